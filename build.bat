@@ -2,7 +2,9 @@
 echo 正在编译端口代理管理器...
 set GOOS=windows
 set GOARCH=amd64
-go build -ldflags="-H windowsgui" -o portproxy-manager.exe main.go
+set VERSION=dev
+for /f %%v in ('git describe --tags --always --dirty 2^>nul') do set VERSION=%%v
+go build -ldflags="-H windowsgui -X main.version=%VERSION%" -o portproxy-manager.exe .
 if %errorlevel% neq 0 (
     echo 编译失败！
     pause
@@ -0,0 +1,10 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+// isSharingViolation is always false on non-Windows platforms, where opening
+// a file is not blocked by other processes holding it open
+func isSharingViolation(err error) bool {
+	return false
+}
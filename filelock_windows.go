@@ -0,0 +1,16 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isSharingViolation reports whether err means another process holds the
+// file open without sharing it (ERROR_SHARING_VIOLATION / ERROR_LOCK_VIOLATION)
+func isSharingViolation(err error) bool {
+	var errno syscall.Errno
+	return errors.As(err, &errno) && (errno == 32 || errno == 33)
+}
@@ -1,38 +1,121 @@
 package main
 
 import (
+	"archive/zip"
 	"bufio"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
+	"maps"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Config represents application configuration
 type Config struct {
-	Port              int    `json:"port"`
-	FrpcTomlPath      string `json:"frpcTomlPath"`
-	FrpcExePath       string `json:"frpcExePath"`
-	AutoRegisterToFrp bool   `json:"autoRegisterToFrp"`
-	WebUIProxyName    string `json:"webUIProxyName"`
-	WebUIRemotePort   int    `json:"webUIRemotePort"`
-	Name              string `json:"name"`
+	Port               int    `json:"port"`
+	FrpcTomlPath       string `json:"frpcTomlPath"`
+	FrpcExePath        string `json:"frpcExePath"`
+	AutoRegisterToFrp  bool   `json:"autoRegisterToFrp"`
+	WebUIProxyName     string `json:"webUIProxyName"`
+	WebUIRemotePort    int    `json:"webUIRemotePort"`
+	Name               string `json:"name"`
+	MaxBodyBytes       int64  `json:"maxBodyBytes"`
+	AuditMaxBytes      int64  `json:"auditMaxBytes"`
+	AuthToken          string `json:"authToken"`
+	LogBufferLines     int    `json:"logBufferLines"`
+	ReadTimeoutSecs    int    `json:"readTimeoutSeconds"`
+	WriteTimeoutSecs   int    `json:"writeTimeoutSeconds"`
+	IdleTimeoutSecs    int    `json:"idleTimeoutSeconds"`
+	NameSeparator      string `json:"nameSeparator"`
+	NamePrefixPattern  string `json:"namePrefixPattern"`
+	CommandTimeoutSecs int    `json:"commandTimeoutSeconds"`
+	// ScheduledRestartCron is "HH:MM" or a five-field cron expression;
+	// empty or "off" disables scheduled restarts
+	ScheduledRestartCron string `json:"scheduledRestartCron"`
+	// WatchToml restarts frpc when frpc.toml is edited outside the manager
+	WatchToml bool `json:"watchToml"`
+	// LocalOnly binds the web UI to 127.0.0.1 so it is reachable only from
+	// this machine and through the frp web UI proxy, never from the LAN.
+	// Every tunnelled request then arrives from 127.0.0.1, so loopback can no
+	// longer be trusted and authToken becomes the only protection; the
+	// recommended setup is localOnly together with authToken.
+	LocalOnly bool `json:"localOnly"`
+	// FrpcPriority is the Windows priority class frpc starts with: idle,
+	// below_normal, normal, above_normal or high; empty leaves the default
+	FrpcPriority string `json:"frpcPriority"`
+	// FrpcCPUAffinity is a bit mask of the CPUs frpc may run on; 0 means all
+	FrpcCPUAffinity uint64 `json:"frpcCpuAffinity"`
+	// FrpcExtraArgs are appended to "frpc -c <frpcTomlPath>", e.g.
+	// ["--strict_config"]
+	FrpcExtraArgs []string `json:"frpcExtraArgs"`
+	// LegacyResponses keeps the pre-envelope response shapes (API version 1)
+	// for clients that do not send X-API-Version or ?apiVersion=
+	LegacyResponses bool `json:"legacyResponses"`
+	// ValidateConnectAddr checks that connectAddr resolves before a rule is
+	// added: "warn" reports problems, "block" refuses the rule, empty or
+	// "off" skips the check. ProbeConnectAddr also TCP-probes the target.
+	ValidateConnectAddr string `json:"validateConnectAddr"`
+	ProbeConnectAddr    bool   `json:"probeConnectAddr"`
+	// Presets are named AddRuleRequest templates for /api/add/from-preset,
+	// e.g. {"rdp": {"type": "tcp", "listenPort": "3389", ...}}
+	Presets map[string]AddRuleRequest `json:"presets"`
+	// StatusCacheMs is how long /api/frpc/status reuses a result before
+	// querying the process list again; 0 uses the default, negative disables
+	StatusCacheMs int `json:"statusCacheMs"`
+	// ManagedProxiesFile moves the proxies this tool manages into a separate
+	// file pulled in by frpc.toml's includes; relative paths are resolved
+	// against frpc.toml's directory. Empty keeps them in frpc.toml.
+	ManagedProxiesFile string `json:"managedProxiesFile"`
+	// RemotePortPool lists ports and ranges ("20000-20099") that an add
+	// request without remotePort is assigned the next free port from
+	RemotePortPool []string `json:"remotePortPool"`
+	// AlwaysRestartOnEdit disables the check that skips or reloads instead
+	// of restarting frpc when an edit leaves the running proxies untouched
+	AlwaysRestartOnEdit bool `json:"alwaysRestartOnEdit"`
 }
 
 // Rule represents a portproxy rule
 type Rule struct {
-	ListenAddress  string `json:"listenAddress"`
-	ListenPort     string `json:"listenPort"`
-	ConnectAddress string `json:"connectAddress"`
-	ConnectPort    string `json:"connectPort"`
+	ListenAddress  string    `json:"listenAddress"`
+	ListenPort     string    `json:"listenPort"`
+	ConnectAddress string    `json:"connectAddress"`
+	ConnectPort    string    `json:"connectPort"`
+	Meta           *RuleMeta `json:"meta,omitempty"`
+	// Numeric forms of the ports; 0 if netsh printed something non-numeric
+	ListenPortNumber  int `json:"listenPortNumber"`
+	ConnectPortNumber int `json:"connectPortNumber"`
+	// Family is the portproxy table the rule was listed in, e.g. v4tov4
+	Family string `json:"family"`
+	// Protocol is the forwarded protocol; portproxy only supports tcp
+	Protocol string `json:"protocol"`
 }
 
 // FrpProxy represents a proxy configuration in frpc.toml
@@ -42,6 +125,19 @@ type FrpProxy struct {
 	LocalIP    string `json:"localIP"`
 	LocalPort  string `json:"localPort"`
 	RemotePort string `json:"remotePort"`
+	Group      string `json:"group,omitempty"`
+	GroupKey   string `json:"groupKey,omitempty"`
+	// CustomDomains is set for http/https proxies
+	CustomDomains []string `json:"customDomains,omitempty"`
+	// ProxyProtocolVersion is "v1" or "v2" when the backend receives the
+	// client address via PROXY protocol
+	ProxyProtocolVersion string `json:"proxyProtocolVersion,omitempty"`
+	// Tags come from a "# tags: a,b" comment in the proxy block
+	Tags []string `json:"tags,omitempty"`
+	// SecretKey is shared with the visitors of stcp/xtcp/sudp proxies
+	SecretKey string `json:"secretKey,omitempty"`
+	// Extra holds keys the manager does not model, with raw TOML values
+	Extra map[string]string `json:"extra,omitempty"`
 }
 
 // AddRuleRequest represents the JSON payload for adding a rule
@@ -53,10 +149,72 @@ type AddRuleRequest struct {
 	Type        string `json:"type"`
 	Name        string `json:"name"`
 	Manager     string `json:"manager"`
+	Description string `json:"description"`
+	Group       string `json:"group"`
+	GroupKey    string `json:"groupKey"`
+	// Tags are stored as a "# tags: a,b" comment in the proxy block
+	Tags []string `json:"tags"`
+	// ListenAddresses creates one netsh rule per IPv4 address instead of a
+	// single 0.0.0.0 rule
+	ListenAddresses []string `json:"listenAddresses"`
+	// LocalAddr is the proxy's local service as "host:port", an alternative
+	// to listenPort (tcp, host must be loopback) or connectAddr/connectPort (udp)
+	LocalAddr string `json:"localAddr"`
+	// LinkNetshPort points the new proxy at an existing netsh rule listening
+	// on this port instead of creating a new rule
+	LinkNetshPort string `json:"linkNetshPort"`
+	// ExtraConfig is written verbatim into the proxy block as key = value,
+	// e.g. {"healthCheck.type": "\"tcp\""}; values are raw TOML
+	ExtraConfig map[string]string `json:"extraConfig"`
+	// HealthCheckType enables frp's backend health check ("tcp" or "http")
+	HealthCheckType            string `json:"healthCheckType"`
+	HealthCheckIntervalSeconds int    `json:"healthCheckIntervalSeconds"`
+	HealthCheckTimeoutSeconds  int    `json:"healthCheckTimeoutSeconds"`
+	// HealthCheckPath is the URL path probed by http checks, "/" by default
+	HealthCheckPath string `json:"healthCheckPath"`
+	// ProxyProtocolVersion ("v1" or "v2") sends the client address to the
+	// backend using PROXY protocol
+	ProxyProtocolVersion string `json:"proxyProtocolVersion"`
+	// IgnoreConnectCheck adds the rule even when validateConnectAddr is
+	// "block" and the connect address check failed
+	IgnoreConnectCheck bool `json:"ignoreConnectCheck"`
+	// Family is the portproxy table for the netsh rule (v4tov4 by default)
+	// and Protocol its protocol (tcp, the only one portproxy supports)
+	Family   string `json:"family"`
+	Protocol string `json:"protocol"`
+	// SecretKey is required for stcp/xtcp/sudp, which visitors reach with
+	// the same key instead of through a remotePort
+	SecretKey string `json:"secretKey"`
 }
 
+// defaultMaxBodyBytes caps JSON request bodies when maxBodyBytes is not configured
+const defaultMaxBodyBytes = 1 << 20
+
+// Default HTTP server timeouts, used when the config leaves them unset
+const (
+	defaultReadTimeout  = 15 * time.Second
+	defaultWriteTimeout = 30 * time.Second
+	defaultIdleTimeout  = 120 * time.Second
+)
+
+// frpcPriorities are the accepted frpcPriority values
+var frpcPriorities = []string{"idle", "below_normal", "normal", "above_normal", "high"}
+
+// frpcLogFile receives frpc's stdout and stderr
+const frpcLogFile = "frpc.log"
+
+// defaultCommandTimeout bounds external commands such as netsh and tasklist
+const defaultCommandTimeout = 30 * time.Second
+
 var (
 	config Config
+
+	// startTime records when the manager process started
+	startTime = time.Now()
+
+	// version is the manager's build version, set with
+	// -ldflags "-X main.version=..."
+	version = "dev"
 )
 
 // corsMiddleware adds CORS headers to all responses
@@ -64,7 +222,8 @@ func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Version")
+		w.Header().Set("Access-Control-Expose-Headers", errorCodeHeader)
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -75,9 +234,196 @@ func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// authMiddleware guards sensitive endpoints. With authToken configured the
+// request must carry "Authorization: Bearer <token>"; without it only
+// clients on the local machine are allowed, and only while the web UI is not
+// published through frp.
+func authMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if config.AuthToken != "" {
+			given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(given), []byte(config.AuthToken)) != 1 {
+				writeMsgError(w, r, http.StatusUnauthorized, "unauthorized")
+				return
+			}
+		} else if config.LocalOnly {
+			// Tunnelled requests look local too, so loopback proves nothing
+			writeMsgError(w, r, http.StatusForbidden, "local_only_needs_token")
+			return
+		} else if webUIExposed() {
+			// frpc forwards the web UI proxy from 127.0.0.1, so every request
+			// from the internet looks local
+			writeMsgError(w, r, http.StatusForbidden, "webui_exposed_needs_token")
+			return
+		} else if ip := net.ParseIP(clientIP(r)); ip == nil || !ip.IsLoopback() {
+			writeMsgError(w, r, http.StatusForbidden, "local_only")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// gzipMinSize is the smallest response body worth compressing
+const gzipMinSize = 1024
+
+// gzipSkipPaths are streaming endpoints that must not be buffered
+var gzipSkipPaths = map[string]bool{
+	"/api/frpc/logs/stream": true,
+}
+
+// gzipResponseWriter buffers the first gzipMinSize bytes of a response and
+// then decides whether to compress it; smaller bodies are sent as-is
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz      *gzip.Writer
+	buf     []byte
+	status  int
+	decided bool
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if g.status == 0 {
+		g.status = status
+	}
+}
+
+func (g *gzipResponseWriter) Write(p []byte) (int, error) {
+	if g.decided {
+		if g.gz != nil {
+			return g.gz.Write(p)
+		}
+		return g.ResponseWriter.Write(p)
+	}
+	g.buf = append(g.buf, p...)
+	if len(g.buf) >= gzipMinSize {
+		if err := g.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// decide sends the headers, compressing if allowed, and flushes the buffer
+func (g *gzipResponseWriter) decide(compress bool) error {
+	g.decided = true
+	h := g.Header()
+	if g.status == 0 {
+		g.status = http.StatusOK
+	}
+	if compress && h.Get("Content-Encoding") == "" && g.status != http.StatusPartialContent {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		g.gz = gzip.NewWriter(g.ResponseWriter)
+	}
+	g.ResponseWriter.WriteHeader(g.status)
+
+	buf := g.buf
+	g.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	if g.gz != nil {
+		_, err := g.gz.Write(buf)
+		return err
+	}
+	_, err := g.ResponseWriter.Write(buf)
+	return err
+}
+
+// Flush commits to the current decision so partial output reaches the client
+func (g *gzipResponseWriter) Flush() {
+	if !g.decided {
+		g.decide(false)
+	}
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+	http.NewResponseController(g.ResponseWriter).Flush()
+}
+
+// close finishes the response once the handler returns
+func (g *gzipResponseWriter) close() {
+	if !g.decided {
+		g.decide(false)
+	}
+	if g.gz != nil {
+		g.gz.Close()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (g *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+// gzipMiddleware compresses responses for clients that accept gzip, skipping
+// streaming endpoints, range requests and bodies under gzipMinSize
+func gzipMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if gzipSkipPaths[r.URL.Path] || r.Header.Get("Range") != "" ||
+			!strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") ||
+			strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// decodeJSONBody decodes the JSON request body into v, limiting how much is
+// read. It replies with 413 for oversized bodies and 400 for malformed ones,
+// returning false if the handler should stop.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	limit := config.MaxBodyBytes
+	if limit <= 0 {
+		limit = defaultMaxBodyBytes
+	}
+
+	// Reject early when the client announces an oversized body
+	if r.ContentLength > limit {
+		writeMsgError(w, r, http.StatusRequestEntityTooLarge, "body_too_large")
+		return false
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			writeMsgError(w, r, http.StatusRequestEntityTooLarge, "body_too_large")
+			return false
+		}
+		writeError(w, r, err, http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
 func main() {
+	noRegister := flag.Bool("no-register", false, "本次运行不自动将 Web UI 注册到 frpc.toml")
+	configDir := flag.String("config-dir", "", "从该目录按文件名顺序加载并合并所有 *.json 配置")
+	showVersion := flag.Bool("version", false, "打印版本号并退出")
+	encryptConfig := flag.String("encrypt-config", "", "使用环境变量 CONFIG_KEY 加密指定的配置文件并退出")
+	flag.Parse()
+
+	if *encryptConfig != "" {
+		if err := encryptConfigFile(*encryptConfig); err != nil {
+			log.Fatalf("加密配置文件失败: %v", err)
+		}
+		fmt.Printf("已加密 %s，启动前请设置环境变量 %s\n", *encryptConfig, configKeyEnv)
+		return
+	}
+
+	if *showVersion {
+		fmt.Printf("portproxy-manager %s (%s %s/%s)\n", version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+		return
+	}
+
 	// Load configuration
-	if err := loadConfig(); err != nil {
+	if err := loadConfig(*configDir); err != nil {
 		log.Printf("Warning: Failed to load config.json, using defaults: %v", err)
 		config = Config{
 			Port:              8080,
@@ -90,664 +436,9391 @@ func main() {
 		}
 	}
 
+	frpcLogRing = newLineRing(config.LogBufferLines)
+	loadPauseState()
+
+	if config.FrpcPriority != "" && !slices.Contains(frpcPriorities, config.FrpcPriority) {
+		log.Printf("警告: 无效的 frpcPriority %q (可选 %s)，将使用默认优先级", config.FrpcPriority, strings.Join(frpcPriorities, ", "))
+		config.FrpcPriority = ""
+	}
+	if err := validateFrpcExtraArgs(config.FrpcExtraArgs); err != nil {
+		log.Printf("警告: %v，将忽略 frpcExtraArgs", err)
+		config.FrpcExtraArgs = nil
+	}
+	if _, err := parsePortPool(config.RemotePortPool); err != nil {
+		log.Printf("警告: %v，自动分配远程端口将不可用", err)
+	}
+	switch config.ValidateConnectAddr {
+	case "", "off", "warn", "block":
+	default:
+		log.Printf("警告: 无效的 validateConnectAddr %q (可选 off、warn、block)，已关闭检查", config.ValidateConnectAddr)
+		config.ValidateConnectAddr = ""
+	}
+	if runtime.GOOS != "windows" && (config.FrpcPriority != "" || config.FrpcCPUAffinity != 0) {
+		log.Println("提示: frpcPriority 和 frpcCpuAffinity 仅在 Windows 上生效")
+	}
+
+	// Make sure the configured frpc executable can actually be launched
+	if path, found := probeFrpcExe(); !found {
+		log.Printf("警告: 未找到 frpc 可执行文件: %s (请检查 config.json 中的 frpcExePath)", config.FrpcExePath)
+	} else if path != config.FrpcExePath {
+		log.Printf("警告: 配置的 frpc 路径 %s 不存在，将使用 PATH 中的 %s", config.FrpcExePath, path)
+	}
+
+	if err := applyServerEnvOverrides(); err != nil {
+		log.Printf("警告: 应用 frps 环境变量覆盖失败: %v", err)
+	}
+	checkFrpcToml()
+
 	// Auto-register web UI to frpc.toml if enabled
+	if *noRegister {
+		config.AutoRegisterToFrp = false
+		log.Println("已通过 -no-register 参数禁用 Web UI 自动注册")
+	} else {
+		log.Printf("Web UI 自动注册: %v (来自配置)", config.AutoRegisterToFrp)
+	}
 	if config.AutoRegisterToFrp {
 		if err := registerWebUIToFrpc(); err != nil {
 			log.Printf("Warning: Failed to register web UI to frpc.toml: %v", err)
 		}
 	}
 
+	startRestartScheduler(config.ScheduledRestartCron)
+	if config.WatchToml {
+		startTomlWatcher()
+	}
+
 	// Serve static files
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, "index.html")
 	})
 
+	// Liveness probe for the manager itself, independent of frpc
+	http.HandleFunc("/healthz", handleHealthz)
+	http.HandleFunc("/api/version", corsMiddleware(handleVersion))
+
 	// API endpoints with CORS middleware
 	http.HandleFunc("/api/rules", corsMiddleware(handleGetRules))
-	http.HandleFunc("/api/add", corsMiddleware(handleAddRule))
-	http.HandleFunc("/api/netsh/delete", corsMiddleware(handleDeleteNetshRule))
+	http.HandleFunc("/api/rules/get", corsMiddleware(handleGetRulesByPort))
+	http.HandleFunc("/api/rules/preview-add", corsMiddleware(handlePreviewAddRule))
+	http.HandleFunc("/api/rules/raw", corsMiddleware(authMiddleware(handleGetRulesRaw)))
+	http.HandleFunc("/api/rules/edit", corsMiddleware(auditMiddleware(handleEditNetshRule)))
+	http.HandleFunc("/api/add", corsMiddleware(auditMiddleware(handleAddRule)))
+	http.HandleFunc("/api/add/range", corsMiddleware(auditMiddleware(handleAddRange)))
+	http.HandleFunc("/api/add/from-preset", corsMiddleware(auditMiddleware(handleAddFromPreset)))
+	http.HandleFunc("/api/services/ports", corsMiddleware(handleServicePorts))
+	http.HandleFunc("/api/services/forward", corsMiddleware(auditMiddleware(handleForwardService)))
+	http.HandleFunc("/api/presets", corsMiddleware(handleGetPresets))
+	http.HandleFunc("/api/netsh/delete", corsMiddleware(auditMiddleware(handleDeleteNetshRule)))
+	http.HandleFunc("/api/netsh/prune", corsMiddleware(auditMiddleware(handlePruneNetshRules)))
+	http.HandleFunc("/api/netsh/iphlpsvc", corsMiddleware(auditMiddleware(handleIPHelper)))
+	http.HandleFunc("/api/sync-and-restart", corsMiddleware(auditMiddleware(handleSyncAndRestart)))
 	http.HandleFunc("/api/default-name", corsMiddleware(handleGetDefaultName))
 	http.HandleFunc("/api/frp-proxies", corsMiddleware(handleGetFrpProxies))
-	http.HandleFunc("/api/frp-proxies/delete", corsMiddleware(handleDeleteFrpProxy))
-	http.HandleFunc("/api/frpc/start", corsMiddleware(handleStartFrpc))
-	http.HandleFunc("/api/frpc/stop", corsMiddleware(handleStopFrpc))
-	http.HandleFunc("/api/frpc/restart", corsMiddleware(handleRestartFrpc))
+	http.HandleFunc("/api/frp-proxies/grouped", corsMiddleware(handleGetGroupedFrpProxies))
+	http.HandleFunc("/api/frp-proxies/check-name", corsMiddleware(handleCheckProxyName))
+	http.HandleFunc("/api/frp-visitors", corsMiddleware(handleGetFrpVisitors))
+	http.HandleFunc("/api/frp-visitors/add", corsMiddleware(auditMiddleware(handleAddFrpVisitor)))
+	http.HandleFunc("/api/frp-visitors/delete", corsMiddleware(auditMiddleware(handleDeleteFrpVisitor)))
+	http.HandleFunc("/api/frp-proxies/delete", corsMiddleware(auditMiddleware(handleDeleteFrpProxy)))
+	http.HandleFunc("/api/frp-proxies/reorder", corsMiddleware(auditMiddleware(handleReorderFrpProxies)))
+	http.HandleFunc("/api/frp-proxies/stats/reset", corsMiddleware(auditMiddleware(handleResetProxyStats)))
+	http.HandleFunc("/api/frp-proxies/copy", corsMiddleware(auditMiddleware(handleCopyFrpProxy)))
+	http.HandleFunc("/api/frp-proxies/apply", corsMiddleware(auditMiddleware(handleApplyFrpProxies)))
+	http.HandleFunc("/api/frp-proxies/tags", corsMiddleware(auditMiddleware(handleSetFrpProxyTags)))
+	http.HandleFunc("/api/frp-proxies/rename", corsMiddleware(auditMiddleware(handleRenameFrpProxy)))
+	http.HandleFunc("/api/frpc/start", corsMiddleware(auditMiddleware(handleStartFrpc)))
+	http.HandleFunc("/api/frpc/stop", corsMiddleware(auditMiddleware(handleStopFrpc)))
+	http.HandleFunc("/api/frpc/restart", corsMiddleware(auditMiddleware(handleRestartFrpc)))
+	http.HandleFunc("/api/frpc/restart-if-running", corsMiddleware(auditMiddleware(handleRestartFrpcIfRunning)))
+	http.HandleFunc("/api/frpc/apply-pending", corsMiddleware(auditMiddleware(handleApplyPending)))
 	http.HandleFunc("/api/frpc/status", corsMiddleware(handleFrpcStatus))
+	http.HandleFunc("/api/pause", corsMiddleware(auditMiddleware(handlePause)))
+	http.HandleFunc("/api/resume", corsMiddleware(auditMiddleware(handleResume)))
+	http.HandleFunc("/api/frpc/health", corsMiddleware(handleFrpcHealth))
+	http.HandleFunc("/api/frpc/tail", corsMiddleware(handleFrpcTail))
+	http.HandleFunc("/api/frpc/logs/stream", corsMiddleware(handleFrpcLogStream))
+	http.HandleFunc("/api/frpc/logs/clear", corsMiddleware(authMiddleware(auditMiddleware(handleFrpcLogClear))))
+	http.HandleFunc("/api/frpc/normalize", corsMiddleware(auditMiddleware(handleNormalizeFrpcToml)))
+	http.HandleFunc("/api/frpc/repair", corsMiddleware(auditMiddleware(handleRepairFrpcToml)))
+	http.HandleFunc("/api/frpc/update-check", corsMiddleware(handleFrpcUpdateCheck))
+	http.HandleFunc("/api/frpc/capabilities", corsMiddleware(handleFrpcCapabilities))
+	http.HandleFunc("/api/frpc/try", corsMiddleware(authMiddleware(handleTryFrpcToml)))
+	http.HandleFunc("/api/webui-proxy", corsMiddleware(auditMiddleware(handleWebUIProxy)))
+	http.HandleFunc("/api/frp-server", corsMiddleware(auditMiddleware(handleFrpServer)))
+	http.HandleFunc("/api/frp-server/token", corsMiddleware(auditMiddleware(handleFrpServerToken)))
+	http.HandleFunc("/api/frpc/config", corsMiddleware(authMiddleware(handleExportFrpcConfig)))
+	http.HandleFunc("/api/frpc/admin", corsMiddleware(handleFrpcAdminConfig))
+	http.HandleFunc("/api/frpc/admin-check", corsMiddleware(authMiddleware(handleFrpcAdminCheck)))
+	http.HandleFunc("/api/frpc/log-level", corsMiddleware(auditMiddleware(handleFrpcLogLevel)))
+	http.HandleFunc("/api/frpc/signal", corsMiddleware(auditMiddleware(handleFrpcSignal)))
+	http.HandleFunc("/api/test-chain", corsMiddleware(handleTestChain))
+	http.HandleFunc("/api/network-info", corsMiddleware(handleNetworkInfo))
+	http.HandleFunc("/api/forwarding-map", corsMiddleware(handleGetForwardingMap))
+	http.HandleFunc("/api/selftest", corsMiddleware(handleSelfTest))
+	http.HandleFunc("/api/summary", corsMiddleware(handleGetSummary))
+	http.HandleFunc("/api/verify-public", corsMiddleware(handleVerifyPublic))
+	http.HandleFunc("/api/audit", corsMiddleware(handleGetAudit))
+	http.HandleFunc("/api/files/status", corsMiddleware(handleFilesStatus))
+	http.HandleFunc("/api/support-bundle", corsMiddleware(authMiddleware(handleSupportBundle)))
+
+	host := ""
+	if config.LocalOnly {
+		host = "127.0.0.1"
+		if !config.AutoRegisterToFrp {
+			log.Println("警告: localOnly 已启用但未注册 Web UI 到 frp，只能在本机访问管理界面")
+		}
+		if config.AuthToken == "" {
+			log.Println("警告: localOnly 模式下通过 frp 访问的请求都来自 127.0.0.1，建议同时配置 authToken")
+		}
+	} else if config.AuthToken == "" {
+		log.Println("提示: 管理界面对局域网开放且未配置 authToken，建议启用 localOnly 并配置 authToken")
+		if config.AutoRegisterToFrp {
+			log.Println("警告: Web UI 已注册到 frp 且未配置 authToken，敏感接口将拒绝所有请求")
+		}
+	}
 
-	addr := fmt.Sprintf(":%d", config.Port)
+	server := &http.Server{
+		Addr:         net.JoinHostPort(host, strconv.Itoa(config.Port)),
+		Handler:      gzipMiddleware(envelopeMiddleware(http.DefaultServeMux)),
+		ReadTimeout:  secondsOrDefault(config.ReadTimeoutSecs, defaultReadTimeout),
+		WriteTimeout: secondsOrDefault(config.WriteTimeoutSecs, defaultWriteTimeout),
+		IdleTimeout:  secondsOrDefault(config.IdleTimeoutSecs, defaultIdleTimeout),
+	}
 	log.Printf("服务器启动在 http://localhost:%d", config.Port)
-	log.Fatal(http.ListenAndServe(addr, nil))
+	log.Fatal(server.ListenAndServe())
+}
+
+// secondsOrDefault converts a configured number of seconds to a duration,
+// falling back to def when the value is not positive
+func secondsOrDefault(seconds int, def time.Duration) time.Duration {
+	if seconds <= 0 {
+		return def
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// disableWriteDeadline lifts the server's WriteTimeout for long-lived
+// streaming responses
+func disableWriteDeadline(w http.ResponseWriter) {
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("警告: 无法取消写超时: %v", err)
+	}
+}
+
+// configFiles are the config files loadConfig read, in merge order
+var configFiles = []string{"config.json"}
+
+// loadConfig loads config.json and then config.local.json, if present, on top
+// of it. With configDir set, every *.json in that directory is merged in name
+// order instead. Later files only override the keys they contain.
+func loadConfig(configDir string) error {
+	var files []string
+	if configDir != "" {
+		matches, err := filepath.Glob(filepath.Join(configDir, "*.json"))
+		if err != nil {
+			return err
+		}
+		if len(matches) == 0 {
+			return fmt.Errorf("目录 %s 中没有 *.json 配置文件", configDir)
+		}
+		sort.Strings(matches)
+		files = matches
+	} else {
+		files = []string{"config.json"}
+		if _, err := os.Stat("config.local.json"); err == nil {
+			files = append(files, "config.local.json")
+		}
+	}
+
+	configFiles = files
+	for _, path := range files {
+		if err := mergeConfigFile(path); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		log.Printf("已加载配置文件 %s", path)
+	}
+
+	if effective, err := json.Marshal(redactConfig(config)); err == nil {
+		log.Printf("生效配置: %s", effective)
+	}
+	return nil
 }
 
-func loadConfig() error {
-	file, err := os.Open("config.json")
+// mergeConfigFile decodes a config file over the current config, decrypting
+// it first if it was written by -encrypt-config
+func mergeConfigFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	content, err = decryptConfig(content)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	decoder := json.NewDecoder(file)
+	decoder := json.NewDecoder(bytes.NewReader(content))
 	return decoder.Decode(&config)
 }
 
+// configKeyEnv names the environment variable holding the passphrase for
+// encrypted config files
+const configKeyEnv = "CONFIG_KEY"
+
+// encryptedConfigMarker is the "encrypted" value of an encrypted config file
+const encryptedConfigMarker = "aes-256-gcm"
+
+// configKDFIterations is the PBKDF2-SHA256 work factor for new encrypted
+// config files; the count is stored in the file so it can be raised later
+const configKDFIterations = 600000
+
+// maxConfigKDFIterations bounds the count read from a file so a corrupted
+// one cannot stall startup
+const maxConfigKDFIterations = 10000000
+
+// EncryptedConfig is the on-disk form of an encrypted config file; Data is
+// the sealed plaintext JSON
+type EncryptedConfig struct {
+	Encrypted  string `json:"encrypted"`
+	Salt       string `json:"salt"`
+	Iterations int    `json:"iterations"`
+	Nonce      string `json:"nonce"`
+	Data       string `json:"data"`
+}
+
+// configCipher builds the AES-256-GCM cipher from CONFIG_KEY, stretched with
+// PBKDF2-SHA256 over salt
+func configCipher(salt []byte, iterations int) (cipher.AEAD, error) {
+	passphrase := os.Getenv(configKeyEnv)
+	if passphrase == "" {
+		return nil, fmt.Errorf("未设置环境变量 %s", configKeyEnv)
+	}
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, iterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// decryptConfig returns the plaintext of an encrypted config file, or content
+// unchanged when it is a plain config
+func decryptConfig(content []byte) ([]byte, error) {
+	var enc EncryptedConfig
+	if json.Unmarshal(content, &enc) != nil || enc.Encrypted == "" {
+		return content, nil
+	}
+	if enc.Encrypted != encryptedConfigMarker {
+		return nil, fmt.Errorf("不支持的加密方式: %s", enc.Encrypted)
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(enc.Salt)
+	if err != nil || len(salt) == 0 {
+		return nil, fmt.Errorf("无效的 salt")
+	}
+	if enc.Iterations < 1 || enc.Iterations > maxConfigKDFIterations {
+		return nil, fmt.Errorf("无效的 iterations: %d", enc.Iterations)
+	}
+	gcm, err := configCipher(salt, enc.Iterations)
+	if err != nil {
+		return nil, fmt.Errorf("配置文件已加密: %v", err)
+	}
+	nonce, err := base64.StdEncoding.DecodeString(enc.Nonce)
+	if err != nil || len(nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("无效的 nonce")
+	}
+	data, err := base64.StdEncoding.DecodeString(enc.Data)
+	if err != nil {
+		return nil, fmt.Errorf("无效的加密数据: %v", err)
+	}
+	plain, err := gcm.Open(nil, nonce, data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("解密失败，请检查 %s 是否正确", configKeyEnv)
+	}
+	return plain, nil
+}
+
+// encryptConfigFile replaces a plain config file with its encrypted form
+func encryptConfigFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var enc EncryptedConfig
+	if json.Unmarshal(content, &enc) == nil && enc.Encrypted != "" {
+		return fmt.Errorf("%s 已经是加密的配置文件", path)
+	}
+	var check Config
+	if err := json.Unmarshal(content, &check); err != nil {
+		return fmt.Errorf("%s 不是有效的配置文件: %v", path, err)
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	gcm, err := configCipher(salt, configKDFIterations)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	out, err := json.MarshalIndent(EncryptedConfig{
+		Encrypted:  encryptedConfigMarker,
+		Salt:       base64.StdEncoding.EncodeToString(salt),
+		Iterations: configKDFIterations,
+		Nonce:      base64.StdEncoding.EncodeToString(nonce),
+		Data:       base64.StdEncoding.EncodeToString(gcm.Seal(nil, nonce, content, nil)),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write next to the original and rename so a failure never leaves a
+	// truncated config behind
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(out, '\n'), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// redactConfig returns the config as a map with secret-looking values masked,
+// suitable for logging
+func redactConfig(c Config) map[string]interface{} {
+	var m map[string]interface{}
+	content, _ := json.Marshal(c)
+	json.Unmarshal(content, &m)
+
+	for key, value := range m {
+		if value != "" && isSecretKey(key) {
+			m[key] = "***"
+		}
+	}
+	return m
+}
+
+// isSecretKey reports whether a config or request field name looks like it
+// holds a credential
+func isSecretKey(key string) bool {
+	lower := strings.ToLower(key)
+	return strings.Contains(lower, "token") || strings.Contains(lower, "password") ||
+		strings.Contains(lower, "secret") || strings.HasSuffix(lower, "key")
+}
+
 func registerWebUIToFrpc() error {
-	// Check if already registered
-	proxies, err := getFrpProxies()
+	if err := ensureProxiesInclude(); err != nil {
+		return err
+	}
+
+	// Check if already registered, in either file
+	proxies, err := allFrpProxies()
 	if err != nil {
 		return err
 	}
 
 	// The actual proxy name that will be written
-	webUIProxyFullName := config.Name + "-" + config.WebUIProxyName
+	proxyName := webUIProxyFullName()
 
 	for _, p := range proxies {
-		if p.Name == webUIProxyFullName {
-			log.Printf("Web UI 已经注册到 frpc.toml (名称: %s)", webUIProxyFullName)
+		if p.Name == proxyName {
+			log.Printf("Web UI 已经注册到 frpc.toml (名称: %s)", proxyName)
 			return nil
 		}
 	}
 
 	// Register
-	f, err := os.OpenFile(config.FrpcTomlPath, os.O_APPEND|os.O_WRONLY, 0644)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
 	var sb strings.Builder
 	sb.WriteString("\n[[proxies]]\n")
-	sb.WriteString(fmt.Sprintf("name = \"%s\"\n", webUIProxyFullName))
+	sb.WriteString(fmt.Sprintf("name = %s\n", tomlQuote(proxyName)))
 	sb.WriteString("type = \"tcp\"\n")
 	sb.WriteString("localIP = \"127.0.0.1\"\n")
 	sb.WriteString(fmt.Sprintf("localPort = %d\n", config.Port))
 	sb.WriteString(fmt.Sprintf("remotePort = %d\n", config.WebUIRemotePort))
 
-	if _, err := io.WriteString(f, sb.String()); err != nil {
+	if err := appendProxiesToml(sb.String()); err != nil {
 		return err
 	}
 
-	log.Printf("Web UI 已自动注册到 frpc.toml (名称: %s, 远程端口: %d)", webUIProxyFullName, config.WebUIRemotePort)
+	log.Printf("Web UI 已自动注册到 frpc.toml (名称: %s, 远程端口: %d)", proxyName, config.WebUIRemotePort)
 	return nil
 }
 
-func handleGetRules(w http.ResponseWriter, r *http.Request) {
-	rules, err := getNetshRules()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(rules)
-}
-
-func handleGetDefaultName(w http.ResponseWriter, r *http.Request) {
-	name := config.Name
-	if name == "" {
-		name = getFirstProxyName()
+// webUIExposed reports whether the web UI is, or will be at startup,
+// published through frp. A proxy list that cannot be read counts as exposed.
+func webUIExposed() bool {
+	if config.AutoRegisterToFrp {
+		return true
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"name": name})
+	taken, err := frpProxyNameTaken(webUIProxyFullName())
+	return err != nil || taken
 }
 
-func handleGetFrpProxies(w http.ResponseWriter, r *http.Request) {
-	proxies, err := getFrpProxies()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(proxies)
+// webUIProxyFullName returns the name of the proxy that exposes the web UI
+func webUIProxyFullName() string {
+	return config.Name + "-" + config.WebUIProxyName
 }
 
-func handleDeleteFrpProxy(w http.ResponseWriter, r *http.Request) {
+// handleWebUIProxy registers or unregisters the web UI proxy on demand
+func handleWebUIProxy(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	var req struct {
-		Name string `json:"name"`
+		Action string `json:"action"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !decodeJSONBody(w, r, &req) {
 		return
 	}
 
-	if err := deleteFrpProxy(req.Name); err != nil {
-		http.Error(w, "删除 FRP 代理失败: "+err.Error(), http.StatusInternalServerError)
+	switch req.Action {
+	case "register":
+		if err := registerWebUIToFrpc(); err != nil {
+			writeMsgError(w, r, http.StatusInternalServerError, "webui_register_failed", err)
+			return
+		}
+	case "unregister":
+		if err := deleteFrpProxy(webUIProxyFullName()); err != nil {
+			writeMsgError(w, r, http.StatusInternalServerError, "webui_unregister_failed", err)
+			return
+		}
+		log.Printf("Web UI 代理已从 frpc.toml 移除 (名称: %s)", webUIProxyFullName())
+	default:
+		writeMsgError(w, r, http.StatusBadRequest, "invalid_webui_action")
 		return
 	}
 
 	// Restart frpc
-	if err := restartFrpc(); err != nil {
-		log.Printf("警告: 重启 frpc 失败: %v", err)
-	}
+	restart := restartAfterEdit(r)
 
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "success", "restart": restart})
 }
 
-func handleAddRule(w http.ResponseWriter, r *http.Request) {
-	var req AddRuleRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":        "ok",
+		"uptimeSeconds": int64(time.Since(startTime).Seconds()),
+	})
+}
 
-	// 1. Add netsh rule
-	if err := addNetshRule(req.ListenPort, req.ConnectAddr, req.ConnectPort); err != nil {
-		http.Error(w, "添加 netsh 规则失败: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"version":   version,
+		"goVersion": runtime.Version(),
+		"os":        runtime.GOOS,
+		"arch":      runtime.GOARCH,
+	})
+}
 
-	// 2. Append to frpc.toml
-	if err := appendToFrpc(req); err != nil {
-		http.Error(w, "更新 frpc.toml 失败: "+err.Error(), http.StatusInternalServerError)
+func handleGetRules(w http.ResponseWriter, r *http.Request) {
+	rules, duplicates, err := listNetshRules()
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
 		return
 	}
-
-	// 3. Restart frpc
-	if err := restartFrpc(); err != nil {
-		log.Printf("警告: 重启 frpc 失败: %v", err)
-		// Don't fail the request, just log the warning
+	attachRulesMeta(rules)
+	if by := r.URL.Query().Get("sort"); by != "" {
+		if err := sortRules(rules, by); err != nil {
+			writeError(w, r, err, http.StatusBadRequest)
+			return
+		}
 	}
-
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	w.Header().Set("X-Netsh-Duplicates", strconv.Itoa(duplicates))
+	w.Header().Set("Content-Type", "application/json")
+	writeJSONArray(w, rules)
 }
 
-func handleDeleteNetshRule(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+func handleGetRulesByPort(w http.ResponseWriter, r *http.Request) {
+	listenPort := r.URL.Query().Get("listenPort")
+	if listenPort == "" {
+		writeMsgError(w, r, http.StatusBadRequest, "missing_listen_port")
 		return
 	}
 
-	var req struct {
-		ListenPort string `json:"listenPort"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	rules, err := getNetshRules()
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
-	if err := deleteNetshRule(req.ListenPort); err != nil {
-		http.Error(w, "删除 netsh 规则失败: "+err.Error(), http.StatusInternalServerError)
-		return
+	matches := []Rule{}
+	for _, rule := range rules {
+		if rule.ListenPort == listenPort {
+			matches = append(matches, rule)
+		}
 	}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(matches)
 }
 
-func getNetshRules() ([]Rule, error) {
-	if runtime.GOOS != "windows" {
-		return mockRules(), nil
-	}
+// ForwardingEntry describes the full path from a public frp port to the
+// backend service, including any netsh hop in between
+type ForwardingEntry struct {
+	Name       string `json:"name"`
+	RemotePort string `json:"remotePort"`
+	Via        string `json:"via"`
+	Backend    string `json:"backend"`
+}
 
-	cmd := exec.Command("netsh", "interface", "portproxy", "show", "all")
-	hideWindow(cmd)
-	output, err := cmd.Output()
+func handleGetForwardingMap(w http.ResponseWriter, r *http.Request) {
+	proxies, err := getFrpProxies()
 	if err != nil {
-		return nil, err
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	rules, err := getNetshRules()
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	entries := []ForwardingEntry{}
+	for _, p := range proxies {
+		local := net.JoinHostPort(p.LocalIP, p.LocalPort)
+		entry := ForwardingEntry{Name: p.Name, RemotePort: p.RemotePort, Backend: local}
+
+		// If a netsh rule listens on the proxy's local port, frp only reaches
+		// the backend through it
+		for _, rule := range rules {
+			if rule.ListenPort != p.LocalPort {
+				continue
+			}
+			if rule.ListenAddress == "0.0.0.0" || rule.ListenAddress == p.LocalIP {
+				entry.Via = local
+				entry.Backend = net.JoinHostPort(rule.ConnectAddress, rule.ConnectPort)
+				break
+			}
+		}
+		entries = append(entries, entry)
 	}
 
-	return parseNetshOutput(string(output)), nil
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
 }
 
-func addNetshRule(listenPort, connectAddr, connectPort string) error {
-	if runtime.GOOS != "windows" {
-		log.Printf("[模拟] netsh interface portproxy add v4tov4 listenaddress=0.0.0.0 listenport=%s connectaddress=%s connectport=%s", listenPort, connectAddr, connectPort)
-		return nil
+// handlePreviewAddRule returns the netsh command /api/add would run for the
+// given parameters, without running it
+func handlePreviewAddRule(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	listenPort := query.Get("listenPort")
+	connectAddr := query.Get("connectAddr")
+	connectPort := query.Get("connectPort")
+	if listenPort == "" || connectAddr == "" || connectPort == "" {
+		writeMsgError(w, r, http.StatusBadRequest, "missing_rule_params")
+		return
 	}
 
-	cmd := exec.Command("netsh", "interface", "portproxy", "add", "v4tov4",
-		"listenaddress=0.0.0.0",
-		"listenport="+listenPort,
-		"connectaddress="+connectAddr,
-		"connectport="+connectPort,
-	)
-	hideWindow(cmd)
-	return cmd.Run()
+	family := query.Get("family")
+	if family == "" {
+		family = "v4tov4"
+	}
+	if !slices.Contains(netshFamilies, family) {
+		writeMsgError(w, r, http.StatusBadRequest, "invalid_family", family, strings.Join(netshFamilies, ", "))
+		return
+	}
+
+	args := netshAddFamilyArgs(family, familyListenAddress(family), listenPort, connectAddr, connectPort)
+	preview := map[string]interface{}{
+		"command": "netsh " + strings.Join(args, " "),
+		"args":    append([]string{"netsh"}, args...),
+	}
+	if policy := config.ValidateConnectAddr; policy != "" && policy != "off" {
+		preview["connectCheck"] = checkConnectAddr(connectAddr, connectPort, config.ProbeConnectAddr)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(preview)
 }
 
-func deleteNetshRule(listenPort string) error {
-	if runtime.GOOS != "windows" {
-		log.Printf("[模拟] netsh interface portproxy delete v4tov4 listenaddress=0.0.0.0 listenport=%s", listenPort)
-		return nil
+// countFrpcTomlTables counts the [[proxies]] and [[visitors]] entries in
+// frpc.toml and the managed proxies file without parsing them
+func countFrpcTomlTables() (proxies, visitors int, err error) {
+	paths := []string{config.FrpcTomlPath}
+	if path := proxiesTomlPath(); path != config.FrpcTomlPath {
+		paths = append(paths, path)
 	}
 
-	cmd := exec.Command("netsh", "interface", "portproxy", "delete", "v4tov4",
-		"listenaddress=0.0.0.0",
-		"listenport="+listenPort,
-	)
-	hideWindow(cmd)
-	return cmd.Run()
+	for i, path := range paths {
+		file, err := os.Open(path)
+		if i > 0 && os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			switch strings.TrimSpace(scanner.Text()) {
+			case "[[proxies]]":
+				proxies++
+			case "[[visitors]]":
+				visitors++
+			}
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return 0, 0, err
+		}
+	}
+	return proxies, visitors, nil
 }
 
-func parseNetshOutput(output string) []Rule {
-	var rules []Rule
-	lines := strings.Split(output, "\n")
+func handleGetSummary(w http.ResponseWriter, r *http.Request) {
+	summary := map[string]interface{}{}
 
-	for _, line := range lines {
-		fields := strings.Fields(line)
-		if len(fields) == 4 {
-			// Filter out headers (both English and Chinese)
-			// English headers: "Address", "Listen", "---------------"
-			// Chinese headers: "侦听", "地址", "端口", "连接到", "ipv4:"
-			if fields[0] == "Address" ||
-				fields[0] == "---------------" ||
-				strings.HasPrefix(fields[0], "Listen") ||
-				fields[0] == "侦听" ||
-				fields[0] == "地址" ||
-				strings.Contains(line, "ipv4:") {
-				continue
+	if rules, err := getNetshRules(); err != nil {
+		summary["netshError"] = err.Error()
+	} else {
+		summary["netshRuleCount"] = len(rules)
+	}
+
+	if proxies, visitors, err := countFrpcTomlTables(); err != nil {
+		summary["frpError"] = err.Error()
+	} else {
+		summary["frpProxyCount"] = proxies
+		summary["frpVisitorCount"] = visitors
+	}
+
+	process, err := getFrpcProcess()
+	summary["frpcRunning"] = err == nil && process != nil
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
+
+func handleGetDefaultName(w http.ResponseWriter, r *http.Request) {
+	name := config.Name
+	if name == "" {
+		name = getFirstProxyName()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"name": name})
+}
+
+func handleGetFrpProxies(w http.ResponseWriter, r *http.Request) {
+	proxies, err := getFrpProxies()
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if tag := r.URL.Query().Get("tag"); tag != "" {
+		filtered := []FrpProxy{}
+		for _, p := range proxies {
+			for _, t := range p.Tags {
+				if t == tag {
+					filtered = append(filtered, p)
+					break
+				}
 			}
-			rules = append(rules, Rule{
-				ListenAddress:  fields[0],
-				ListenPort:     fields[1],
-				ConnectAddress: fields[2],
-				ConnectPort:    fields[3],
-			})
 		}
+		proxies = filtered
 	}
-	return rules
+	if typ := r.URL.Query().Get("type"); typ != "" {
+		typ = normalizeProxyType(typ)
+		proxies = slices.DeleteFunc(proxies, func(p FrpProxy) bool { return p.Type != typ })
+	}
+	w.Header().Set("Content-Type", "application/json")
+	writeJSONArray(w, redactFrpProxies(groupFrpProxies(proxies)))
 }
 
-func mockRules() []Rule {
-	return []Rule{
-		{"0.0.0.0", "8080", "192.168.1.10", "80"},
-		{"0.0.0.0", "2222", "192.168.1.11", "22"},
+// redactedSecret replaces secret values in listings; a client that sends it
+// back unchanged keeps the stored value
+const redactedSecret = "***"
+
+// redactFrpProxies masks secretKey and loadBalancer.groupKey in place
+func redactFrpProxies(proxies []FrpProxy) []FrpProxy {
+	for i := range proxies {
+		if proxies[i].SecretKey != "" {
+			proxies[i].SecretKey = redactedSecret
+		}
+		if proxies[i].GroupKey != "" {
+			proxies[i].GroupKey = redactedSecret
+		}
 	}
+	return proxies
 }
 
-func getFrpProxies() ([]FrpProxy, error) {
-	file, err := os.Open(config.FrpcTomlPath)
+// ProxyTypeGroup is one type's entry in /api/frp-proxies/grouped
+type ProxyTypeGroup struct {
+	Count   int        `json:"count"`
+	Proxies []FrpProxy `json:"proxies"`
+}
+
+// handleGetGroupedFrpProxies returns the proxies grouped by type, each group
+// in file order; types lists the groups in order of first appearance
+func handleGetGroupedFrpProxies(w http.ResponseWriter, r *http.Request) {
+	proxies, err := getFrpProxies()
 	if err != nil {
-		return nil, err
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
 	}
-	defer file.Close()
 
-	var proxies []FrpProxy
-	scanner := bufio.NewScanner(file)
+	types := []string{}
+	groups := make(map[string]*ProxyTypeGroup)
+	for _, p := range redactFrpProxies(proxies) {
+		group, ok := groups[p.Type]
+		if !ok {
+			group = &ProxyTypeGroup{}
+			groups[p.Type] = group
+			types = append(types, p.Type)
+		}
+		group.Count++
+		group.Proxies = append(group.Proxies, p)
+	}
 
-	var current *FrpProxy
-	reName := regexp.MustCompile(`^\s*name\s*=\s*"(.*)"`)
-	reType := regexp.MustCompile(`^\s*type\s*=\s*"(.*)"`)
-	reLocalIP := regexp.MustCompile(`^\s*localIP\s*=\s*"(.*)"`)
-	reLocalPort := regexp.MustCompile(`^\s*localPort\s*=\s*(\d+)`)
-	reRemotePort := regexp.MustCompile(`^\s*remotePort\s*=\s*(\d+)`)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"total":  len(proxies),
+		"types":  types,
+		"groups": groups,
+	})
+}
 
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
+// writeJSONArray encodes items one element at a time so large listings are
+// streamed instead of being marshaled into a single buffer first
+func writeJSONArray[T any](w io.Writer, items []T) error {
+	out := bufio.NewWriter(w)
+	out.WriteByte('[')
+	for i := range items {
+		if i > 0 {
+			out.WriteByte(',')
+		}
+		content, err := json.Marshal(items[i])
+		if err != nil {
+			return err
+		}
+		out.Write(content)
+	}
+	out.WriteString("]\n")
+	return out.Flush()
+}
 
-		if line == "[[proxies]]" {
-			if current != nil {
-				proxies = append(proxies, *current)
-			}
-			current = &FrpProxy{}
+// handleSetFrpProxyTags replaces a proxy's tags, or with merge adds to them
+func handleSetFrpProxyTags(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Name  string   `json:"name"`
+		Tags  []string `json:"tags"`
+		Merge bool     `json:"merge"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if err := validateTags(req.Tags); err != nil {
+		writeError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	tags, err := setFrpProxyTags(req.Name, req.Tags, req.Merge)
+	if err != nil {
+		writeMsgError(w, r, http.StatusBadRequest, "tags_update_failed", err)
+		return
+	}
+
+	// Tags are comments, so frpc does not need a restart
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "success", "tags": tags})
+}
+
+// setFrpProxyTags rewrites the tags comment of the named proxy and returns
+// the resulting tags
+func setFrpProxyTags(name string, tags []string, merge bool) ([]string, error) {
+	lines, eol, err := readProxiesToml()
+	if err != nil {
+		return nil, err
+	}
+
+	blocks := splitTomlBlocks(lines)
+	for i, block := range blocks {
+		if !block.Proxy || block.Name != name {
 			continue
 		}
 
-		if current != nil {
-			if matches := reName.FindStringSubmatch(line); len(matches) > 1 {
-				current.Name = matches[1]
-			} else if matches := reType.FindStringSubmatch(line); len(matches) > 1 {
-				current.Type = matches[1]
-			} else if matches := reLocalIP.FindStringSubmatch(line); len(matches) > 1 {
-				current.LocalIP = matches[1]
-			} else if matches := reLocalPort.FindStringSubmatch(line); len(matches) > 1 {
-				current.LocalPort = matches[1]
-			} else if matches := reRemotePort.FindStringSubmatch(line); len(matches) > 1 {
-				current.RemotePort = matches[1]
+		var kept, existing []string
+		for _, line := range block.Lines {
+			if old, ok := parseTagsComment(line); ok {
+				existing = append(existing, old...)
+				continue
 			}
+			kept = append(kept, line)
 		}
+		if merge {
+			tags = append(existing, tags...)
+		}
+
+		result := []string{}
+		seen := make(map[string]bool)
+		for _, tag := range tags {
+			tag = strings.TrimSpace(tag)
+			if !seen[tag] {
+				seen[tag] = true
+				result = append(result, tag)
+			}
+		}
+
+		if comment := tagsComment(result); comment != "" {
+			kept = append([]string{kept[0], comment}, kept[1:]...)
+		}
+		blocks[i].Lines = kept
+		return result, writeProxiesToml(joinTomlBlocks(blocks), eol)
 	}
+	return nil, msgError("proxy_not_found", name)
+}
 
-	// Add last proxy
-	if current != nil {
-		proxies = append(proxies, *current)
+// reNameLine splits a proxy's name line around the quoted value so a rename
+// keeps indentation and any trailing comment
+var reNameLine = regexp.MustCompile(`^(\s*name\s*=\s*)"(?:[^"\\]|\\.)*"(.*)$`)
+
+// renameFrpProxy changes the name key of a proxy block in place, leaving every
+// other line of the block untouched
+func renameFrpProxy(oldName, newName string) error {
+	if taken, err := frpProxyNameTaken(newName); err != nil {
+		return err
+	} else if taken {
+		return msgError("proxy_name_taken", newName)
+	}
+	lines, eol, err := readProxiesToml()
+	if err != nil {
+		return err
 	}
 
-	return proxies, nil
+	blocks := splitTomlBlocks(lines)
+	found := -1
+	for i, block := range blocks {
+		if !block.Proxy {
+			continue
+		}
+		switch block.Name {
+		case newName:
+			return msgError("proxy_name_taken", newName)
+		case oldName:
+			found = i
+		}
+	}
+	if found < 0 {
+		return msgError("proxy_not_found", oldName)
+	}
+
+	for j, line := range blocks[found].Lines {
+		if m := reNameLine.FindStringSubmatch(line); m != nil {
+			blocks[found].Lines[j] = m[1] + tomlQuote(newName) + m[2]
+			break
+		}
+	}
+	return writeProxiesToml(joinTomlBlocks(blocks), eol)
 }
 
-func getFirstProxyName() string {
-	file, err := os.Open(config.FrpcTomlPath)
-	if err != nil {
-		return ""
+// handleRenameFrpProxy renames a proxy, keeps its netsh link and restarts frpc
+func handleRenameFrpProxy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
-	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	inProxies := false
-	reName := regexp.MustCompile(`^\s*name\s*=\s*"(.*)"`)
+	var req struct {
+		OldName string `json:"oldName"`
+		NewName string `json:"newName"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	req.NewName = strings.TrimSpace(req.NewName)
+	switch {
+	case req.OldName == "" || req.NewName == "":
+		writeMsgError(w, r, http.StatusBadRequest, "missing_rename_names")
+		return
+	case req.OldName == webUIProxyFullName():
+		writeMsgError(w, r, http.StatusBadRequest, "rename_webui_proxy")
+		return
+	}
+	if err := validateProxyName("newName", req.NewName); err != nil {
+		writeError(w, r, err, http.StatusBadRequest)
+		return
+	}
 
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "[[proxies]]" {
-			inProxies = true
+	if err := renameFrpProxy(req.OldName, req.NewName); err != nil {
+		writeMsgError(w, r, http.StatusBadRequest, "rename_failed", err)
+		return
+	}
+	for _, rule := range linkedRules(req.OldName) {
+		linkRuleMeta(rule.ListenAddress, rule.ListenPort, req.NewName)
+	}
+	log.Printf("代理已重命名: %s -> %s", req.OldName, req.NewName)
+
+	restart := restartAfterEdit(r)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "success", "name": req.NewName, "restart": restart})
+}
+
+// groupFrpProxies moves proxies of the same load-balancing group next to each
+// other (at the position of the group's first member), keeping file order otherwise
+func groupFrpProxies(proxies []FrpProxy) []FrpProxy {
+	grouped := make([]FrpProxy, 0, len(proxies))
+	done := make(map[string]bool)
+	for i, p := range proxies {
+		if p.Group == "" {
+			grouped = append(grouped, p)
 			continue
 		}
-		if inProxies {
-			matches := reName.FindStringSubmatch(line)
-			if len(matches) > 1 {
-				// Found the first name
-				parts := strings.Split(matches[1], "-")
-				if len(parts) > 0 {
-					return parts[0] // Return the prefix (e.g., "yzwj")
-				}
-				return matches[1]
+		if done[p.Group] {
+			continue
+		}
+		done[p.Group] = true
+		for _, q := range proxies[i:] {
+			if q.Group == p.Group {
+				grouped = append(grouped, q)
 			}
 		}
 	}
-	return ""
+	return grouped
 }
 
-func appendToFrpc(req AddRuleRequest) error {
-	f, err := os.OpenFile(config.FrpcTomlPath, os.O_APPEND|os.O_WRONLY, 0644)
-	if err != nil {
-		return err
+func handleDeleteFrpProxy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
-	defer f.Close()
 
-	// New naming convention: [name]-[manager]-[connectAddr]-[connectPort]
-	// Name is optional
-	var proxyName string
-	if req.Name != "" {
-		proxyName = fmt.Sprintf("%s-%s-%s-%s", req.Name, req.Manager, req.ConnectAddr, req.ConnectPort)
-	} else {
-		proxyName = fmt.Sprintf("%s-%s-%s", req.Manager, req.ConnectAddr, req.ConnectPort)
+	var req struct {
+		Name string `json:"name"`
+		// Cascade also deletes the netsh rule linked to the proxy
+		Cascade bool `json:"cascade"`
+		// Reason is optional and ends up in the audit log
+		Reason string `json:"reason"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if len(req.Reason) > maxAuditReasonLen {
+		writeMsgError(w, r, http.StatusBadRequest, "reason_too_long", maxAuditReasonLen)
+		return
 	}
 
-	var sb strings.Builder
-	sb.WriteString("\n[[proxies]]\n")
-	sb.WriteString(fmt.Sprintf("name = \"%s\"\n", proxyName))
-	sb.WriteString("type = \"tcp\"\n")
-	sb.WriteString("localIP = \"127.0.0.1\"\n")
-	sb.WriteString(fmt.Sprintf("localPort = %s\n", req.ListenPort))
-	sb.WriteString(fmt.Sprintf("remotePort = %s\n", req.RemotePort))
+	if err := deleteFrpProxy(req.Name); err != nil {
+		writeMsgError(w, r, http.StatusInternalServerError, "proxy_delete_failed", err)
+		return
+	}
+	if req.Reason != "" {
+		log.Printf("已删除代理 %s，原因: %s", req.Name, req.Reason)
+	}
 
-	if _, err := io.WriteString(f, sb.String()); err != nil {
-		return err
+	result := map[string]interface{}{"status": "success"}
+	if linked := linkedRules(req.Name); len(linked) > 0 {
+		var keys []string
+		cascadeErrors := make(map[string]string)
+		for _, rule := range linked {
+			key := ruleKey(rule.ListenAddress, rule.ListenPort)
+			keys = append(keys, key)
+			if !req.Cascade {
+				linkRuleMeta(rule.ListenAddress, rule.ListenPort, "")
+				continue
+			}
+			if err := deleteNetshRuleOn(rule.Family, rule.ListenAddress, rule.ListenPort); err != nil {
+				log.Printf("警告: 删除关联的 netsh 规则 %s 失败: %v", key, err)
+				cascadeErrors[key] = err.Error()
+				continue
+			}
+			forgetRuleMeta(rule.ListenAddress, rule.ListenPort)
+		}
+		result["linkedRule"] = keys[0]
+		result["linkedRules"] = keys
+		if req.Cascade {
+			result["cascaded"] = len(cascadeErrors) == 0
+			if len(cascadeErrors) > 0 {
+				result["cascadeErrors"] = cascadeErrors
+			}
+		}
+	}
+
+	// Restart frpc
+	result["restart"] = restartAfterEdit(r)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
+
+func handleReorderFrpProxies(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Names []string `json:"names"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+
+	if err := reorderFrpProxies(req.Names); err != nil {
+		writeMsgError(w, r, http.StatusBadRequest, "reorder_failed", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+// handleApplyFrpProxies converges frpc.toml to the posted list of proxies
+// (the same shape /api/frp-proxies returns) in one write and one restart
+func handleApplyFrpProxies(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Proxies []FrpProxy `json:"proxies"`
+		DryRun  bool       `json:"dryRun"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+
+	lines, eol, err := readProxiesToml()
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if err := restoreRedactedSecrets(req.Proxies); err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	applied, actions, err := applyFrpProxies(lines, req.Proxies)
+	if err != nil {
+		writeError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	result := map[string]interface{}{
+		"status":  "success",
+		"dryRun":  req.DryRun,
+		"actions": actions,
+		"diff":    lineDiff(lines, applied),
+	}
+	if !req.DryRun && len(actions) > 0 {
+		// A managed proxies file that does not exist yet has nothing to back up
+		backupPath, err := backupTomlFile(proxiesTomlPath())
+		if err != nil && !os.IsNotExist(err) {
+			writeMsgError(w, r, http.StatusInternalServerError, "backup_toml_failed", err)
+			return
+		}
+		if err := writeProxiesToml(applied, eol); err != nil {
+			writeMsgError(w, r, http.StatusInternalServerError, "write_toml_failed", err)
+			return
+		}
+		result["backup"] = backupPath
+		result["restart"] = restartAfterEdit(r)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func handleCopyFrpProxy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		SourceName    string `json:"sourceName"`
+		NewName       string `json:"newName"`
+		NewRemotePort string `json:"newRemotePort"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+
+	if err := copyFrpProxy(req.SourceName, req.NewName, req.NewRemotePort); err != nil {
+		writeMsgError(w, r, http.StatusBadRequest, "proxy_copy_failed", err)
+		return
+	}
+
+	// Restart frpc
+	restart := restartAfterEdit(r)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "success", "restart": restart})
+}
+
+// validateTomlString rejects characters that could terminate a TOML string
+// value early and inject extra keys or tables: quotes, backslashes and
+// control characters such as newlines
+func validateTomlString(field, value string) error {
+	for _, c := range value {
+		if c == '"' || c == '\\' || c < 0x20 || c == 0x7f {
+			return msgError("invalid_chars", field, c)
+		}
+	}
+	return nil
+}
+
+// maxProxyNameLen caps proxy names; frps shows them in its dashboard and
+// logs, and anything longer is almost certainly a mistake
+const maxProxyNameLen = 200
+
+// validateProxyName applies the naming rules enforced whenever a proxy name
+// is written: non-empty, at most maxProxyNameLen bytes, no characters that
+// could break out of the TOML string
+func validateProxyName(field, name string) error {
+	if strings.TrimSpace(name) == "" {
+		return msgError("field_empty", field)
+	}
+	if len(name) > maxProxyNameLen {
+		return msgError("field_too_long", field, len(name), maxProxyNameLen)
+	}
+	return validateTomlString(field, name)
+}
+
+// frpProxyNameTaken reports whether a proxy called name already exists
+func frpProxyNameTaken(name string) (bool, error) {
+	proxies, err := allFrpProxies()
+	if err != nil {
+		return false, err
+	}
+	return slices.ContainsFunc(proxies, func(p FrpProxy) bool { return p.Name == name }), nil
+}
+
+// handleCheckProxyName reports whether ?name= would be accepted as a new
+// proxy name, so the UI can warn before submitting
+func handleCheckProxyName(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	result := map[string]interface{}{"name": name, "valid": true, "available": true}
+	if err := validateProxyName("name", name); err != nil {
+		result["valid"] = false
+		result["available"] = false
+		result["reason"] = errText(r, err)
+	} else if taken, err := frpProxyNameTaken(name); err != nil {
+		writeMsgError(w, r, http.StatusInternalServerError, "proxy_read_failed", err)
+		return
+	} else if taken {
+		result["available"] = false
+		result["reason"] = msg(r, "proxy_name_taken", name)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// validatePort checks that value is a port number in 1-65535
+func validatePort(field, value string) error {
+	port, err := strconv.Atoi(value)
+	if err != nil || port < 1 || port > 65535 {
+		return msgError("invalid_port", field, value)
+	}
+	return nil
+}
+
+// validateAddRuleRequest checks every field that ends up in frpc.toml or on
+// the netsh command line
+func validateAddRuleRequest(req AddRuleRequest) error {
+	for field, value := range map[string]string{
+		"name":        req.Name,
+		"manager":     req.Manager,
+		"connectAddr": req.ConnectAddr,
+		"description": req.Description,
+		"group":       req.Group,
+		"groupKey":    req.GroupKey,
+	} {
+		if err := validateTomlString(field, value); err != nil {
+			return err
+		}
+	}
+	if req.ConnectAddr == "" || strings.ContainsAny(req.ConnectAddr, " =") {
+		return msgError("invalid_connect_addr", req.ConnectAddr)
+	}
+	ports := map[string]string{
+		"listenPort":  req.ListenPort,
+		"connectPort": req.ConnectPort,
+		"remotePort":  req.RemotePort,
+	}
+	if slices.Contains(secretProxyTypes, req.Type) {
+		// Visitors reach these through frps without a public port
+		if req.RemotePort != "" {
+			return msgError("secret_no_remote_port", req.Type)
+		}
+		if req.SecretKey == "" {
+			return msgError("secret_key_required", req.Type)
+		}
+		delete(ports, "remotePort")
+	}
+	if err := validateTomlString("secretKey", req.SecretKey); err != nil {
+		return err
+	}
+	for field, value := range ports {
+		if err := validatePort(field, value); err != nil {
+			return err
+		}
+	}
+	if err := validateHealthCheck(req); err != nil {
+		return err
+	}
+	if v := req.ProxyProtocolVersion; v != "" && v != "v1" && v != "v2" {
+		return msgError("invalid_proxy_protocol", v)
+	}
+	if err := validateTags(req.Tags); err != nil {
+		return err
+	}
+	if req.Family != "" && !slices.Contains(netshFamilies, req.Family) {
+		return msgError("invalid_family", req.Family, strings.Join(netshFamilies, ", "))
+	}
+	if req.Protocol != "" && req.Protocol != "tcp" {
+		return msgError("tcp_only", req.Protocol)
+	}
+	if strings.HasPrefix(req.Family, "v6") && len(req.ListenAddresses) > 0 {
+		return msgError("listen_addresses_v4_only", req.Family)
+	}
+	seen := make(map[string]bool)
+	for _, addr := range req.ListenAddresses {
+		if ip := net.ParseIP(addr); ip == nil || ip.To4() == nil {
+			return msgError("invalid_listen_address", addr)
+		}
+		if seen[addr] {
+			return msgError("duplicate_listen_address", addr)
+		}
+		seen[addr] = true
+	}
+	return validateExtraConfig(req.ExtraConfig)
+}
+
+// applyLocalAddr splits req.LocalAddr into the fields that become the proxy's
+// localIP/localPort. Separately supplied fields must agree with it.
+func applyLocalAddr(req *AddRuleRequest) error {
+	if req.LocalAddr == "" {
+		return nil
+	}
+	host, port, err := net.SplitHostPort(req.LocalAddr)
+	if err != nil {
+		return msgError("invalid_local_addr", req.LocalAddr, err)
+	}
+	if err := validatePort("localAddr", port); err != nil {
+		return err
+	}
+
+	if isUDPProxyType(req.Type) {
+		if (req.ConnectAddr != "" && req.ConnectAddr != host) || (req.ConnectPort != "" && req.ConnectPort != port) {
+			return msgError("local_addr_connect_mismatch", req.LocalAddr)
+		}
+		req.ConnectAddr, req.ConnectPort = host, port
+		return nil
+	}
+
+	// tcp proxies reach the backend through the local netsh listener
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return msgError("local_addr_not_loopback", req.LocalAddr)
+	}
+	if req.ListenPort != "" && req.ListenPort != port {
+		return msgError("local_addr_port_mismatch", req.LocalAddr, "listenPort", req.ListenPort)
+	}
+	if req.LinkNetshPort != "" && req.LinkNetshPort != port {
+		return msgError("local_addr_port_mismatch", req.LocalAddr, "linkNetshPort", req.LinkNetshPort)
+	}
+	req.ListenPort = port
+	return nil
+}
+
+// validateHealthCheck checks the optional health check settings and makes
+// sure extraConfig does not set the same keys
+func validateHealthCheck(req AddRuleRequest) error {
+	if req.HealthCheckType == "" {
+		if req.HealthCheckIntervalSeconds != 0 || req.HealthCheckTimeoutSeconds != 0 || req.HealthCheckPath != "" {
+			return msgError("health_check_type_required")
+		}
+		return nil
+	}
+	if req.HealthCheckType != "tcp" && req.HealthCheckType != "http" {
+		return msgError("unsupported_health_check", req.HealthCheckType)
+	}
+	if req.HealthCheckIntervalSeconds != 0 && (req.HealthCheckIntervalSeconds < 1 || req.HealthCheckIntervalSeconds > 3600) {
+		return msgError("invalid_health_check_interval")
+	}
+	if req.HealthCheckTimeoutSeconds != 0 && (req.HealthCheckTimeoutSeconds < 1 || req.HealthCheckTimeoutSeconds > 60) {
+		return msgError("invalid_health_check_timeout")
+	}
+	if req.HealthCheckIntervalSeconds != 0 && req.HealthCheckTimeoutSeconds > req.HealthCheckIntervalSeconds {
+		return msgError("health_check_timeout_too_long")
+	}
+	if req.HealthCheckPath != "" {
+		if req.HealthCheckType != "http" {
+			return msgError("health_check_path_http_only")
+		}
+		if !strings.HasPrefix(req.HealthCheckPath, "/") {
+			return msgError("health_check_path_slash")
+		}
+		if err := validateTomlString("healthCheckPath", req.HealthCheckPath); err != nil {
+			return err
+		}
+	}
+	for key := range req.ExtraConfig {
+		switch key {
+		case "healthCheck.type", "healthCheck.intervalSeconds", "healthCheck.timeoutSeconds", "healthCheck.path":
+			return msgError("health_check_extra_conflict", key)
+		}
+	}
+	return nil
+}
+
+// tagsCommentPrefix marks the comment line that stores a proxy's tags, which
+// frp itself ignores
+const tagsCommentPrefix = "# tags:"
+
+// validateTags rejects tags that could not round-trip through the comment
+func validateTags(tags []string) error {
+	for _, tag := range tags {
+		if strings.TrimSpace(tag) == "" || strings.Contains(tag, ",") {
+			return msgError("invalid_tag", tag)
+		}
+		if err := validateTomlString("tags", tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tagsComment renders the tags comment line, or "" for no tags
+func tagsComment(tags []string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	trimmed := make([]string, len(tags))
+	for i, tag := range tags {
+		trimmed[i] = strings.TrimSpace(tag)
+	}
+	return tagsCommentPrefix + " " + strings.Join(trimmed, ",")
+}
+
+// parseTagsComment returns the tags of a "# tags:" line
+func parseTagsComment(line string) ([]string, bool) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(line), tagsCommentPrefix)
+	if !ok {
+		return nil, false
+	}
+	var tags []string
+	for _, tag := range strings.Split(rest, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags, true
+}
+
+// managedProxyKeys are written by buildProxyBlock and may not be overridden
+// through ExtraConfig
+var managedProxyKeys = map[string]bool{
+	"name": true, "type": true, "localIP": true, "localPort": true, "remotePort": true,
+	"loadBalancer.group": true, "loadBalancer.groupKey": true,
+	"transport.proxyProtocolVersion": true,
+}
+
+var (
+	reExtraKey    = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]*(\.[A-Za-z][A-Za-z0-9_-]*)*$`)
+	reTomlString  = regexp.MustCompile(`^"([^"\\\x00-\x1f\x7f]|\\["\\btnfr]|\\u[0-9A-Fa-f]{4})*"$`)
+	reTomlNumber  = regexp.MustCompile(`^[+-]?(\d+(_\d+)*)(\.\d+)?([eE][+-]?\d+)?$`)
+	reTomlElement = regexp.MustCompile(`"(?:[^"\\]|\\.)*"|[^,]+`)
+)
+
+// validateExtraConfig checks that every ExtraConfig entry is a dotted bare
+// key with a single-line scalar or flat array value, so it cannot open a
+// new table or spill into the next line
+func validateExtraConfig(extra map[string]string) error {
+	for key, value := range extra {
+		if !reExtraKey.MatchString(key) {
+			return msgError("invalid_extra_key", key)
+		}
+		if managedProxyKeys[key] {
+			return msgError("reserved_extra_key", key)
+		}
+		if !isTomlValue(strings.TrimSpace(value), true) {
+			return msgError("invalid_extra_value", key, value)
+		}
+	}
+	return nil
+}
+
+// isTomlValue accepts basic strings, numbers, booleans and, when allowArray
+// is set, arrays of those
+func isTomlValue(value string, allowArray bool) bool {
+	switch {
+	case value == "true" || value == "false":
+		return true
+	case reTomlString.MatchString(value), reTomlNumber.MatchString(value):
+		return true
+	case allowArray && strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]"):
+		inner := strings.TrimSpace(value[1 : len(value)-1])
+		if inner == "" {
+			return true
+		}
+		elements := reTomlElement.FindAllStringIndex(inner, -1)
+		rest := inner
+		for i := len(elements) - 1; i >= 0; i-- {
+			el := strings.TrimSpace(inner[elements[i][0]:elements[i][1]])
+			if !isTomlValue(el, false) {
+				return false
+			}
+			rest = rest[:elements[i][0]] + rest[elements[i][1]:]
+		}
+		// Only separators may remain once the elements are removed
+		return strings.Trim(rest, ", ") == "" && strings.Count(rest, ",") == len(elements)-1
+	}
+	return false
+}
+
+func handleAddRule(w http.ResponseWriter, r *http.Request) {
+	var req AddRuleRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	addRule(w, r, req)
+}
+
+// addRule validates req, creates its netsh rule and frp proxy and replies
+func addRule(w http.ResponseWriter, r *http.Request, req AddRuleRequest) {
+	req.Type = normalizeProxyType(req.Type)
+	if req.Type == "" {
+		req.Type = "tcp"
+	}
+	if !slices.Contains(frpProxyTypes, req.Type) {
+		writeMsgError(w, r, http.StatusBadRequest, "unknown_proxy_type", req.Type, strings.Join(frpProxyTypes, ", "))
+		return
+	}
+	if req.Type != "tcp" && req.Type != "udp" && !slices.Contains(secretProxyTypes, req.Type) {
+		writeMsgError(w, r, http.StatusBadRequest, "unsupported_add_type", req.Type)
+		return
+	}
+
+	if err := applyLocalAddr(&req); err != nil {
+		writeError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	if req.Family == "" {
+		req.Family = "v4tov4"
+	}
+	if req.Protocol == "" {
+		req.Protocol = "tcp"
+	}
+
+	// Linking to an existing rule takes the listen port and target from it
+	var linked *Rule
+	if req.LinkNetshPort != "" {
+		if isUDPProxyType(req.Type) {
+			writeMsgError(w, r, http.StatusBadRequest, "udp_link_netsh")
+			return
+		}
+		if len(req.ListenAddresses) > 0 {
+			writeMsgError(w, r, http.StatusBadRequest, "link_with_listen_addresses")
+			return
+		}
+		rule, err := findNetshRule(req.LinkNetshPort)
+		if err != nil {
+			writeError(w, r, err, http.StatusBadRequest)
+			return
+		}
+		linked = rule
+		req.ListenPort, req.ConnectAddr, req.ConnectPort = rule.ListenPort, rule.ConnectAddress, rule.ConnectPort
+		if rule.Family != "" {
+			req.Family = rule.Family
+		}
+	}
+
+	assigned, releasePort := "", func() {}
+	if req.RemotePort == "" && len(config.RemotePortPool) > 0 && !slices.Contains(secretProxyTypes, req.Type) {
+		port, release, err := assignRemotePort()
+		if err != nil {
+			writeError(w, r, err, http.StatusConflict)
+			return
+		}
+		// Released once the proxy block is written; the defer covers the
+		// error paths
+		defer release()
+		req.RemotePort, assigned, releasePort = port, port, release
+	}
+
+	if err := validateAddRuleRequest(req); err != nil {
+		writeError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	if err := validateProxyName("name", proxyNameFor(req)); err != nil {
+		writeError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	if taken, err := frpProxyNameTaken(proxyNameFor(req)); err != nil {
+		writeMsgError(w, r, http.StatusInternalServerError, "proxy_read_failed", err)
+		return
+	} else if taken {
+		writeMsgError(w, r, http.StatusConflict, "proxy_name_taken", proxyNameFor(req))
+		return
+	}
+
+	result := map[string]interface{}{"status": "success", "family": req.Family, "protocol": req.Protocol}
+	if assigned != "" {
+		result["remotePort"] = assigned
+	}
+	if policy := config.ValidateConnectAddr; linked == nil && policy != "" && policy != "off" {
+		check := checkConnectAddr(req.ConnectAddr, req.ConnectPort, config.ProbeConnectAddr && !isUDPProxyType(req.Type))
+		result["connectCheck"] = check
+		if !check.OK {
+			log.Printf("警告: connectAddr 检查未通过: %s", check.Detail)
+			if policy == "block" && !req.IgnoreConnectCheck {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"status":       "error",
+					"code":         "connect_check_failed",
+					"message":      msg(r, "connect_check_failed"),
+					"connectCheck": check,
+				})
+				return
+			}
+		}
+	}
+
+	// 1. Add netsh rule. Windows portproxy only forwards TCP, so UDP proxies
+	// skip netsh and point frp straight at the target instead.
+	switch {
+	case isUDPProxyType(req.Type):
+		result["netshSkipped"] = true
+		result["note"] = "Windows portproxy 不支持 UDP 转发，已跳过 netsh 规则，frp 将直接连接目标地址"
+	case linked != nil:
+		result["linkedRule"] = linked
+	case len(req.ListenAddresses) > 0:
+		if err := addNetshRulesOn(req.Family, req.ListenAddresses, req.ListenPort, req.ConnectAddr, req.ConnectPort); err != nil {
+			writeMsgError(w, r, http.StatusInternalServerError, "netsh_add_failed", err)
+			return
+		}
+	default:
+		if err := addNetshFamilyRuleOn(req.Family, familyListenAddress(req.Family), req.ListenPort, req.ConnectAddr, req.ConnectPort); err != nil {
+			writeMsgError(w, r, http.StatusInternalServerError, "netsh_add_failed", err)
+			return
+		}
+	}
+
+	// 2. Append to frpc.toml
+	if err := appendToFrpc(req); err != nil {
+		writeMsgError(w, r, http.StatusInternalServerError, "update_toml_failed", err)
+		return
+	}
+	releasePort()
+	switch {
+	case linked != nil:
+		linkRuleMeta(linked.ListenAddress, linked.ListenPort, proxyNameFor(req))
+	case !isUDPProxyType(req.Type) && len(req.ListenAddresses) > 0:
+		for _, addr := range req.ListenAddresses {
+			recordRuleMeta(req.Family, addr, req.ListenPort, req.Description, clientIP(r), proxyNameFor(req))
+		}
+	case !isUDPProxyType(req.Type):
+		recordRuleMeta(req.Family, familyListenAddress(req.Family), req.ListenPort, req.Description, clientIP(r), proxyNameFor(req))
+	}
+
+	// 3. Restart frpc
+	result["restart"] = restartAfterEdit(r)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
+
+// handleGetPresets lists the configured proxy presets
+func handleGetPresets(w http.ResponseWriter, r *http.Request) {
+	presets := config.Presets
+	if presets == nil {
+		presets = map[string]AddRuleRequest{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(presets)
+}
+
+// handleAddFromPreset adds a rule built from a preset, with any fields in
+// overrides taking precedence over the preset's
+func handleAddFromPreset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body struct {
+		Preset    string          `json:"preset"`
+		Overrides json.RawMessage `json:"overrides"`
+	}
+	if !decodeJSONBody(w, r, &body) {
+		return
+	}
+
+	req, ok := config.Presets[body.Preset]
+	if !ok {
+		writeMsgError(w, r, http.StatusNotFound, "preset_not_found", body.Preset)
+		return
+	}
+	// Unmarshal merges into existing maps, so keep the preset's own intact
+	req.ExtraConfig = maps.Clone(req.ExtraConfig)
+	if len(body.Overrides) > 0 {
+		if err := json.Unmarshal(body.Overrides, &req); err != nil {
+			writeMsgError(w, r, http.StatusBadRequest, "invalid_overrides", err)
+			return
+		}
+	}
+	addRule(w, r, req)
+}
+
+// parsePortPool parses remotePortPool entries ("20000" or "20000-20099")
+// into inclusive ranges
+func parsePortPool(entries []string) ([][2]int, error) {
+	var ranges [][2]int
+	for _, entry := range entries {
+		lo, hi, isRange := strings.Cut(strings.TrimSpace(entry), "-")
+		if !isRange {
+			hi = lo
+		}
+		start, startErr := strconv.Atoi(strings.TrimSpace(lo))
+		end, endErr := strconv.Atoi(strings.TrimSpace(hi))
+		if startErr != nil || endErr != nil || start < 1 || end > 65535 || start > end {
+			return nil, fmt.Errorf("无效的 remotePortPool 项: %q", entry)
+		}
+		ranges = append(ranges, [2]int{start, end})
+	}
+	return ranges, nil
+}
+
+var (
+	remotePortPoolMu sync.Mutex
+	// remotePortsReserved holds ports handed out to adds still in progress
+	remotePortsReserved = make(map[int]bool)
+)
+
+// assignRemotePort picks the first port in remotePortPool that no proxy uses
+// and no concurrent add has reserved. The caller must call release once the
+// proxy is written (or the add failed).
+func assignRemotePort() (string, func(), error) {
+	ranges, err := parsePortPool(config.RemotePortPool)
+	if err != nil {
+		return "", nil, err
+	}
+	// The used set is read under the lock: a port is either still reserved
+	// or, once its reservation is released, already written to the file
+	remotePortPoolMu.Lock()
+	defer remotePortPoolMu.Unlock()
+	proxies, err := allFrpProxies()
+	if err != nil {
+		return "", nil, msgError("proxy_read_failed", err)
+	}
+	used := make(map[int]bool)
+	for _, p := range proxies {
+		if n := portNumber(p.RemotePort); n != 0 {
+			used[n] = true
+		}
+	}
+	for _, r := range ranges {
+		for port := r[0]; port <= r[1]; port++ {
+			if used[port] || remotePortsReserved[port] {
+				continue
+			}
+			remotePortsReserved[port] = true
+			release := sync.OnceFunc(func() {
+				remotePortPoolMu.Lock()
+				delete(remotePortsReserved, port)
+				remotePortPoolMu.Unlock()
+			})
+			return strconv.Itoa(port), release, nil
+		}
+	}
+	return "", nil, msgError("remote_port_pool_exhausted", strings.Join(config.RemotePortPool, ", "))
+}
+
+// AddRangeRequest represents the JSON payload for adding a block of ports
+type AddRangeRequest struct {
+	ListenPortStart  int    `json:"listenPortStart"`
+	ListenPortEnd    int    `json:"listenPortEnd"`
+	ConnectAddr      string `json:"connectAddr"`
+	ConnectPortStart int    `json:"connectPortStart"`
+	RemotePortStart  int    `json:"remotePortStart"`
+	Name             string `json:"name"`
+	Manager          string `json:"manager"`
+	// IgnoreConnectCheck adds the range even if connectAddr fails the check
+	IgnoreConnectCheck bool `json:"ignoreConnectCheck"`
+}
+
+// rangeConflict returns the first generated rule whose listen port, proxy
+// name or remote port is already in use, with the status to answer
+func rangeConflict(reqs []AddRuleRequest) (int, error) {
+	rules, err := getNetshRules()
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	proxies, err := allFrpProxies()
+	if err != nil {
+		return http.StatusInternalServerError, msgError("proxy_read_failed", err)
+	}
+
+	listeners := make(map[string]string)
+	for _, rule := range rules {
+		listeners[rule.ListenPort] = ruleKey(rule.ListenAddress, rule.ListenPort)
+	}
+	names := make(map[string]bool)
+	remotes := make(map[string]string)
+	for _, p := range proxies {
+		names[p.Name] = true
+		if p.RemotePort != "" {
+			remotes[p.RemotePort] = p.Name
+		}
+	}
+
+	for _, add := range reqs {
+		if key, taken := listeners[add.ListenPort]; taken {
+			return http.StatusConflict, msgError("listen_port_taken", add.ListenPort, key)
+		}
+		if names[proxyNameFor(add)] {
+			return http.StatusConflict, msgError("proxy_name_taken", proxyNameFor(add))
+		}
+		if owner, taken := remotes[add.RemotePort]; taken {
+			return http.StatusConflict, msgError("remote_port_taken", add.RemotePort, owner)
+		}
+	}
+	return 0, nil
+}
+
+// rollbackNetshAdds deletes the netsh rules of adds in a single batch
+func rollbackNetshAdds(adds []AddRuleRequest) {
+	var deletes [][]string
+	for _, add := range adds {
+		deletes = append(deletes, netshDeleteArgs("0.0.0.0", add.ListenPort))
+	}
+	results, err := runNetshBatch(deletes)
+	if err != nil {
+		log.Printf("警告: 回滚 netsh 规则失败: %v", err)
+		return
+	}
+	for i, err := range results {
+		if err != nil {
+			log.Printf("警告: 回滚 netsh 规则 %s 失败: %v", adds[i].ListenPort, err)
+		}
+	}
+}
+
+// maxRangeSize caps how many ports a single range request may forward
+const maxRangeSize = 256
+
+func handleAddRange(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req AddRangeRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+
+	// Connect ports default to the listen ports
+	if req.ConnectPortStart == 0 {
+		req.ConnectPortStart = req.ListenPortStart
+	}
+	count := req.ListenPortEnd - req.ListenPortStart + 1
+	switch {
+	case req.ConnectAddr == "":
+		writeMsgError(w, r, http.StatusBadRequest, "missing_connect_addr")
+		return
+	case validateTomlString("connectAddr", req.ConnectAddr) != nil,
+		validateTomlString("name", req.Name) != nil,
+		validateTomlString("manager", req.Manager) != nil,
+		strings.ContainsAny(req.ConnectAddr, " ="):
+		writeMsgError(w, r, http.StatusBadRequest, "invalid_range_chars")
+		return
+	case req.ListenPortStart < 1 || count < 1:
+		writeMsgError(w, r, http.StatusBadRequest, "invalid_listen_range")
+		return
+	case count > maxRangeSize:
+		writeMsgError(w, r, http.StatusBadRequest, "range_too_large", maxRangeSize)
+		return
+	case req.ListenPortEnd > 65535,
+		req.ConnectPortStart < 1 || req.ConnectPortStart+count-1 > 65535,
+		req.RemotePortStart < 1 || req.RemotePortStart+count-1 > 65535:
+		writeMsgError(w, r, http.StatusBadRequest, "range_out_of_bounds")
+		return
+	}
+
+	var reqs []AddRuleRequest
+	for i := 0; i < count; i++ {
+		reqs = append(reqs, AddRuleRequest{
+			ListenPort:  strconv.Itoa(req.ListenPortStart + i),
+			ConnectAddr: req.ConnectAddr,
+			ConnectPort: strconv.Itoa(req.ConnectPortStart + i),
+			RemotePort:  strconv.Itoa(req.RemotePortStart + i),
+			Type:        "tcp",
+			Name:        req.Name,
+			Manager:     req.Manager,
+		})
+	}
+
+	// Check every rule as a single add would, and refuse ports already in use
+	// before touching netsh: adding over an existing listener silently
+	// replaces the user's rule, which a rollback would then delete
+	for _, add := range reqs {
+		if err := validateAddRuleRequest(add); err != nil {
+			writeError(w, r, err, http.StatusBadRequest)
+			return
+		}
+		if err := validateProxyName("name", proxyNameFor(add)); err != nil {
+			writeError(w, r, err, http.StatusBadRequest)
+			return
+		}
+	}
+	if status, err := rangeConflict(reqs); err != nil {
+		writeError(w, r, err, status)
+		return
+	}
+
+	result := map[string]interface{}{"status": "success", "count": count}
+	// All rules share connectAddr, so it is checked (and probed) once, on the
+	// first connect port
+	if policy := config.ValidateConnectAddr; policy != "" && policy != "off" {
+		check := checkConnectAddr(req.ConnectAddr, reqs[0].ConnectPort, config.ProbeConnectAddr)
+		result["connectCheck"] = check
+		if !check.OK {
+			log.Printf("警告: connectAddr 检查未通过: %s", check.Detail)
+			if policy == "block" && !req.IgnoreConnectCheck {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"status":       "error",
+					"code":         "connect_check_failed",
+					"message":      msg(r, "connect_check_failed"),
+					"connectCheck": check,
+				})
+				return
+			}
+		}
+	}
+
+	// 1. Add netsh rules in one batch, undoing the ones added if any fails
+	var adds [][]string
+	for _, add := range reqs {
+		adds = append(adds, netshAddArgs("0.0.0.0", add.ListenPort, add.ConnectAddr, add.ConnectPort))
+	}
+	results, err := runNetshBatch(adds)
+	if err != nil {
+		writeMsgError(w, r, http.StatusInternalServerError, "netsh_batch_add_failed", err)
+		return
+	}
+	var added, failed []AddRuleRequest
+	var firstErr error
+	for i, add := range reqs {
+		if results[i] != nil {
+			failed = append(failed, add)
+			if firstErr == nil {
+				firstErr = results[i]
+			}
+		} else {
+			added = append(added, add)
+		}
+	}
+	if len(failed) > 0 {
+		rollbackNetshAdds(added)
+		writeMsgError(w, r, http.StatusInternalServerError, "netsh_add_port_failed", failed[0].ListenPort, firstErr)
+		return
+	}
+
+	// 2. Append all proxies to frpc.toml in a single write
+	var sb strings.Builder
+	for _, add := range reqs {
+		sb.WriteString(buildProxyBlock(add))
+	}
+	if err := appendProxiesToml(sb.String()); err != nil {
+		rollbackNetshAdds(reqs)
+		writeMsgError(w, r, http.StatusInternalServerError, "update_toml_failed", err)
+		return
+	}
+	for _, add := range reqs {
+		recordRuleMeta("v4tov4", "0.0.0.0", add.ListenPort, "", clientIP(r), proxyNameFor(add))
+	}
+
+	// 3. Restart frpc once for the whole range
+	result["restart"] = restartAfterEdit(r)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
+
+func handleDeleteNetshRule(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ListenPort string `json:"listenPort"`
+		// ListenAddress defaults to the wildcard address of Family
+		ListenAddress string `json:"listenAddress"`
+		// Family defaults to the table recorded when the rule was added
+		Family string `json:"family"`
+		// Cascade also deletes the frp proxy linked to the rule
+		Cascade bool `json:"cascade"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if req.Family != "" && !slices.Contains(netshFamilies, req.Family) {
+		writeMsgError(w, r, http.StatusBadRequest, "invalid_family", req.Family, strings.Join(netshFamilies, ", "))
+		return
+	}
+	if req.ListenAddress == "" {
+		req.ListenAddress = familyListenAddress(req.Family)
+	}
+	if req.Family == "" {
+		req.Family = storedRuleFamily(req.ListenAddress, req.ListenPort)
+	}
+
+	proxyName := linkedProxyName(req.ListenAddress, req.ListenPort)
+	if err := deleteNetshRuleOn(req.Family, req.ListenAddress, req.ListenPort); err != nil {
+		writeMsgError(w, r, http.StatusInternalServerError, "netsh_delete_failed", err)
+		return
+	}
+	forgetRuleMeta(req.ListenAddress, req.ListenPort)
+
+	// The rule is gone from here on, so a failed cascade is reported in the
+	// result rather than as an error, like the proxy-side cascade does
+	result := map[string]interface{}{"status": "success", "netshDeleted": true}
+	if proxyName != "" {
+		result["linkedProxy"] = proxyName
+		if req.Cascade {
+			if err := deleteFrpProxy(proxyName); err != nil {
+				log.Printf("警告: netsh 规则已删除，但删除关联的 FRP 代理 %s 失败: %v", proxyName, err)
+				result["cascaded"] = false
+				result["proxyError"] = errText(r, err)
+			} else {
+				result["restart"] = restartAfterEdit(r)
+				result["cascaded"] = true
+			}
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
+
+// findNetshRule returns the rule listening on listenPort, preferring the
+// 0.0.0.0 listener the add flow creates
+func findNetshRule(listenPort string) (*Rule, error) {
+	rules, err := getNetshRules()
+	if err != nil {
+		return nil, err
+	}
+	var found *Rule
+	for i := range rules {
+		if rules[i].ListenPort != listenPort {
+			continue
+		}
+		if found == nil || rules[i].ListenAddress == "0.0.0.0" {
+			found = &rules[i]
+		}
+	}
+	if found == nil {
+		return nil, msgError("netsh_port_not_found", listenPort)
+	}
+	return found, nil
+}
+
+// netshEditMu serializes rule edits so a delete/re-add pair is not interleaved
+// with another edit of the same table
+var netshEditMu sync.Mutex
+
+// editNetshRule retargets an existing rule by deleting and re-adding it,
+// restoring the original target if the re-add fails
+func editNetshRule(listenAddress, listenPort, newConnectAddr, newConnectPort string) (Rule, error) {
+	netshEditMu.Lock()
+	defer netshEditMu.Unlock()
+
+	rules, err := getNetshRules()
+	if err != nil {
+		return Rule{}, err
+	}
+	var old *Rule
+	for i := range rules {
+		if rules[i].ListenAddress == listenAddress && rules[i].ListenPort == listenPort {
+			old = &rules[i]
+			break
+		}
+	}
+	if old == nil {
+		return Rule{}, msgError("netsh_rule_not_found", listenAddress, listenPort)
+	}
+
+	family := cmp.Or(old.Family, "v4tov4")
+	if err := deleteNetshRuleOn(family, listenAddress, listenPort); err != nil {
+		return Rule{}, fmt.Errorf("删除原规则失败: %v", err)
+	}
+	if err := addNetshFamilyRuleOn(family, listenAddress, listenPort, newConnectAddr, newConnectPort); err != nil {
+		if rbErr := addNetshFamilyRuleOn(family, listenAddress, listenPort, old.ConnectAddress, old.ConnectPort); rbErr != nil {
+			return Rule{}, fmt.Errorf("添加新规则失败: %v；回滚原规则也失败: %v", err, rbErr)
+		}
+		return Rule{}, fmt.Errorf("添加新规则失败，已恢复原规则: %v", err)
+	}
+
+	log.Printf("netsh 规则 %s:%s 已从 %s:%s 改为 %s:%s", listenAddress, listenPort, old.ConnectAddress, old.ConnectPort, newConnectAddr, newConnectPort)
+	return Rule{ListenAddress: listenAddress, ListenPort: listenPort, ConnectAddress: newConnectAddr, ConnectPort: newConnectPort, Family: family, Protocol: old.Protocol}, nil
+}
+
+func handleEditNetshRule(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ListenAddress     string `json:"listenAddress"`
+		ListenPort        string `json:"listenPort"`
+		NewConnectAddress string `json:"newConnectAddress"`
+		NewConnectPort    string `json:"newConnectPort"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if req.ListenAddress == "" {
+		req.ListenAddress = "0.0.0.0"
+	}
+
+	if req.NewConnectAddress == "" || strings.ContainsAny(req.NewConnectAddress, " =\"") {
+		writeMsgError(w, r, http.StatusBadRequest, "invalid_new_connect_address")
+		return
+	}
+	if err := validatePort("newConnectPort", req.NewConnectPort); err != nil {
+		writeError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	rule, err := editNetshRule(req.ListenAddress, req.ListenPort, req.NewConnectAddress, req.NewConnectPort)
+	if err != nil {
+		writeMsgError(w, r, http.StatusInternalServerError, "netsh_edit_failed", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "success", "rule": rule})
+}
+
+func handlePruneNetshRules(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		DryRun      bool  `json:"dryRun"`
+		OnlyOrphans *bool `json:"onlyOrphans"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	onlyOrphans := req.OnlyOrphans == nil || *req.OnlyOrphans
+
+	rules, err := getNetshRules()
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	// A rule is orphaned when no frp proxy connects to its listen port
+	proxies, err := getFrpProxies()
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	usedPorts := make(map[string]bool)
+	for _, p := range proxies {
+		usedPorts[p.LocalPort] = true
+	}
+
+	var candidates []Rule
+	for _, rule := range rules {
+		if onlyOrphans && usedPorts[rule.ListenPort] {
+			continue
+		}
+		candidates = append(candidates, rule)
+	}
+
+	pruned := []Rule{}
+	var failures []string
+	if req.DryRun {
+		pruned = append(pruned, candidates...)
+	} else {
+		var deletes [][]string
+		for _, rule := range candidates {
+			deletes = append(deletes, ruleDeleteArgs(rule))
+		}
+		results, err := runNetshBatch(deletes)
+		if err != nil {
+			writeMsgError(w, r, http.StatusInternalServerError, "netsh_batch_delete_failed", err)
+			return
+		}
+		for i, rule := range candidates {
+			if results[i] != nil {
+				failures = append(failures, fmt.Sprintf("%s:%s: %v", rule.ListenAddress, rule.ListenPort, results[i]))
+				continue
+			}
+			forgetRuleMeta(rule.ListenAddress, rule.ListenPort)
+			log.Printf("已清理 netsh 规则 %s:%s -> %s:%s", rule.ListenAddress, rule.ListenPort, rule.ConnectAddress, rule.ConnectPort)
+			pruned = append(pruned, rule)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":   "success",
+		"dryRun":   req.DryRun,
+		"pruned":   pruned,
+		"failures": failures,
+	})
+}
+
+// syncPolicies are the drift fixes /api/sync-and-restart can apply: "report"
+// changes nothing, "prune" deletes netsh rules no frp proxy points at
+var syncPolicies = map[string]bool{"report": true, "prune": true}
+
+// handleSyncAndRestart reconciles netsh rules against frp proxies, fixes
+// drift according to the policy and restarts frpc, reporting what changed
+func handleSyncAndRestart(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Policy string `json:"policy"`
+		DryRun bool   `json:"dryRun"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if req.Policy == "" {
+		req.Policy = "report"
+	}
+	if !syncPolicies[req.Policy] {
+		writeMsgError(w, r, http.StatusBadRequest, "unsupported_policy", req.Policy)
+		return
+	}
+
+	rules, err := getNetshRules()
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	proxies, err := getFrpProxies()
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	// 1. Reconcile: rules nothing forwards to, and local proxies with no rule
+	usedPorts := make(map[string]bool)
+	for _, p := range proxies {
+		usedPorts[p.LocalPort] = true
+	}
+	listened := make(map[string]bool)
+	for _, rule := range rules {
+		listened[rule.ListenPort] = true
+	}
+
+	orphanRules := []Rule{}
+	for _, rule := range rules {
+		if !usedPorts[rule.ListenPort] {
+			orphanRules = append(orphanRules, rule)
+		}
+	}
+	// These may be served by a local program, so they are reported only
+	unmatchedProxies := []string{}
+	for _, p := range proxies {
+		if p.LocalIP == "127.0.0.1" && p.Type != "udp" && !listened[p.LocalPort] && p.Name != webUIProxyFullName() {
+			unmatchedProxies = append(unmatchedProxies, p.Name)
+		}
+	}
+
+	// 2. Fix drift per policy
+	removed := []Rule{}
+	var failures []string
+	if req.Policy == "prune" && !req.DryRun && len(orphanRules) > 0 {
+		var deletes [][]string
+		for _, rule := range orphanRules {
+			deletes = append(deletes, ruleDeleteArgs(rule))
+		}
+		results, err := runNetshBatch(deletes)
+		if err != nil {
+			writeMsgError(w, r, http.StatusInternalServerError, "netsh_batch_delete_failed", err)
+			return
+		}
+		for i, rule := range orphanRules {
+			if results[i] != nil {
+				failures = append(failures, fmt.Sprintf("%s:%s: %v", rule.ListenAddress, rule.ListenPort, results[i]))
+				continue
+			}
+			forgetRuleMeta(rule.ListenAddress, rule.ListenPort)
+			log.Printf("同步: 已删除孤立的 netsh 规则 %s:%s -> %s:%s", rule.ListenAddress, rule.ListenPort, rule.ConnectAddress, rule.ConnectPort)
+			removed = append(removed, rule)
+		}
+	}
+
+	// 3. Restart frpc
+	result := map[string]interface{}{
+		"status":           "success",
+		"policy":           req.Policy,
+		"dryRun":           req.DryRun,
+		"orphanRules":      orphanRules,
+		"unmatchedProxies": unmatchedProxies,
+		"removed":          removed,
+		"failures":         failures,
+		"restarted":        false,
+	}
+	if !req.DryRun {
+		restarted, err := restartFrpcIfRunning()
+		if err != nil {
+			result["restartError"] = err.Error()
+		}
+		result["restarted"] = restarted
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func getNetshRules() ([]Rule, error) {
+	rules, _, err := listNetshRules()
+	return rules, err
+}
+
+// listNetshRules lists the portproxy rules along with how many duplicate
+// rows were merged
+func listNetshRules() ([]Rule, int, error) {
+	if runtime.GOOS != "windows" {
+		return setPortNumbers(mockRules()), 0, nil
+	}
+
+	output, err := runCommand("netsh", "interface", "portproxy", "show", "all")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rules, duplicates := dedupeRules(parseNetshOutput(string(output)))
+	if duplicates > 0 {
+		log.Printf("警告: netsh 输出中有 %d 条重复的 portproxy 规则，已合并", duplicates)
+	}
+	return setPortNumbers(rules), duplicates, nil
+}
+
+// setPortNumbers fills in the numeric port fields of rules
+func setPortNumbers(rules []Rule) []Rule {
+	for i := range rules {
+		rules[i].ListenPortNumber = portNumber(rules[i].ListenPort)
+		rules[i].ConnectPortNumber = portNumber(rules[i].ConnectPort)
+	}
+	return rules
+}
+
+// portNumber parses a port, returning 0 for anything outside 1-65535
+func portNumber(port string) int {
+	n, err := strconv.Atoi(strings.TrimSpace(port))
+	if err != nil || n < 1 || n > 65535 {
+		return 0
+	}
+	return n
+}
+
+// sortRules orders rules numerically by "listenPort" or "connectPort",
+// keeping netsh order for equal ports; rules with non-numeric ports sort last
+func sortRules(rules []Rule, by string) error {
+	var key func(Rule) int
+	switch by {
+	case "listenPort":
+		key = func(r Rule) int { return r.ListenPortNumber }
+	case "connectPort":
+		key = func(r Rule) int { return r.ConnectPortNumber }
+	default:
+		return msgError("unsupported_rule_sort", by)
+	}
+	sort.SliceStable(rules, func(i, j int) bool {
+		a, b := key(rules[i]), key(rules[j])
+		if a == 0 || b == 0 {
+			return b == 0 && a != 0
+		}
+		return a < b
+	})
+	return nil
+}
+
+// dedupeRules keeps one rule per family, listen address and port, taking the values
+// of the last duplicate at the position of the first. It returns the rules
+// and how many rows were dropped.
+func dedupeRules(rules []Rule) ([]Rule, int) {
+	index := make(map[string]int)
+	deduped := make([]Rule, 0, len(rules))
+	for _, rule := range rules {
+		key := rule.Family + " " + ruleKey(rule.ListenAddress, rule.ListenPort)
+		if i, ok := index[key]; ok {
+			deduped[i] = rule
+			continue
+		}
+		index[key] = len(deduped)
+		deduped = append(deduped, rule)
+	}
+	return deduped, len(rules) - len(deduped)
+}
+
+// getNetshRawOutput returns the unparsed output of `netsh interface portproxy show all`
+func getNetshRawOutput() (string, error) {
+	if runtime.GOOS != "windows" {
+		return mockNetshOutput, nil
+	}
+
+	output, err := runCommand("netsh", "interface", "portproxy", "show", "all")
+	if err != nil {
+		return "", err
+	}
+	return string(output), nil
+}
+
+func handleGetRulesRaw(w http.ResponseWriter, r *http.Request) {
+	output, err := getNetshRawOutput()
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, output)
+}
+
+func addNetshRule(listenPort, connectAddr, connectPort string) error {
+	return addNetshRuleOn("0.0.0.0", listenPort, connectAddr, connectPort)
+}
+
+// addNetshRuleOn adds a rule bound to a specific listen address
+func addNetshRuleOn(listenAddress, listenPort, connectAddr, connectPort string) error {
+	return addNetshFamilyRuleOn("v4tov4", listenAddress, listenPort, connectAddr, connectPort)
+}
+
+// addNetshFamilyRuleOn adds a rule to the given portproxy table
+func addNetshFamilyRuleOn(family, listenAddress, listenPort, connectAddr, connectPort string) error {
+	args := netshAddFamilyArgs(family, listenAddress, listenPort, connectAddr, connectPort)
+	if runtime.GOOS != "windows" {
+		log.Printf("[模拟] netsh %s", strings.Join(args, " "))
+		return nil
+	}
+
+	_, err := runCommand("netsh", args...)
+	return err
+}
+
+// addNetshRulesOn adds the same forward on each listen address in one batch,
+// deleting the ones that succeeded if any fails
+func addNetshRulesOn(family string, listenAddresses []string, listenPort, connectAddr, connectPort string) error {
+	var adds [][]string
+	for _, addr := range listenAddresses {
+		adds = append(adds, netshAddFamilyArgs(family, addr, listenPort, connectAddr, connectPort))
+	}
+	results, err := runNetshBatch(adds)
+	if err != nil {
+		return err
+	}
+
+	var undo [][]string
+	var failed error
+	for i, addr := range listenAddresses {
+		if results[i] != nil {
+			if failed == nil {
+				failed = fmt.Errorf("%s:%s: %v", addr, listenPort, results[i])
+			}
+			continue
+		}
+		undo = append(undo, netshDeleteFamilyArgs(family, addr, listenPort))
+	}
+	if failed == nil {
+		return nil
+	}
+	if results, err := runNetshBatch(undo); err != nil {
+		log.Printf("警告: 回滚 netsh 规则失败: %v", err)
+	} else {
+		for i, err := range results {
+			if err != nil {
+				log.Printf("警告: 回滚 netsh 规则失败 (%s): %v", strings.Join(undo[i], " "), err)
+			}
+		}
+	}
+	return failed
+}
+
+// netshFamilies lists the portproxy tables, named after the listen and
+// connect IP versions
+var netshFamilies = []string{"v4tov4", "v4tov6", "v6tov4", "v6tov6"}
+
+// familyListenAddress is the wildcard listen address for a portproxy table
+func familyListenAddress(family string) string {
+	if strings.HasPrefix(family, "v6") {
+		return "::"
+	}
+	return "0.0.0.0"
+}
+
+// netshAddArgs returns the netsh arguments used to add a rule
+func netshAddArgs(listenAddress, listenPort, connectAddr, connectPort string) []string {
+	return netshAddFamilyArgs("v4tov4", listenAddress, listenPort, connectAddr, connectPort)
+}
+
+// netshAddFamilyArgs builds the add arguments for a rule in the given
+// portproxy table
+func netshAddFamilyArgs(family, listenAddress, listenPort, connectAddr, connectPort string) []string {
+	return []string{"interface", "portproxy", "add", family,
+		"listenaddress=" + listenAddress,
+		"listenport=" + listenPort,
+		"connectaddress=" + connectAddr,
+		"connectport=" + connectPort,
+	}
+}
+
+// deleteNetshRuleOn deletes the rule bound to a specific listen address from
+// the given portproxy table
+func deleteNetshRuleOn(family, listenAddress, listenPort string) error {
+	args := netshDeleteFamilyArgs(family, listenAddress, listenPort)
+	if runtime.GOOS != "windows" {
+		log.Printf("[模拟] netsh %s", strings.Join(args, " "))
+		return nil
+	}
+
+	_, err := runCommand("netsh", args...)
+	return err
+}
+
+// netshDeleteArgs returns the netsh arguments used to delete a rule
+func netshDeleteArgs(listenAddress, listenPort string) []string {
+	return netshDeleteFamilyArgs("v4tov4", listenAddress, listenPort)
+}
+
+// netshDeleteFamilyArgs builds the delete arguments for a rule in the given
+// portproxy table
+func netshDeleteFamilyArgs(family, listenAddress, listenPort string) []string {
+	return []string{"interface", "portproxy", "delete", family,
+		"listenaddress=" + listenAddress,
+		"listenport=" + listenPort,
+	}
+}
+
+// ruleDeleteArgs builds the delete arguments for a listed rule, using the
+// table it was listed in
+func ruleDeleteArgs(rule Rule) []string {
+	family := rule.Family
+	if family == "" {
+		family = "v4tov4"
+	}
+	return netshDeleteFamilyArgs(family, rule.ListenAddress, rule.ListenPort)
+}
+
+// netshBatchMarker prefixes the line the batch script echoes after each
+// command, carrying the command's index and exit code
+const netshBatchMarker = "##NETSH-RESULT"
+
+// runNetshBatch runs several netsh commands from a single cmd script instead
+// of one process each, which is much faster for bulk operations. It returns
+// one error per command (nil on success); the error return is for failures
+// to run the script itself.
+func runNetshBatch(commands [][]string) ([]error, error) {
+	results := make([]error, len(commands))
+	if len(commands) == 0 {
+		return results, nil
+	}
+	if runtime.GOOS != "windows" {
+		for _, args := range commands {
+			log.Printf("[模拟] netsh %s", strings.Join(args, " "))
+		}
+		return results, nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString("@echo off\r\n")
+	for i, args := range commands {
+		for _, arg := range args {
+			// Arguments are validated before this point; refuse anything cmd
+			// would interpret rather than pass through
+			if strings.ContainsAny(arg, "&|<>^%\"\r\n ") {
+				return nil, fmt.Errorf("netsh 参数包含非法字符: %q", arg)
+			}
+		}
+		sb.WriteString("netsh " + strings.Join(args, " ") + "\r\n")
+		sb.WriteString(fmt.Sprintf("echo %s %d %%ERRORLEVEL%%\r\n", netshBatchMarker, i))
+	}
+
+	script, err := os.CreateTemp("", "portproxy-netsh-*.cmd")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(script.Name())
+	if _, err := script.WriteString(sb.String()); err != nil {
+		script.Close()
+		return nil, err
+	}
+	script.Close()
+
+	// Allow roughly a second per command on top of the normal timeout
+	timeout := secondsOrDefault(config.CommandTimeoutSecs, defaultCommandTimeout) + time.Duration(len(commands))*time.Second
+	output, runErr := runCommandTimeout(timeout, "cmd", "/C", script.Name())
+
+	// Output before each marker belongs to the command the marker reports on
+	seen := make([]bool, len(commands))
+	var pending []string
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSpace(line)
+		fields := strings.Fields(line)
+		if len(fields) != 3 || fields[0] != netshBatchMarker {
+			if line != "" {
+				pending = append(pending, line)
+			}
+			continue
+		}
+		i, err := strconv.Atoi(fields[1])
+		if err != nil || i < 0 || i >= len(commands) {
+			continue
+		}
+		seen[i] = true
+		if fields[2] != "0" {
+			results[i] = fmt.Errorf("netsh 退出码 %s: %s", fields[2], strings.Join(pending, " "))
+		}
+		pending = nil
+	}
+	for i := range commands {
+		if !seen[i] {
+			if runErr == nil {
+				runErr = fmt.Errorf("没有输出")
+			}
+			results[i] = fmt.Errorf("命令未执行完成: %v", runErr)
+		}
+	}
+	return results, nil
+}
+
+func parseNetshOutput(output string) []Rule {
+	var rules []Rule
+	lines := strings.Split(output, "\n")
+	// Rows before any table header are treated as v4tov4
+	family := "v4tov4"
+
+	for _, line := range lines {
+		// Table headers name the family, e.g. "Listen on ipv4:  Connect to ipv6:"
+		// or "侦听 ipv4:  连接到 ipv6:"
+		if m := reNetshTable.FindStringSubmatch(line); m != nil {
+			family = "v" + m[1] + "tov" + m[2]
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 4 {
+			// Filter out headers (both English and Chinese)
+			// English headers: "Address", "Listen", "---------------"
+			// Chinese headers: "侦听", "地址", "端口", "连接到"
+			if fields[0] == "Address" ||
+				fields[0] == "---------------" ||
+				strings.HasPrefix(fields[0], "Listen") ||
+				fields[0] == "侦听" ||
+				fields[0] == "地址" {
+				continue
+			}
+			rules = append(rules, Rule{
+				ListenAddress:  fields[0],
+				ListenPort:     fields[1],
+				ConnectAddress: fields[2],
+				ConnectPort:    fields[3],
+				Family:         family,
+				Protocol:       "tcp",
+			})
+		}
+	}
+	return rules
+}
+
+// reNetshTable matches a portproxy table header and captures the listen and
+// connect IP versions
+var reNetshTable = regexp.MustCompile(`(?i)ipv([46]):.*ipv([46]):`)
+
+// mockNetshOutput is a representative `netsh interface portproxy show all`
+// output used in simulation mode
+const mockNetshOutput = `
+Listen on ipv4:             Connect to ipv4:
+
+Address         Port        Address         Port
+--------------- ----------  --------------- ----------
+0.0.0.0         8080        192.168.1.10    80
+0.0.0.0         2222        192.168.1.11    22
+`
+
+func mockRules() []Rule {
+	return []Rule{
+		{ListenAddress: "0.0.0.0", ListenPort: "8080", ConnectAddress: "192.168.1.10", ConnectPort: "80", Family: "v4tov4", Protocol: "tcp"},
+		{ListenAddress: "0.0.0.0", ListenPort: "2222", ConnectAddress: "192.168.1.11", ConnectPort: "22", Family: "v4tov4", Protocol: "tcp"},
+	}
+}
+
+// allFrpProxies parses the proxies of every file in managedTomlPaths. Name
+// and port uniqueness checks use it, since frpc loads them all together;
+// getFrpProxies lists only the managed proxies file.
+func allFrpProxies() ([]FrpProxy, error) {
+	var all []FrpProxy
+	for _, path := range managedTomlPaths() {
+		content, err := readManagedFile(path)
+		if err != nil {
+			return nil, err
+		}
+		proxies, err := parseFrpProxies(bytes.NewReader(content))
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, proxies...)
+	}
+	return all, nil
+}
+
+func getFrpProxies() ([]FrpProxy, error) {
+	file, err := os.Open(proxiesTomlPath())
+	if os.IsNotExist(err) && proxiesTomlPath() != config.FrpcTomlPath {
+		return []FrpProxy{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return parseFrpProxies(file)
+}
+
+// parseFrpProxies parses the [[proxies]] tables of frpc.toml content
+func parseFrpProxies(r io.Reader) ([]FrpProxy, error) {
+	var proxies []FrpProxy
+	scanner := bufio.NewScanner(r)
+
+	var current *FrpProxy
+	reName := regexp.MustCompile(`^\s*name\s*=\s*` + tomlBasicString)
+	reType := regexp.MustCompile(`^\s*type\s*=\s*` + tomlBasicString)
+	reLocalIP := regexp.MustCompile(`^\s*localIP\s*=\s*` + tomlBasicString)
+	reLocalPort := regexp.MustCompile(`^\s*localPort\s*=\s*(\d+)`)
+	reRemotePort := regexp.MustCompile(`^\s*remotePort\s*=\s*(\d+)`)
+	reGroup := regexp.MustCompile(`^\s*loadBalancer\.group\s*=\s*` + tomlBasicString)
+	reGroupKey := regexp.MustCompile(`^\s*loadBalancer\.groupKey\s*=\s*` + tomlBasicString)
+	reCustomDomains := regexp.MustCompile(`^\s*customDomains\s*=\s*\[(.*)\]`)
+	reProxyProtocol := regexp.MustCompile(`^\s*transport\.proxyProtocolVersion\s*=\s*` + tomlBasicString)
+	reSecretKey := regexp.MustCompile(`^\s*secretKey\s*=\s*` + tomlBasicString)
+	reQuoted := regexp.MustCompile(tomlBasicString)
+	reKeyValue := regexp.MustCompile(`^([A-Za-z0-9_.-]+)\s*=\s*(.+)$`)
+	reSubTable := regexp.MustCompile(`^\[proxies\.([A-Za-z0-9_.-]+)\]$`)
+	// subTable is the prefix for keys under a [proxies.x] table
+	subTable := ""
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "[[proxies]]" {
+			if current != nil {
+				proxies = append(proxies, *current)
+			}
+			current = &FrpProxy{}
+			subTable = ""
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if matches := reSubTable.FindStringSubmatch(line); len(matches) > 1 && current != nil {
+				subTable = matches[1] + "."
+				continue
+			}
+			// Any other table ends the current proxy
+			if current != nil {
+				proxies = append(proxies, *current)
+				current = nil
+			}
+			continue
+		}
+
+		if tags, ok := parseTagsComment(line); ok && current != nil {
+			current.Tags = tags
+			continue
+		}
+
+		if current != nil && subTable != "" {
+			if matches := reKeyValue.FindStringSubmatch(line); len(matches) > 2 {
+				if subTable+matches[1] == "transport.proxyProtocolVersion" {
+					current.ProxyProtocolVersion = tomlUnquote(matches[2])
+				} else {
+					current.setExtra(subTable+matches[1], matches[2])
+				}
+			}
+		} else if current != nil {
+			if matches := reName.FindStringSubmatch(line); len(matches) > 1 {
+				current.Name = tomlUnescape(matches[1])
+			} else if matches := reType.FindStringSubmatch(line); len(matches) > 1 {
+				current.Type = normalizeProxyType(tomlUnescape(matches[1]))
+			} else if matches := reLocalIP.FindStringSubmatch(line); len(matches) > 1 {
+				current.LocalIP = tomlUnescape(matches[1])
+			} else if matches := reLocalPort.FindStringSubmatch(line); len(matches) > 1 {
+				current.LocalPort = matches[1]
+			} else if matches := reRemotePort.FindStringSubmatch(line); len(matches) > 1 {
+				current.RemotePort = matches[1]
+			} else if matches := reGroup.FindStringSubmatch(line); len(matches) > 1 {
+				current.Group = tomlUnescape(matches[1])
+			} else if matches := reGroupKey.FindStringSubmatch(line); len(matches) > 1 {
+				current.GroupKey = tomlUnescape(matches[1])
+			} else if matches := reCustomDomains.FindStringSubmatch(line); len(matches) > 1 {
+				for _, m := range reQuoted.FindAllStringSubmatch(matches[1], -1) {
+					current.CustomDomains = append(current.CustomDomains, tomlUnescape(m[1]))
+				}
+			} else if matches := reProxyProtocol.FindStringSubmatch(line); len(matches) > 1 {
+				current.ProxyProtocolVersion = tomlUnescape(matches[1])
+			} else if matches := reSecretKey.FindStringSubmatch(line); len(matches) > 1 {
+				current.SecretKey = tomlUnescape(matches[1])
+			} else if matches := reKeyValue.FindStringSubmatch(line); len(matches) > 2 {
+				current.setExtra(matches[1], matches[2])
+			}
+		}
+	}
+
+	// Add last proxy
+	if current != nil {
+		proxies = append(proxies, *current)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return proxies, nil
+}
+
+// setExtra records an unmodelled key with its raw TOML value
+func (p *FrpProxy) setExtra(key, value string) {
+	if p.Extra == nil {
+		p.Extra = make(map[string]string)
+	}
+	p.Extra[key] = value
+}
+
+func getFirstProxyName() string {
+	file, err := os.Open(proxiesTomlPath())
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	inProxies := false
+	reName := regexp.MustCompile(`^\s*name\s*=\s*` + tomlBasicString)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "[[proxies]]" {
+			inProxies = true
+			continue
+		}
+		if inProxies {
+			matches := reName.FindStringSubmatch(line)
+			if len(matches) > 1 {
+				// Found the first name
+				return namePrefix(tomlUnescape(matches[1]))
+			}
+		}
+	}
+	return ""
+}
+
+// namePrefix derives the default name from a proxy name (e.g. "yzwj" from
+// "yzwj-it-10.0.0.5-22"). A configured namePrefixPattern wins, using its first
+// capture group or the whole match; otherwise the name is cut at the first
+// nameSeparator ("-" by default). Names without a prefix are returned whole.
+func namePrefix(name string) string {
+	if config.NamePrefixPattern != "" {
+		re, err := regexp.Compile(config.NamePrefixPattern)
+		if err != nil {
+			log.Printf("警告: namePrefixPattern 无效: %v", err)
+		} else if matches := re.FindStringSubmatch(name); matches != nil {
+			if len(matches) > 1 && matches[1] != "" {
+				return matches[1]
+			}
+			if matches[0] != "" {
+				return matches[0]
+			}
+		}
+	}
+
+	sep := config.NameSeparator
+	if sep == "" {
+		sep = "-"
+	}
+	if prefix, _, found := strings.Cut(name, sep); found && prefix != "" {
+		return prefix
+	}
+	return name
+}
+
+func appendToFrpc(req AddRuleRequest) error {
+	return appendProxiesToml(buildProxyBlock(req))
+}
+
+// proxyNameFor returns the proxy name generated for an add request
+func proxyNameFor(req AddRuleRequest) string {
+	// New naming convention: [name]-[manager]-[connectAddr]-[connectPort]
+	// Name is optional
+	if req.Name != "" {
+		return fmt.Sprintf("%s-%s-%s-%s", req.Name, req.Manager, req.ConnectAddr, req.ConnectPort)
+	}
+	return fmt.Sprintf("%s-%s-%s", req.Manager, req.ConnectAddr, req.ConnectPort)
+}
+
+// buildProxyBlock renders the [[proxies]] block for an add request. TCP
+// proxies connect to the local netsh listener; UDP proxies, which netsh
+// cannot forward, connect to the target directly.
+func buildProxyBlock(req AddRuleRequest) string {
+	proxyType, localIP, localPort := req.Type, "127.0.0.1", req.ListenPort
+	if proxyType == "" {
+		proxyType = "tcp"
+	}
+	if listenAddrs := req.ListenAddresses; len(listenAddrs) > 0 && !slices.Contains(listenAddrs, "127.0.0.1") && !slices.Contains(listenAddrs, "0.0.0.0") {
+		// frpc has to reach one of the listeners the request creates
+		localIP = listenAddrs[0]
+	}
+	if strings.HasPrefix(req.Family, "v6") {
+		localIP = "::1"
+	}
+	if isUDPProxyType(proxyType) {
+		localIP, localPort = req.ConnectAddr, req.ConnectPort
+	}
+
+	var sb strings.Builder
+	sb.WriteString("\n[[proxies]]\n")
+	if comment := tagsComment(req.Tags); comment != "" {
+		sb.WriteString(comment + "\n")
+	}
+	sb.WriteString(fmt.Sprintf("name = %s\n", tomlQuote(proxyNameFor(req))))
+	sb.WriteString(fmt.Sprintf("type = \"%s\"\n", proxyType))
+	sb.WriteString(fmt.Sprintf("localIP = %s\n", tomlQuote(localIP)))
+	sb.WriteString(fmt.Sprintf("localPort = %s\n", localPort))
+	if req.RemotePort != "" {
+		sb.WriteString(fmt.Sprintf("remotePort = %s\n", req.RemotePort))
+	}
+	if req.SecretKey != "" {
+		sb.WriteString(fmt.Sprintf("secretKey = %s\n", tomlQuote(req.SecretKey)))
+	}
+	if req.Group != "" {
+		sb.WriteString(fmt.Sprintf("loadBalancer.group = %s\n", tomlQuote(req.Group)))
+		if req.GroupKey != "" {
+			sb.WriteString(fmt.Sprintf("loadBalancer.groupKey = %s\n", tomlQuote(req.GroupKey)))
+		}
+	}
+	if req.ProxyProtocolVersion != "" {
+		sb.WriteString(fmt.Sprintf("transport.proxyProtocolVersion = \"%s\"\n", req.ProxyProtocolVersion))
+	}
+	if req.HealthCheckType != "" {
+		sb.WriteString(fmt.Sprintf("healthCheck.type = \"%s\"\n", req.HealthCheckType))
+		if req.HealthCheckTimeoutSeconds > 0 {
+			sb.WriteString(fmt.Sprintf("healthCheck.timeoutSeconds = %d\n", req.HealthCheckTimeoutSeconds))
+		}
+		if req.HealthCheckIntervalSeconds > 0 {
+			sb.WriteString(fmt.Sprintf("healthCheck.intervalSeconds = %d\n", req.HealthCheckIntervalSeconds))
+		}
+		if req.HealthCheckType == "http" {
+			path := req.HealthCheckPath
+			if path == "" {
+				path = "/"
+			}
+			sb.WriteString(fmt.Sprintf("healthCheck.path = %s\n", tomlQuote(path)))
+		}
+	}
+	extraKeys := make([]string, 0, len(req.ExtraConfig))
+	for key := range req.ExtraConfig {
+		extraKeys = append(extraKeys, key)
+	}
+	sort.Strings(extraKeys)
+	for _, key := range extraKeys {
+		sb.WriteString(fmt.Sprintf("%s = %s\n", key, strings.TrimSpace(req.ExtraConfig[key])))
+	}
+	return sb.String()
+}
+
+// deleteFrpProxy removes a proxy block, streaming frpc.toml line by line so
+// large configs are never held in memory whole
+func deleteFrpProxy(proxyName string) error {
+	reName := regexp.MustCompile(`^\s*name\s*=\s*` + tomlBasicString)
+
+	return rewriteTomlFile(proxiesTomlPath(), func(in *bufio.Reader, out *bufio.Writer) error {
+		// pending holds a [[proxies]] header and any comments after it until
+		// the name line shows whether the block is the one being deleted
+		var pending []string
+		var skipProxy bool
+
+		for {
+			line, err := in.ReadString('\n')
+			if line != "" {
+				trimmed := strings.TrimSpace(line)
+				if pending != nil {
+					if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+						pending = append(pending, line)
+						line = ""
+					} else if matches := reName.FindStringSubmatch(line); len(matches) > 1 && tomlUnescape(matches[1]) == proxyName {
+						// This is the proxy to delete
+						pending = nil
+						skipProxy = true
+						line = ""
+					} else {
+						for _, p := range pending {
+							out.WriteString(p)
+						}
+						pending = nil
+					}
+				}
+
+				switch {
+				case line == "":
+				case trimmed == "[[proxies]]":
+					// Starting a new proxy block; wait for its name
+					skipProxy = false
+					pending = []string{line}
+				case skipProxy && strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") && !strings.HasPrefix(trimmed, "[proxies."):
+					// A new section, other than one of its sub-tables, ends the deleted block
+					skipProxy = false
+					out.WriteString(line)
+				case !skipProxy:
+					out.WriteString(line)
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+		for _, p := range pending {
+			out.WriteString(p)
+		}
+		return nil
+	})
+}
+
+// splitLines splits file content into lines, accepting both "\n" and "\r\n"
+// endings. It returns the lines without "\r" and the ending the content uses.
+func splitLines(content string) ([]string, string) {
+	eol := "\n"
+	if strings.Contains(content, "\r\n") {
+		eol = "\r\n"
+	}
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return lines, eol
+}
+
+// proxiesTomlPath returns the file holding the managed proxies:
+// managedProxiesFile when configured, otherwise frpc.toml itself
+func proxiesTomlPath() string {
+	path := config.ManagedProxiesFile
+	if path == "" {
+		return config.FrpcTomlPath
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(config.FrpcTomlPath), path)
+	}
+	return path
+}
+
+// managedTomlPaths lists the files frpc reads the managed config from:
+// frpc.toml and, when configured, the separate managed proxies file
+func managedTomlPaths() []string {
+	if path := proxiesTomlPath(); path != config.FrpcTomlPath {
+		return []string{config.FrpcTomlPath, path}
+	}
+	return []string{config.FrpcTomlPath}
+}
+
+// readManagedFile reads one of managedTomlPaths. A separate proxies file
+// that does not exist yet reads as empty.
+func readManagedFile(path string) ([]byte, error) {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) && path != config.FrpcTomlPath {
+		return nil, nil
+	}
+	return content, err
+}
+
+// reTomlQuoted matches the quoted strings of a single-line TOML array
+var reTomlQuoted = regexp.MustCompile(`"((?:[^"\\]|\\.)*)"`)
+
+// ensureProxiesInclude adds the managed proxies file to frpc.toml's includes
+// when it is separate from frpc.toml and no existing entry covers it
+func ensureProxiesInclude() error {
+	path := proxiesTomlPath()
+	if path == config.FrpcTomlPath {
+		return nil
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	lines, _, err := readFrpcToml()
+	if err != nil {
+		return err
+	}
+	var includes []string
+	if idx, value := findTomlKey(lines, "includes"); idx >= 0 {
+		if !strings.HasPrefix(value, "[") || !strings.HasSuffix(value, "]") {
+			return fmt.Errorf("无法解析 frpc.toml 中的 includes (仅支持单行数组)，请手动添加 %q", filepath.ToSlash(abs))
+		}
+		for _, m := range reTomlQuoted.FindAllStringSubmatch(value, -1) {
+			include, _ := filepath.Abs(tomlUnquote(m[0]))
+			if matched, _ := filepath.Match(include, abs); matched || samePath(include, abs) {
+				return nil
+			}
+			includes = append(includes, m[0])
+		}
+	}
+
+	// frpc resolves relative includes against its working directory, so
+	// write an absolute path
+	includes = append(includes, tomlQuote(filepath.ToSlash(abs)))
+	if err := updateFrpcTomlKeys([][2]string{{"includes", "[" + strings.Join(includes, ", ") + "]"}}); err != nil {
+		return err
+	}
+	log.Printf("已将 %s 添加到 frpc.toml 的 includes", filepath.ToSlash(abs))
+	return nil
+}
+
+// readFrpcToml reads frpc.toml as lines along with its line ending
+func readFrpcToml() ([]string, string, error) {
+	return readTomlFile(config.FrpcTomlPath)
+}
+
+// readProxiesToml reads the managed proxies file, which may not exist yet
+// when it is separate from frpc.toml
+func readProxiesToml() ([]string, string, error) {
+	lines, eol, err := readTomlFile(proxiesTomlPath())
+	if os.IsNotExist(err) && proxiesTomlPath() != config.FrpcTomlPath {
+		return []string{""}, "\n", nil
+	}
+	return lines, eol, err
+}
+
+// readTomlFile reads a TOML file as lines along with its line ending
+func readTomlFile(path string) ([]string, string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	lines, eol := splitLines(string(content))
+	return lines, eol, nil
+}
+
+// writeFrpcToml writes lines to frpc.toml using the given line ending
+func writeFrpcToml(lines []string, eol string) error {
+	return writeTomlFile(config.FrpcTomlPath, lines, eol)
+}
+
+// writeProxiesToml writes lines to the managed proxies file
+func writeProxiesToml(lines []string, eol string) error {
+	return writeTomlFile(proxiesTomlPath(), lines, eol)
+}
+
+// writeTomlFile writes lines to a TOML file using the given line ending
+func writeTomlFile(path string, lines []string, eol string) error {
+	return replaceTomlFile(path, func(out *bufio.Writer) error {
+		for i, line := range lines {
+			if i > 0 {
+				out.WriteString(eol)
+			}
+			out.WriteString(line)
+		}
+		return nil
+	})
+}
+
+// replaceTomlFile writes a new version of path through a buffered temp file
+// in the same directory and renames it into place, so readers never see a
+// partial file and no full copy of the content has to be built in memory
+func replaceTomlFile(path string, write func(out *bufio.Writer) error) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	out := bufio.NewWriter(tmp)
+	err = write(out)
+	if err == nil {
+		err = out.Flush()
+	}
+	if err == nil {
+		err = tmp.Chmod(mode)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	if slices.Contains(managedTomlPaths(), path) {
+		noteFrpcTomlWrite()
+	}
+	return nil
+}
+
+// rewriteTomlFile streams path through edit into its replacement. Lines
+// read from in keep their original endings.
+func rewriteTomlFile(path string, edit func(in *bufio.Reader, out *bufio.Writer) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return replaceTomlFile(path, func(out *bufio.Writer) error {
+		return edit(bufio.NewReader(f), out)
+	})
+}
+
+// tomlFileEOL reports the line ending a file uses, judging by its first
+// line. Missing files use frpc.toml's ending.
+func tomlFileEOL(path string) (string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) && path != config.FrpcTomlPath {
+		return tomlFileEOL(config.FrpcTomlPath)
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	if strings.HasSuffix(line, "\r\n") {
+		return "\r\n", nil
+	}
+	return "\n", nil
+}
+
+// appendFrpcToml appends text written with "\n" endings to frpc.toml,
+// converting it to the line ending the file already uses
+func appendFrpcToml(text string) error {
+	return appendTomlFile(config.FrpcTomlPath, text)
+}
+
+// appendProxiesToml appends proxy blocks to the managed proxies file,
+// creating it if it is separate from frpc.toml and does not exist yet
+func appendProxiesToml(text string) error {
+	return appendTomlFile(proxiesTomlPath(), text)
+}
+
+// appendTomlFile appends text written with "\n" endings to path, converting
+// it to the line ending the file already uses
+func appendTomlFile(path, text string) error {
+	eol, err := tomlFileEOL(path)
+	if err != nil {
+		return err
+	}
+	if eol != "\n" {
+		text = strings.ReplaceAll(text, "\n", eol)
+	}
+
+	flags := os.O_APPEND | os.O_WRONLY
+	if path != config.FrpcTomlPath {
+		flags |= os.O_CREATE
+	}
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(f, text)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if slices.Contains(managedTomlPaths(), path) {
+		noteFrpcTomlWrite()
+	}
+	return err
+}
+
+// tomlBlock is a run of consecutive lines in frpc.toml. Proxy blocks start at a
+// [[proxies]] header and run until the next table header; everything else
+// (server settings, comments, other tables) is kept as non-proxy content.
+type tomlBlock struct {
+	Proxy bool
+	Name  string
+	Lines []string
+}
+
+// splitTomlBlocks groups the lines of frpc.toml into proxy and non-proxy blocks
+func splitTomlBlocks(lines []string) []tomlBlock {
+	var blocks []tomlBlock
+	reName := regexp.MustCompile(`^\s*name\s*=\s*` + tomlBasicString)
+
+	current := tomlBlock{}
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		isHeader := strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]")
+
+		// Sub-tables such as [proxies.plugin] belong to the enclosing proxy
+		if isHeader && current.Proxy && strings.HasPrefix(trimmed, "[proxies.") {
+			isHeader = false
+		}
+
+		if isHeader {
+			if len(current.Lines) > 0 {
+				blocks = append(blocks, current)
+			}
+			current = tomlBlock{Proxy: trimmed == "[[proxies]]"}
+		}
+
+		if current.Proxy && current.Name == "" {
+			if matches := reName.FindStringSubmatch(line); len(matches) > 1 {
+				current.Name = tomlUnescape(matches[1])
+			}
+		}
+		current.Lines = append(current.Lines, line)
+	}
+	if len(current.Lines) > 0 {
+		blocks = append(blocks, current)
+	}
+	return blocks
+}
+
+// joinTomlBlocks flattens blocks back into lines
+func joinTomlBlocks(blocks []tomlBlock) []string {
+	var lines []string
+	for _, b := range blocks {
+		lines = append(lines, b.Lines...)
+	}
+	return lines
+}
+
+// splitTrailingBlank separates a block's content from the blank lines that
+// follow it, so blocks can be moved without disturbing the file's spacing
+func splitTrailingBlank(lines []string) (body, tail []string) {
+	end := len(lines)
+	for end > 0 && strings.TrimSpace(lines[end-1]) == "" {
+		end--
+	}
+	return lines[:end], lines[end:]
+}
+
+// copyFrpProxy appends a clone of the named proxy block with a new name and
+// remote port. All other keys of the source block are carried over verbatim.
+func copyFrpProxy(sourceName, newName, newRemotePort string) error {
+	if err := validateProxyName("newName", newName); err != nil {
+		return err
+	}
+	if newRemotePort != "" {
+		if err := validatePort("newRemotePort", newRemotePort); err != nil {
+			return err
+		}
+	}
+
+	lines, _, err := readProxiesToml()
+	if err != nil {
+		return err
+	}
+
+	var source *tomlBlock
+	blocks := splitTomlBlocks(lines)
+	for i := range blocks {
+		if !blocks[i].Proxy {
+			continue
+		}
+		if blocks[i].Name == newName {
+			return msgError("proxy_name_taken", newName)
+		}
+		if blocks[i].Name == sourceName {
+			source = &blocks[i]
+		}
+	}
+	if source == nil {
+		return msgError("proxy_not_found", sourceName)
+	}
+
+	proxies, err := allFrpProxies()
+	if err != nil {
+		return err
+	}
+	for _, p := range proxies {
+		if p.Name == newName {
+			return msgError("proxy_name_taken", newName)
+		}
+		if newRemotePort != "" && p.RemotePort == newRemotePort {
+			return msgError("remote_port_taken", newRemotePort, p.Name)
+		}
+	}
+
+	reName := regexp.MustCompile(`^\s*name\s*=`)
+	reRemotePort := regexp.MustCompile(`^\s*remotePort\s*=`)
+
+	body, _ := splitTrailingBlank(source.Lines)
+	clone := make([]string, 0, len(body))
+	hasRemotePort := false
+	for _, line := range body {
+		switch {
+		case reName.MatchString(line):
+			line = "name = " + tomlQuote(newName)
+		case reRemotePort.MatchString(line):
+			hasRemotePort = true
+			if newRemotePort == "" {
+				return msgError("copy_remote_port_required")
+			}
+			line = fmt.Sprintf("remotePort = %s", newRemotePort)
+		}
+		clone = append(clone, line)
+	}
+	if newRemotePort != "" && !hasRemotePort {
+		return msgError("copy_no_remote_port")
+	}
+
+	return appendProxiesToml("\n" + strings.Join(clone, "\n") + "\n")
+}
+
+// frpProxyBlock renders a proxy as a [[proxies]] block. Extra keys are
+// written as dotted keys, which frp reads the same as their sub-tables.
+func frpProxyBlock(p FrpProxy) []string {
+	lines := []string{"[[proxies]]"}
+	if comment := tagsComment(p.Tags); comment != "" {
+		lines = append(lines, comment)
+	}
+	lines = append(lines, "name = "+tomlQuote(p.Name), "type = "+tomlQuote(p.Type))
+	if p.LocalIP != "" {
+		lines = append(lines, "localIP = "+tomlQuote(p.LocalIP))
+	}
+	if p.LocalPort != "" {
+		lines = append(lines, "localPort = "+p.LocalPort)
+	}
+	if p.RemotePort != "" {
+		lines = append(lines, "remotePort = "+p.RemotePort)
+	}
+	if p.SecretKey != "" {
+		lines = append(lines, "secretKey = "+tomlQuote(p.SecretKey))
+	}
+	if len(p.CustomDomains) > 0 {
+		quoted := make([]string, len(p.CustomDomains))
+		for i, d := range p.CustomDomains {
+			quoted[i] = tomlQuote(d)
+		}
+		lines = append(lines, "customDomains = ["+strings.Join(quoted, ", ")+"]")
+	}
+	if p.Group != "" {
+		lines = append(lines, "loadBalancer.group = "+tomlQuote(p.Group))
+		if p.GroupKey != "" {
+			lines = append(lines, "loadBalancer.groupKey = "+tomlQuote(p.GroupKey))
+		}
+	}
+	if p.ProxyProtocolVersion != "" {
+		lines = append(lines, "transport.proxyProtocolVersion = "+tomlQuote(p.ProxyProtocolVersion))
+	}
+	keys := make([]string, 0, len(p.Extra))
+	for key := range p.Extra {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		lines = append(lines, key+" = "+strings.TrimSpace(p.Extra[key]))
+	}
+	return lines
+}
+
+// frpProxyTypes lists the proxy types frp accepts, in canonical lowercase
+var frpProxyTypes = []string{"tcp", "udp", "http", "https", "tcpmux", "stcp", "sudp", "xtcp"}
+
+// secretProxyTypes are reached through a visitor holding the same secretKey
+// instead of through a public remotePort
+var secretProxyTypes = []string{"stcp", "xtcp", "sudp"}
+
+// isUDPProxyType reports whether a proxy type carries UDP, which netsh
+// cannot forward
+func isUDPProxyType(t string) bool {
+	return t == "udp" || t == "sudp"
+}
+
+// normalizeProxyType returns the canonical form of a proxy type; frp only
+// accepts lowercase, but users write "TCP" or "Tcp"
+func normalizeProxyType(t string) string {
+	return strings.ToLower(strings.TrimSpace(t))
+}
+
+// validateFrpProxy checks a desired proxy before it is written. The type
+// must already be normalized.
+func validateFrpProxy(p FrpProxy) error {
+	if p.Name == "" || p.Type == "" {
+		return msgError("proxy_name_type_required")
+	}
+	if !slices.Contains(frpProxyTypes, p.Type) {
+		return msgError("proxy_error", p.Name, msgError("unknown_proxy_type", p.Type, strings.Join(frpProxyTypes, ", ")))
+	}
+	if slices.Contains(secretProxyTypes, p.Type) && p.SecretKey == "" {
+		return msgError("proxy_error", p.Name, msgError("secret_key_required", p.Type))
+	}
+	fields := map[string]string{
+		"name": p.Name, "type": p.Type, "localIP": p.LocalIP,
+		"group": p.Group, "groupKey": p.GroupKey, "secretKey": p.SecretKey,
+	}
+	for i, d := range p.CustomDomains {
+		fields[fmt.Sprintf("customDomains[%d]", i)] = d
+	}
+	for field, value := range fields {
+		if err := validateTomlString(field, value); err != nil {
+			return msgError("proxy_error", p.Name, err)
+		}
+	}
+	for field, value := range map[string]string{"localPort": p.LocalPort, "remotePort": p.RemotePort} {
+		if value == "" {
+			continue
+		}
+		if err := validatePort(field, value); err != nil {
+			return msgError("proxy_error", p.Name, err)
+		}
+	}
+	if v := p.ProxyProtocolVersion; v != "" && v != "v1" && v != "v2" {
+		return msgError("proxy_error", p.Name, msgError("invalid_proxy_protocol", p.ProxyProtocolVersion))
+	}
+	if err := validateTags(p.Tags); err != nil {
+		return msgError("proxy_error", p.Name, err)
+	}
+	if err := validateExtraConfig(p.Extra); err != nil {
+		return msgError("proxy_error", p.Name, err)
+	}
+	return nil
+}
+
+// sameFrpProxy reports whether two proxies would render the same block
+func sameFrpProxy(a, b FrpProxy) bool {
+	return strings.Join(frpProxyBlock(a), "\n") == strings.Join(frpProxyBlock(b), "\n")
+}
+
+// ApplyAction is one change made by applyFrpProxies
+type ApplyAction struct {
+	Action string `json:"action"` // "add", "update" or "remove"
+	Name   string `json:"name"`
+}
+
+// restoreRedactedSecrets puts the stored secrets back into proxies that
+// carry redactedSecret, as a listing fetched for editing does
+func restoreRedactedSecrets(proxies []FrpProxy) error {
+	current, err := getFrpProxies()
+	if err != nil {
+		return err
+	}
+	stored := make(map[string]FrpProxy, len(current))
+	for _, p := range current {
+		stored[p.Name] = p
+	}
+	for i := range proxies {
+		old := stored[proxies[i].Name]
+		if proxies[i].SecretKey == redactedSecret {
+			proxies[i].SecretKey = old.SecretKey
+		}
+		if proxies[i].GroupKey == redactedSecret {
+			proxies[i].GroupKey = old.GroupKey
+		}
+	}
+	return nil
+}
+
+// applyFrpProxies computes the edits that turn the current frpc.toml into
+// one holding exactly the desired proxies. Unchanged blocks are kept
+// verbatim, changed ones are rewritten in place and new ones appended. The
+// web UI proxy is never removed. It returns the new lines and the actions.
+func applyFrpProxies(lines []string, desired []FrpProxy) ([]string, []ApplyAction, error) {
+	want := make(map[string]FrpProxy)
+	for _, p := range desired {
+		p.Type = normalizeProxyType(p.Type)
+		if err := validateFrpProxy(p); err != nil {
+			return nil, nil, err
+		}
+		if _, dup := want[p.Name]; dup {
+			return nil, nil, msgError("duplicate_proxy_name", p.Name)
+		}
+		want[p.Name] = p
+	}
+
+	actions := []ApplyAction{}
+	seen := make(map[string]bool)
+	var kept []tomlBlock
+	for _, block := range splitTomlBlocks(lines) {
+		if !block.Proxy {
+			kept = append(kept, block)
+			continue
+		}
+		p, ok := want[block.Name]
+		if !ok || seen[block.Name] {
+			if block.Name == webUIProxyFullName() {
+				kept = append(kept, block)
+				continue
+			}
+			actions = append(actions, ApplyAction{Action: "remove", Name: block.Name})
+			continue
+		}
+		seen[block.Name] = true
+
+		current, err := parseFrpProxies(strings.NewReader(strings.Join(block.Lines, "\n")))
+		if err == nil && len(current) == 1 && sameFrpProxy(current[0], p) {
+			kept = append(kept, block)
+			continue
+		}
+		_, tail := splitTrailingBlank(block.Lines)
+		block.Lines = append(frpProxyBlock(p), tail...)
+		kept = append(kept, block)
+		actions = append(actions, ApplyAction{Action: "update", Name: p.Name})
+	}
+
+	result := joinTomlBlocks(kept)
+	for _, p := range desired {
+		if seen[p.Name] {
+			continue
+		}
+		p = want[p.Name]
+		body, _ := splitTrailingBlank(result)
+		result = append(append(body, ""), frpProxyBlock(p)...)
+		result = append(result, "")
+		actions = append(actions, ApplyAction{Action: "add", Name: p.Name})
+	}
+	return result, actions, nil
+}
+
+// reorderFrpProxies rewrites frpc.toml so the proxy blocks appear in the given
+// order. names must contain exactly the proxy names currently in the file.
+func reorderFrpProxies(names []string) error {
+	lines, eol, err := readProxiesToml()
+	if err != nil {
+		return err
+	}
+
+	blocks := splitTomlBlocks(lines)
+
+	bodies := make(map[string][]string)
+	var slots []int
+	for i, b := range blocks {
+		if !b.Proxy {
+			continue
+		}
+		if b.Name == "" {
+			return msgError("proxy_missing_name", len(slots)+1)
+		}
+		if _, exists := bodies[b.Name]; exists {
+			return msgError("duplicate_proxy_name", b.Name)
+		}
+		body, _ := splitTrailingBlank(b.Lines)
+		bodies[b.Name] = body
+		slots = append(slots, i)
+	}
+
+	if len(names) != len(slots) {
+		return msgError("reorder_count_mismatch", len(names), len(slots))
+	}
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if _, exists := bodies[name]; !exists {
+			return msgError("proxy_not_found", name)
+		}
+		if seen[name] {
+			return msgError("duplicate_name", name)
+		}
+		seen[name] = true
+	}
+
+	// Fill each proxy slot with the next proxy in the requested order while
+	// keeping the slot's own trailing blank lines where they were
+	for i, slot := range slots {
+		_, tail := splitTrailingBlank(blocks[slot].Lines)
+		if len(tail) == 0 && slot < len(blocks)-1 {
+			// The file's last block may have had no trailing newline
+			tail = []string{""}
+		}
+		lines := append([]string{}, bodies[names[i]]...)
+		blocks[slot] = tomlBlock{Proxy: true, Name: names[i], Lines: append(lines, tail...)}
+	}
+
+	return writeProxiesToml(joinTomlBlocks(blocks), eol)
+}
+
+// ========================================
+// FRP Process Management
+// ========================================
+
+// runCommand runs a short-lived external command without a window and returns
+// its stdout. The command is killed if it exceeds the configured timeout.
+func runCommand(name string, args ...string) ([]byte, error) {
+	return runCommandTimeout(secondsOrDefault(config.CommandTimeoutSecs, defaultCommandTimeout), name, args...)
+}
+
+// runCommandTimeout is runCommand with an explicit timeout, for commands such
+// as batched scripts whose run time scales with their size
+func runCommandTimeout(timeout time.Duration, name string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	hideWindow(cmd)
+	output, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return output, fmt.Errorf("命令 %s 超时 (%v)", name, timeout)
+	}
+	return output, err
+}
+
+// getFrpcExeName extracts the executable name from frpcExePath
+func getFrpcExeName() string {
+	return filepath.Base(config.FrpcExePath)
+}
+
+// isExecutableFile reports whether path is a regular file that can be run
+func isExecutableFile(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		return true
+	}
+	return info.Mode().Perm()&0111 != 0
+}
+
+// probeFrpcExe checks that the configured frpc executable exists and falls back
+// to searching PATH for it. It returns the path to use and whether one was found.
+func probeFrpcExe() (string, bool) {
+	if isExecutableFile(config.FrpcExePath) {
+		return config.FrpcExePath, true
+	}
+	if path, err := exec.LookPath(getFrpcExeName()); err == nil {
+		return path, true
+	}
+	return "", false
+}
+
+// Machine-readable error codes reported in /api/frpc/status
+const (
+	errCodeTasklistFailed = "tasklist_failed"
+	errCodeTasklistParse  = "tasklist_parse_failed"
+	errCodeWmicFailed     = "wmic_failed"
+	errCodeUnknown        = "unknown"
+)
+
+// StatusError is a process lookup failure with a stable code for automation
+// and a human-readable message
+type StatusError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *StatusError) Error() string {
+	return e.Message
+}
+
+// getFrpcProcess finds the running frpc process
+func getFrpcProcess() (*os.Process, error) {
+	if runtime.GOOS != "windows" {
+		log.Println("[模拟] 查找 frpc 进程")
+		return nil, nil
+	}
+
+	// Get the executable name from config
+	exeName := getFrpcExeName()
+
+	// Use tasklist to find the process
+	output, err := runCommand("tasklist", "/FI", fmt.Sprintf("IMAGENAME eq %s", exeName), "/FO", "CSV", "/NH")
+	if err != nil {
+		// tasklist may be blocked in locked-down environments; fall back
+		// to a Toolhelp32 snapshot
+		pids, snapErr := findProcessesByName(exeName)
+		if snapErr != nil {
+			return nil, &StatusError{Code: errCodeTasklistFailed, Message: fmt.Sprintf("tasklist 执行失败: %v (进程快照也失败: %v)", err, snapErr)}
+		}
+		if len(pids) == 0 {
+			return nil, nil
+		}
+		return os.FindProcess(pids[0])
+	}
+
+	// Parse CSV output
+	reader := csv.NewReader(strings.NewReader(string(output)))
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, &StatusError{Code: errCodeTasklistParse, Message: fmt.Sprintf("parsing tasklist output: %v", err)}
+	}
+
+	for _, record := range records {
+		if len(record) >= 2 && record[0] == exeName {
+			pidStr := record[1]
+			var pid int
+			if _, err := fmt.Sscanf(pidStr, "%d", &pid); err != nil {
+				continue
+			}
+
+			process, err := os.FindProcess(pid)
+			if err != nil {
+				return nil, err
+			}
+			return process, nil
+		}
+	}
+
+	return nil, nil // Process not found
+}
+
+// getProcessCommandLine returns the full command line of a process via wmic
+func getProcessCommandLine(pid int) (string, error) {
+	output, err := runCommand("wmic", "process", "where", fmt.Sprintf("ProcessId=%d", pid), "get", "CommandLine", "/value")
+	if err != nil {
+		return "", &StatusError{Code: errCodeWmicFailed, Message: fmt.Sprintf("wmic 执行失败: %v", err)}
+	}
+
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "CommandLine=") {
+			return strings.TrimPrefix(line, "CommandLine="), nil
+		}
+	}
+	return "", &StatusError{Code: errCodeWmicFailed, Message: "wmic 输出中没有 CommandLine"}
+}
+
+// splitCommandLine splits a Windows command line into arguments, honouring
+// double quotes
+func splitCommandLine(cmdline string) []string {
+	var args []string
+	var current strings.Builder
+	inQuotes, hasArg := false, false
+	for _, c := range cmdline {
+		switch {
+		case c == '"':
+			inQuotes = !inQuotes
+			hasArg = true
+		case (c == ' ' || c == '\t') && !inQuotes:
+			if hasArg {
+				args = append(args, current.String())
+				current.Reset()
+				hasArg = false
+			}
+		default:
+			current.WriteRune(c)
+			hasArg = true
+		}
+	}
+	if hasArg {
+		args = append(args, current.String())
+	}
+	return args
+}
+
+// joinCommandLine is the inverse of splitCommandLine, quoting arguments that
+// contain spaces
+func joinCommandLine(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t") {
+			arg = `"` + arg + `"`
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
+
+// frpcShellMetachars are refused in frpcExtraArgs. frpc is started without a
+// shell, but these have no business in a flag and would change meaning if
+// the command were ever pasted into cmd.exe.
+const frpcShellMetachars = "&|;<>^%$`\"\r\n"
+
+// validateFrpcExtraArgs checks frpcExtraArgs; the config file is always the
+// manager's, so -c/--config cannot be overridden
+func validateFrpcExtraArgs(args []string) error {
+	for _, arg := range args {
+		if strings.ContainsAny(arg, frpcShellMetachars) {
+			return fmt.Errorf("frpcExtraArgs 参数 %q 包含非法字符", arg)
+		}
+		name, _, _ := strings.Cut(arg, "=")
+		if name == "-c" || name == "--config" {
+			return fmt.Errorf("frpcExtraArgs 不能指定 %s (配置文件由 frpcTomlPath 决定)", name)
+		}
+	}
+	return nil
+}
+
+// frpcArgs returns the arguments frpc is started with
+func frpcArgs() []string {
+	return append([]string{"-c", config.FrpcTomlPath}, config.FrpcExtraArgs...)
+}
+
+// configPathFromArgs extracts the -c/--config value from frpc's arguments
+func configPathFromArgs(args []string) string {
+	for i, arg := range args {
+		for _, flag := range []string{"-c", "--config"} {
+			if arg == flag && i+1 < len(args) {
+				return args[i+1]
+			}
+			if strings.HasPrefix(arg, flag+"=") {
+				return strings.TrimPrefix(arg, flag+"=")
+			}
+		}
+	}
+	return ""
+}
+
+// samePath reports whether two paths refer to the same file, comparing
+// case-insensitively on Windows
+func samePath(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	if runtime.GOOS == "windows" {
+		return strings.EqualFold(absA, absB)
+	}
+	return absA == absB
+}
+
+// stopFrpc stops the running frpc process
+func stopFrpc() error {
+	defer invalidateFrpcStatus()
+	if runtime.GOOS != "windows" {
+		log.Println("[模拟] 停止 frpc 进程")
+		return nil
+	}
+
+	process, err := getFrpcProcess()
+	if err != nil {
+		return msgError("frpc_find_failed", err)
+	}
+
+	if process == nil {
+		return nil // Already stopped
+	}
+
+	// Get the executable name from config
+	exeName := getFrpcExeName()
+
+	// Kill the process using taskkill for more reliable termination,
+	// falling back to TerminateProcess when taskkill is unavailable
+	if _, err := runCommand("taskkill", "/F", "/IM", exeName); err != nil {
+		if killErr := process.Kill(); killErr != nil {
+			return msgError("frpc_kill_failed", err, killErr)
+		}
+		log.Printf("taskkill 失败 (%v)，已直接结束进程 %d", err, process.Pid)
+	}
+
+	log.Printf("%s 进程已停止", exeName)
+	return nil
+}
+
+// startFrpc starts the frpc process
+func startFrpc() error {
+	defer invalidateFrpcStatus()
+	if runtime.GOOS != "windows" {
+		log.Println("[模拟] 启动 frpc 进程")
+		recordFrpcRunningConfig()
+		return nil
+	}
+
+	// Check if already running
+	process, err := getFrpcProcess()
+	if err != nil {
+		return msgError("frpc_check_failed", err)
+	}
+
+	if process != nil {
+		return msgError("frpc_already_running")
+	}
+
+	exePath, found := probeFrpcExe()
+	if !found {
+		return msgError("frpc_exe_not_found", config.FrpcExePath)
+	}
+
+	// Start frpc in background
+	cmd := exec.Command(exePath, frpcArgs()...)
+	hideWindow(cmd)
+	if config.FrpcPriority != "" {
+		setPriorityClass(cmd, config.FrpcPriority)
+	}
+
+	// Redirect output to log files
+	logFile, err := openFrpcLog()
+	if err != nil {
+		return msgError("frpc_log_create_failed", err)
+	}
+
+	// Tee output into the in-memory buffer used by the logs endpoints
+	output := io.MultiWriter(logFile, frpcLogRing)
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	if err := cmd.Start(); err != nil {
+		logFile.Close()
+		return msgError("frpc_start_failed", err)
+	}
+
+	if config.FrpcPriority != "" {
+		log.Printf("frpc 进程优先级: %s", config.FrpcPriority)
+	}
+	if config.FrpcCPUAffinity != 0 {
+		if err := setProcessAffinity(cmd.Process.Pid, config.FrpcCPUAffinity); err != nil {
+			log.Printf("警告: 设置 frpc CPU 亲和性失败: %v", err)
+		} else {
+			log.Printf("frpc CPU 亲和性掩码: %#x", config.FrpcCPUAffinity)
+		}
+	}
+
+	// Don't wait for the process
+	go func() {
+		cmd.Wait()
+		logFile.Close()
+	}()
+
+	recordFrpcRunningConfig()
+	if len(config.FrpcExtraArgs) > 0 {
+		log.Printf("frpc 附加参数: %s", joinCommandLine(config.FrpcExtraArgs))
+	}
+	log.Printf("frpc 已启动 (PID: %d, 日志: %s)", cmd.Process.Pid, logFile.Name())
+	return nil
+}
+
+var (
+	frpcLogMu     sync.Mutex
+	frpcLogActive = frpcLogFile
+)
+
+// frpcLogPath returns the log file frpc is currently writing to
+func frpcLogPath() string {
+	frpcLogMu.Lock()
+	defer frpcLogMu.Unlock()
+	return frpcLogActive
+}
+
+// openFrpcLog opens frpc.log for appending. If another process holds it open
+// without sharing (e.g. a log viewer on Windows), it falls back to a dated
+// frpc-YYYYMMDD.log so frpc can still start.
+func openFrpcLog() (*os.File, error) {
+	path := frpcLogFile
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil && isSharingViolation(err) {
+		path = fmt.Sprintf("frpc-%s.log", time.Now().Format("20060102"))
+		log.Printf("警告: %s 被其他进程占用，改为写入 %s", frpcLogFile, path)
+		f, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	frpcLogMu.Lock()
+	frpcLogActive = path
+	frpcLogMu.Unlock()
+	return f, nil
+}
+
+// restartFrpc restarts the frpc process
+func restartFrpc() error {
+	return restartFrpcDetailed().err()
+}
+
+// RestartResult reports each phase of a restart and the state it left frpc in
+type RestartResult struct {
+	Stopped    bool   `json:"stopped"`
+	StopError  string `json:"stopError,omitempty"`
+	Started    bool   `json:"started"`
+	StartError string `json:"startError,omitempty"`
+	// Rollback explains what happened when frpc exited right after starting
+	Rollback string `json:"rollback,omitempty"`
+	Running  bool   `json:"running"`
+	// Outcome is ok, stop_failed_start_ok, stop_ok_start_failed,
+	// both_failed or rolled_back
+	Outcome string `json:"outcome"`
+}
+
+// err returns the error restartFrpc reports: a failed stop alone is only a
+// warning as long as frpc started
+func (r RestartResult) err() error {
+	switch {
+	case r.StartError != "":
+		return errors.New(r.StartError)
+	case r.Rollback != "":
+		return errors.New(r.Rollback)
+	}
+	return nil
+}
+
+// restartFrpcDetailed stops and starts frpc, recording how each phase went
+func restartFrpcDetailed() RestartResult {
+	log.Println("正在重启 frpc...")
+	var result RestartResult
+
+	// Stop if running
+	if err := stopFrpc(); err != nil {
+		log.Printf("警告: 停止 frpc 时出错: %v", err)
+		result.StopError = err.Error()
+	} else {
+		result.Stopped = true
+	}
+
+	// Wait a moment for the process to fully stop
+	if runtime.GOOS == "windows" {
+		runCommand("timeout", "/t", "1", "/nobreak")
+	}
+
+	// Start frpc
+	if err := startFrpc(); err != nil {
+		result.StartError = err.Error()
+	} else {
+		result.Started = true
+		if runtime.GOOS == "windows" {
+			if frpcStaysUp() {
+				saveLastGoodFrpcToml()
+			} else {
+				result.Rollback = rollbackFrpcToml().Error()
+			}
+		}
+	}
+
+	result.Running = result.Started
+	if runtime.GOOS == "windows" {
+		process, err := getFrpcProcess()
+		result.Running = err == nil && process != nil
+	}
+
+	switch {
+	case result.Rollback != "":
+		result.Outcome = "rolled_back"
+	case result.StopError != "" && result.StartError != "":
+		result.Outcome = "both_failed"
+	case result.StopError != "":
+		result.Outcome = "stop_failed_start_ok"
+	case result.StartError != "":
+		result.Outcome = "stop_ok_start_failed"
+	default:
+		result.Outcome = "ok"
+	}
+	return result
+}
+
+// restartPending is set when an edit skipped its restart via ?noRestart=true
+var restartPending atomic.Bool
+
+// frpcConfigSnapshot is the configuration frpc was last started or
+// reloaded with: the non-proxy lines of frpc.toml and the proxies by name
+type frpcConfigSnapshot struct {
+	Settings string
+	Proxies  map[string]FrpProxy
+	Visitors string
+}
+
+// frpcRunningConfig is nil until frpc is started by the manager
+var frpcRunningConfig atomic.Pointer[frpcConfigSnapshot]
+
+// takeFrpcConfigSnapshot reads the current config. Comments, blank lines and
+// proxy tags are ignored since frpc does not act on them.
+func takeFrpcConfigSnapshot() (*frpcConfigSnapshot, error) {
+	lines, _, err := readFrpcToml()
+	if err != nil {
+		return nil, err
+	}
+	separate := proxiesTomlPath() != config.FrpcTomlPath
+
+	var settings []string
+	for _, block := range splitTomlBlocks(lines) {
+		// With a managed proxies file, proxies left in frpc.toml are only
+		// compared as text
+		if block.Proxy && !separate {
+			continue
+		}
+		for _, line := range block.Lines {
+			trimmed := strings.TrimSpace(line)
+			if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+				continue
+			}
+			settings = append(settings, trimmed)
+		}
+	}
+
+	proxies, err := getFrpProxies()
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &frpcConfigSnapshot{
+		Settings: strings.Join(settings, "\n"),
+		Proxies:  make(map[string]FrpProxy, len(proxies)),
+	}
+	for _, p := range proxies {
+		p.Tags = nil
+		snapshot.Proxies[p.Name] = p
+	}
+
+	visitors, err := getFrpVisitors()
+	if err != nil {
+		return nil, err
+	}
+	var rendered []string
+	for _, v := range visitors {
+		rendered = append(rendered, frpVisitorBlock(v)...)
+	}
+	snapshot.Visitors = strings.Join(rendered, "\n")
+	return snapshot, nil
+}
+
+// recordFrpcRunningConfig remembers the config frpc is now running with
+func recordFrpcRunningConfig() {
+	snapshot, err := takeFrpcConfigSnapshot()
+	if err != nil {
+		log.Printf("警告: 记录 frpc 运行配置失败: %v", err)
+		frpcRunningConfig.Store(nil)
+		return
+	}
+	frpcRunningConfig.Store(snapshot)
+}
+
+// planFrpcApply compares the config on disk with the one frpc is running and
+// returns "skip" when nothing frpc uses changed, "reload" when proxies were
+// only added (existing connections are untouched), or "restart" otherwise,
+// with a human-readable reason
+func planFrpcApply() (string, string) {
+	running := frpcRunningConfig.Load()
+	if running == nil {
+		return "restart", "无法确定 frpc 当前运行的配置"
+	}
+	current, err := takeFrpcConfigSnapshot()
+	if err != nil {
+		return "restart", fmt.Sprintf("读取配置失败: %v", err)
+	}
+	if current.Settings != running.Settings {
+		return "restart", "frpc 全局配置已修改"
+	}
+	if current.Visitors != running.Visitors {
+		return "restart", "visitor 配置已修改"
+	}
+
+	var added, disrupted []string
+	for name, p := range current.Proxies {
+		old, ok := running.Proxies[name]
+		if !ok {
+			added = append(added, name)
+		} else if !sameFrpProxy(old, p) {
+			disrupted = append(disrupted, name)
+		}
+	}
+	for name := range running.Proxies {
+		if _, ok := current.Proxies[name]; !ok {
+			disrupted = append(disrupted, name)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(disrupted)
+
+	switch {
+	case len(disrupted) > 0:
+		return "restart", "以下代理被修改或删除: " + strings.Join(disrupted, ", ")
+	case len(added) > 0:
+		return "reload", "仅新增代理: " + strings.Join(added, ", ")
+	default:
+		return "skip", "运行中的代理配置没有变化"
+	}
+}
+
+// RestartDecision tells the client what a config edit did to frpc and why
+type RestartDecision struct {
+	Action string `json:"action"` // restarted, reloaded, skipped, deferred, paused, not_running or failed
+	Reason string `json:"reason"`
+}
+
+// restartAfterEdit applies a config edit to the running frpc, unless the
+// request asked for ?noRestart=true, in which case the restart is left
+// pending for /api/frpc/apply-pending. Edits that leave running proxies
+// untouched skip the restart, and pure additions use a hot reload.
+func restartAfterEdit(r *http.Request) RestartDecision {
+	if r.URL.Query().Get("noRestart") == "true" {
+		restartPending.Store(true)
+		log.Println("已按 noRestart 跳过 frpc 重启，修改将在 /api/frpc/apply-pending 时生效")
+		return RestartDecision{Action: "deferred", Reason: "请求指定了 noRestart，修改将在 /api/frpc/apply-pending 时生效"}
+	}
+	if frpcPaused() {
+		log.Println("转发已暂停，修改将在 /api/resume 后生效")
+		return RestartDecision{Action: "paused", Reason: "转发已暂停，修改将在 /api/resume 后生效"}
+	}
+
+	reason := "配置已修改"
+	if config.AlwaysRestartOnEdit {
+		reason = "已配置 alwaysRestartOnEdit"
+	} else {
+		var action string
+		action, reason = planFrpcApply()
+		switch action {
+		case "skip":
+			restartPending.Store(false)
+			log.Printf("跳过 frpc 重启: %s", reason)
+			return RestartDecision{Action: "skipped", Reason: reason}
+		case "reload":
+			_, err := sendFrpcSignal("reload")
+			if err == nil {
+				recordFrpcRunningConfig()
+				restartPending.Store(false)
+				log.Printf("frpc 已热重载: %s", reason)
+				return RestartDecision{Action: "reloaded", Reason: reason}
+			}
+			log.Printf("frpc 热重载失败 (%v)，改为重启", err)
+			reason += "；热重载失败，已改为重启"
+		}
+	}
+
+	restarted, err := restartFrpcIfRunning()
+	if err != nil {
+		log.Printf("警告: 重启 frpc 失败: %v", err)
+		return RestartDecision{Action: "failed", Reason: fmt.Sprintf("%s；重启失败: %v", reason, err)}
+	}
+	if !restarted {
+		return RestartDecision{Action: "not_running", Reason: "frpc 未运行，修改将在下次启动时生效"}
+	}
+	return RestartDecision{Action: "restarted", Reason: reason}
+}
+
+// frpcLivenessDelay is how long frpc must survive after starting to count as
+// up; a bad config makes it exit well within this
+const frpcLivenessDelay = 2 * time.Second
+
+// frpcStaysUp waits briefly and reports whether frpc is still running
+func frpcStaysUp() bool {
+	time.Sleep(frpcLivenessDelay)
+	process, err := getFrpcProcess()
+	return err == nil && process != nil
+}
+
+// lastGoodTomlPath holds the last version of path frpc was seen running with
+func lastGoodTomlPath(path string) string {
+	return path + ".lastgood"
+}
+
+// saveLastGoodFrpcToml snapshots frpc.toml and the managed proxies file
+// together, so a rollback restores a pair that worked
+func saveLastGoodFrpcToml() {
+	for _, path := range managedTomlPaths() {
+		content, err := readManagedFile(path)
+		if err != nil {
+			continue
+		}
+		if err := os.WriteFile(lastGoodTomlPath(path), content, 0644); err != nil {
+			log.Printf("警告: 保存可用配置快照失败: %v", err)
+		}
+	}
+}
+
+// lastGoodToml returns the last version of path frpc ran with, or the newest
+// backup if there is no snapshot, along with where it came from
+func lastGoodToml(path string) (string, []byte, error) {
+	source := lastGoodTomlPath(path)
+	good, err := os.ReadFile(source)
+	if err == nil {
+		return source, good, nil
+	}
+	backups, _ := filepath.Glob(path + ".*.bak")
+	if len(backups) == 0 {
+		return "", nil, fmt.Errorf("frpc 启动后立即退出，且没有可回滚的配置备份")
+	}
+	// Timestamped names sort chronologically
+	sort.Strings(backups)
+	source = backups[len(backups)-1]
+	if good, err = os.ReadFile(source); err != nil {
+		return "", nil, fmt.Errorf("frpc 启动后立即退出，且读取备份 %s 失败: %v", source, err)
+	}
+	return source, good, nil
+}
+
+// rollbackFrpcToml is called when frpc exits right after a restart. For each
+// of frpc.toml and the managed proxies file that differs from the last
+// version frpc ran with (or the newest backup if there is none), it keeps
+// the failing file as <file>.<time>.failed and restores the good one, then
+// starts frpc again. The returned error always explains what happened.
+func rollbackFrpcToml() error {
+	type rollback struct {
+		path, source  string
+		current, good []byte
+	}
+	var changed []rollback
+	var sourceErr error
+	for _, path := range managedTomlPaths() {
+		current, err := readManagedFile(path)
+		if err != nil {
+			return fmt.Errorf("frpc 启动后立即退出，且无法读取 %s: %v", filepath.Base(path), err)
+		}
+		source, good, err := lastGoodToml(path)
+		if err != nil {
+			sourceErr = cmp.Or(sourceErr, err)
+			continue
+		}
+		if !bytes.Equal(current, good) {
+			changed = append(changed, rollback{path, source, current, good})
+		}
+	}
+	if len(changed) == 0 {
+		if sourceErr != nil {
+			return sourceErr
+		}
+		// The config is not to blame when it has not changed, e.g. frps is
+		// down and loginFailExit is set
+		return fmt.Errorf("frpc 启动后立即退出 (配置与上次可用版本相同，未回滚)，请查看 frpc 日志")
+	}
+
+	stamp := time.Now().Format("20060102-150405")
+	var sources, failed []string
+	for _, c := range changed {
+		failedPath := fmt.Sprintf("%s.%s.failed", c.path, stamp)
+		if err := os.WriteFile(failedPath, c.current, 0644); err != nil {
+			return fmt.Errorf("frpc 启动后立即退出，且保存失败配置失败: %v", err)
+		}
+		sources = append(sources, c.source)
+		failed = append(failed, failedPath)
+	}
+	for _, c := range changed {
+		if err := os.WriteFile(c.path, c.good, 0644); err != nil {
+			return fmt.Errorf("frpc 启动后立即退出，且回滚 %s 失败: %v", filepath.Base(c.path), err)
+		}
+	}
+	noteFrpcTomlWrite()
+	source, failedPath := strings.Join(sources, ", "), strings.Join(failed, ", ")
+	log.Printf("frpc 使用新配置启动后立即退出，已回滚到 %s (新配置保存在 %s)", source, failedPath)
+
+	if err := startFrpc(); err != nil {
+		return fmt.Errorf("新配置导致 frpc 退出，已回滚到 %s，但重新启动失败: %v", source, err)
+	}
+	if !frpcStaysUp() {
+		return fmt.Errorf("新配置导致 frpc 退出，已回滚到 %s，但 frpc 仍无法保持运行", source)
+	}
+	return fmt.Errorf("新配置导致 frpc 启动后立即退出，已回滚到 %s 并重新启动 (新配置保存在 %s)", source, failedPath)
+}
+
+// restartFrpcIfRunning restarts frpc only when it is currently running, so a
+// config edit never resurrects an instance the user deliberately stopped.
+// It reports whether a restart was performed.
+func restartFrpcIfRunning() (bool, error) {
+	restarted, err := restartFrpcIfRunningNow()
+	if err == nil {
+		restartPending.Store(false)
+	}
+	return restarted, err
+}
+
+func restartFrpcIfRunningNow() (bool, error) {
+	if frpcPaused() {
+		log.Println("转发已暂停，跳过重启")
+		return false, nil
+	}
+	if runtime.GOOS != "windows" {
+		return true, restartFrpc()
+	}
+
+	process, err := getFrpcProcess()
+	if err != nil {
+		return false, msgError("frpc_check_failed", err)
+	}
+	if process == nil {
+		log.Println("frpc 未运行，跳过重启")
+		return false, nil
+	}
+	return true, restartFrpc()
+}
+
+// getFrpcStatus returns the status of frpc process
+func getFrpcStatus() map[string]interface{} {
+	status := map[string]interface{}{
+		"running": false,
+		"pid":     0,
+	}
+
+	exePath, found := probeFrpcExe()
+	status["exeFound"] = found
+	status["exePath"] = exePath
+	if found {
+		// What startFrpc launches; commandLine below is what is running
+		status["launchCommandLine"] = joinCommandLine(append([]string{exePath}, frpcArgs()...))
+	}
+	status["stopDelayed"] = frpcStopDelayed.Load()
+	status["restartPending"] = restartPending.Load()
+	if lines, _, err := readFrpcToml(); err == nil {
+		if problems := findTomlProblems(lines); len(problems) > 0 {
+			status["tomlProblems"] = problems
+		}
+	}
+	if next := nextScheduledRestart(); !next.IsZero() {
+		status["nextScheduledRestart"] = next.Format(time.RFC3339)
+	}
+
+	if runtime.GOOS != "windows" {
+		status["running"] = false
+		status["message"] = "模拟模式"
+		status["messageCode"] = "simulation"
+		return status
+	}
+
+	process, err := getFrpcProcess()
+	if err != nil {
+		var statusErr *StatusError
+		if !errors.As(err, &statusErr) {
+			statusErr = &StatusError{Code: errCodeUnknown, Message: err.Error()}
+		}
+		// "error" stays a plain string for existing clients
+		status["error"] = statusErr.Message
+		status["errorInfo"] = statusErr
+		return status
+	}
+
+	if process != nil {
+		status["running"] = true
+		status["pid"] = process.Pid
+
+		// Flag instances started outside the manager with a different config
+		cmdline, err := getProcessCommandLine(process.Pid)
+		if err != nil {
+			var statusErr *StatusError
+			if errors.As(err, &statusErr) {
+				status["commandLineError"] = statusErr
+			}
+		} else {
+			configPath := configPathFromArgs(splitCommandLine(cmdline))
+			status["commandLine"] = cmdline
+			status["configPath"] = configPath
+			status["configMismatch"] = configPath == "" || !samePath(configPath, config.FrpcTomlPath)
+			if configPath == "" {
+				status["message"] = fmt.Sprintf("运行中的 frpc 未通过 -c 指定配置，与管理器的 %s 不一致", config.FrpcTomlPath)
+				status["messageCode"] = "config_unspecified"
+			} else if status["configMismatch"] == true {
+				status["message"] = fmt.Sprintf("运行中的 frpc 使用的配置 (%s) 与管理器的 %s 不一致", configPath, config.FrpcTomlPath)
+				status["messageCode"] = "config_mismatch"
+			}
+		}
+	}
+
+	return status
+}
+
+// ========================================
+// FRP Control API Handlers
+// ========================================
+
+func handleStartFrpc(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if frpcPaused() {
+		writeMsgError(w, r, http.StatusConflict, "paused")
+		return
+	}
+
+	if err := startFrpc(); err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	// A fresh start loads every queued edit
+	restartPending.Store(false)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success", "message": msg(r, "frpc_started")})
+}
+
+// maxStopDelay caps ?delay= on /api/frpc/stop. ?drain=, the option's
+// original name, is accepted as an alias; both mean a fixed wait.
+const maxStopDelay = 5 * time.Minute
+
+// frpcStopDelayed is set while a stop request waits out its delay
+var frpcStopDelayed atomic.Bool
+
+func handleStopFrpc(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var delay time.Duration
+	if v := cmp.Or(r.URL.Query().Get("delay"), r.URL.Query().Get("drain")); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil {
+			v = fmt.Sprintf("%ds", secs)
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 || d > maxStopDelay {
+			writeMsgError(w, r, http.StatusBadRequest, "invalid_stop_delay")
+			return
+		}
+		delay = d
+	}
+
+	delayed := false
+	if delay > 0 {
+		disableWriteDeadline(w)
+		var err error
+		if delayed, err = delayFrpcStop(r.Context(), delay); err != nil {
+			writeError(w, r, err, http.StatusConflict)
+			return
+		}
+	}
+
+	if err := stopFrpc(); err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "success", "message": msg(r, "frpc_stopped"), "delayed": delayed})
+}
+
+// delayFrpcStop waits a fixed d before frpc is stopped, giving clients a
+// grace period to finish. It is not a drain: frpc keeps accepting new
+// connections, and neither its admin API nor anything else here can tell
+// when existing ones have closed, so the full delay always elapses. It only
+// waits when the admin API answers, i.e. frpc is up and responsive;
+// otherwise it returns false and the caller stops immediately.
+func delayFrpcStop(ctx context.Context, d time.Duration) (bool, error) {
+	if runtime.GOOS != "windows" {
+		log.Printf("[模拟] 停止 frpc 前等待 %v", d)
+		return true, nil
+	}
+
+	resp, err := frpcAdminRequest("GET", "/api/status", nil)
+	if err != nil {
+		log.Printf("frpc 管理 API 不可用 (%v)，不等待直接停止", err)
+		return false, nil
+	}
+	resp.Body.Close()
+
+	if !frpcStopDelayed.CompareAndSwap(false, true) {
+		return false, msgError("frpc_stop_delayed")
+	}
+	defer frpcStopDelayed.Store(false)
+
+	log.Printf("将在 %v 后停止 frpc", d)
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true, nil
+	case <-ctx.Done():
+		return false, msgError("stop_delay_cancelled")
+	}
+}
+
+func handleRestartFrpc(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if frpcPaused() {
+		writeMsgError(w, r, http.StatusConflict, "paused")
+		return
+	}
+
+	result := restartFrpcDetailed()
+	w.Header().Set("Content-Type", "application/json")
+	if err := result.err(); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "error", "message": err.Error(), "result": result})
+		return
+	}
+
+	message := msg(r, "frpc_restarted")
+	if result.StopError != "" {
+		message = msg(r, "frpc_restarted_stop_err", result.StopError)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "success", "message": message, "result": result})
+}
+
+// handleApplyPending performs the restart deferred by ?noRestart=true edits
+func handleApplyPending(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !restartPending.Load() {
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "success", "restarted": false, "message": msg(r, "nothing_pending")})
+		return
+	}
+
+	restarted, err := restartFrpcIfRunning()
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "success", "restarted": restarted})
+}
+
+func handleRestartFrpcIfRunning(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	restarted, err := restartFrpcIfRunning()
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	message := msg(r, "frpc_restarted")
+	if !restarted {
+		message = msg(r, "frpc_not_running_no_restart")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "success", "restarted": restarted, "message": message})
+}
+
+// defaultStatusCacheMs is the status cache TTL when statusCacheMs is unset
+const defaultStatusCacheMs = 1000
+
+var (
+	frpcStatusMu     sync.Mutex
+	frpcStatusCache  map[string]interface{}
+	frpcStatusCached time.Time
+)
+
+// invalidateFrpcStatus drops the cached status after frpc is started or stopped
+func invalidateFrpcStatus() {
+	frpcStatusMu.Lock()
+	frpcStatusCache = nil
+	frpcStatusMu.Unlock()
+}
+
+// cachedFrpcStatus returns getFrpcStatus, reusing a result younger than
+// statusCacheMs unless fresh is set. Cheap in-memory flags are always current.
+func cachedFrpcStatus(fresh bool) map[string]interface{} {
+	ttl := time.Duration(config.StatusCacheMs) * time.Millisecond
+	if config.StatusCacheMs == 0 {
+		ttl = defaultStatusCacheMs * time.Millisecond
+	}
+
+	frpcStatusMu.Lock()
+	defer frpcStatusMu.Unlock()
+	if fresh || ttl <= 0 || frpcStatusCache == nil || time.Since(frpcStatusCached) >= ttl {
+		frpcStatusCache = getFrpcStatus()
+		frpcStatusCached = time.Now()
+	}
+
+	status := maps.Clone(frpcStatusCache)
+	status["stopDelayed"] = frpcStopDelayed.Load()
+	status["restartPending"] = restartPending.Load()
+	status["paused"] = frpcPaused()
+	status["state"] = frpcState(status["running"] == true)
+	status["cachedAt"] = frpcStatusCached.Format(time.RFC3339Nano)
+	return status
+}
+
+func handleFrpcStatus(w http.ResponseWriter, r *http.Request) {
+	status := cachedFrpcStatus(r.URL.Query().Get("fresh") == "true")
+	localizeFrpcStatus(r, status)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// ========================================
+// FRP Config Maintenance
+// ========================================
+
+// backupTomlFile copies path to a timestamped .bak file next to it and
+// returns the backup path
+func backupTomlFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	backupPath := fmt.Sprintf("%s.%s.bak", path, time.Now().Format("20060102-150405"))
+	if err := os.WriteFile(backupPath, content, 0644); err != nil {
+		return "", err
+	}
+
+	log.Printf("已备份 %s 到 %s", filepath.Base(path), backupPath)
+	return backupPath, nil
+}
+
+// lineDiff returns a unified-style diff of two line slices, showing changed
+// lines prefixed with "-" or "+" and up to two unchanged lines of context
+func lineDiff(a, b []string) string {
+	// Longest common subsequence table
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var ops []string
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, " "+a[i])
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, "-"+a[i])
+			i++
+		default:
+			ops = append(ops, "+"+b[j])
+			j++
+		}
+	}
+
+	const context = 2
+	var sb strings.Builder
+	lastPrinted := -1
+	for k, op := range ops {
+		if op[0] == ' ' {
+			continue
+		}
+		start := k - context
+		if start <= lastPrinted {
+			start = lastPrinted + 1
+		} else if lastPrinted >= 0 || start > 0 {
+			sb.WriteString("@@\n")
+		}
+		if start < 0 {
+			start = 0
+		}
+		end := k + context
+		for m := k + 1; m < len(ops) && m <= end; m++ {
+			if ops[m][0] != ' ' {
+				end = m + context
+			}
+		}
+		if end >= len(ops) {
+			end = len(ops) - 1
+		}
+		for m := start; m <= end; m++ {
+			sb.WriteString(ops[m] + "\n")
+		}
+		lastPrinted = end
+	}
+	return sb.String()
+}
+
+// normalizeProxyBlock rewrites a proxy block with canonical key order and
+// "key = value" spacing. Keys under sub-tables such as [proxies.plugin] keep
+// their position after the top-level keys.
+func normalizeProxyBlock(lines []string) []string {
+	reKey := regexp.MustCompile(`^([A-Za-z0-9_.\-"]+)\s*=\s*(.*)$`)
+	order := []string{"name", "type", "localIP", "localPort", "remotePort"}
+
+	type entry struct {
+		key   string
+		lines []string
+	}
+
+	var header []string
+	var entries []entry
+	var pending []string
+	var rest []string
+	inSubTable := false
+	openBrackets := 0
+
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			continue
+		}
+		if trimmed == "[[proxies]]" && header == nil {
+			header = []string{trimmed}
+			continue
+		}
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") && openBrackets == 0 {
+			inSubTable = true
+		}
+		if m := reKey.FindStringSubmatch(trimmed); m != nil && openBrackets == 0 {
+			trimmed = m[1] + " = " + m[2]
+			openBrackets = strings.Count(m[2], "[") - strings.Count(m[2], "]")
+			if !inSubTable {
+				entries = append(entries, entry{key: m[1], lines: append(pending, trimmed)})
+				pending = nil
+				continue
+			}
+		} else if openBrackets > 0 {
+			// Continuation of a multi-line array value
+			openBrackets += strings.Count(trimmed, "[") - strings.Count(trimmed, "]")
+			if !inSubTable && len(entries) > 0 {
+				last := &entries[len(entries)-1]
+				last.lines = append(last.lines, trimmed)
+				continue
+			}
+		}
+		if inSubTable {
+			rest = append(rest, trimmed)
+		} else {
+			pending = append(pending, trimmed)
+		}
+	}
+
+	rank := func(key string) int {
+		for i, k := range order {
+			if k == key {
+				return i
+			}
+		}
+		return len(order)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return rank(entries[i].key) < rank(entries[j].key)
+	})
+
+	out := append([]string{}, header...)
+	for _, e := range entries {
+		out = append(out, e.lines...)
+	}
+	out = append(out, pending...)
+	return append(out, rest...)
+}
+
+// normalizeFrpcToml parses frpc.toml and returns its normalized form: non-proxy
+// sections stay in place, proxies are sorted by sortBy ("name" or "type") and
+// written with canonical key order, separated by single blank lines
+func normalizeFrpcToml(content, sortBy string) (string, error) {
+	if sortBy == "" {
+		sortBy = "name"
+	}
+	if sortBy != "name" && sortBy != "type" {
+		return "", msgError("unsupported_sort", sortBy)
+	}
+
+	blocks := splitTomlBlocks(strings.Split(content, "\n"))
+
+	var before, proxies, after []tomlBlock
+	for _, b := range blocks {
+		body, _ := splitTrailingBlank(b.Lines)
+		if len(body) == 0 {
+			continue
+		}
+		switch {
+		case b.Proxy:
+			proxies = append(proxies, tomlBlock{Proxy: true, Name: b.Name, Lines: normalizeProxyBlock(body)})
+		case len(proxies) == 0:
+			before = append(before, tomlBlock{Lines: body})
+		default:
+			after = append(after, tomlBlock{Lines: body})
+		}
+	}
+
+	reType := regexp.MustCompile(`^type = ` + tomlBasicString)
+	typeOf := func(b tomlBlock) string {
+		for _, line := range b.Lines {
+			if m := reType.FindStringSubmatch(line); m != nil {
+				return tomlUnescape(m[1])
+			}
+		}
+		return ""
+	}
+	sort.SliceStable(proxies, func(i, j int) bool {
+		if sortBy == "type" {
+			ti, tj := typeOf(proxies[i]), typeOf(proxies[j])
+			if ti != tj {
+				return ti < tj
+			}
+		}
+		return proxies[i].Name < proxies[j].Name
+	})
+
+	var parts []string
+	for _, group := range [][]tomlBlock{before, proxies, after} {
+		for _, b := range group {
+			parts = append(parts, strings.Join(b.Lines, "\n"))
+		}
+	}
+	return strings.Join(parts, "\n\n") + "\n", nil
+}
+
+func handleNormalizeFrpcToml(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		SortBy string `json:"sortBy"`
+		DryRun bool   `json:"dryRun"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+
+	if _, err := normalizeFrpcToml("", req.SortBy); err != nil {
+		writeError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	edits, err := editManagedTomls(func(path string, lines []string) ([]string, error) {
+		normalized, err := normalizeFrpcToml(strings.Join(lines, "\n"), req.SortBy)
+		if err != nil {
+			return nil, err
+		}
+		return strings.Split(normalized, "\n"), nil
+	})
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	result := edits.result()
+	if !req.DryRun {
+		if err := edits.write(result); err != nil {
+			writeError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// managedTomlEdit is a pending rewrite of one of managedTomlPaths
+type managedTomlEdit struct {
+	Path   string
+	Lines  []string
+	Edited []string
+	EOL    string
+	Diff   string
+}
+
+type managedTomlEdits []managedTomlEdit
+
+// editManagedTomls runs edit over frpc.toml and the managed proxies file,
+// returning the resulting edits without writing anything
+func editManagedTomls(edit func(path string, lines []string) ([]string, error)) (managedTomlEdits, error) {
+	var edits managedTomlEdits
+	for _, path := range managedTomlPaths() {
+		lines, eol, err := readTomlFile(path)
+		if os.IsNotExist(err) && path != config.FrpcTomlPath {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		edited, err := edit(path, lines)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", filepath.Base(path), err)
+		}
+		edits = append(edits, managedTomlEdit{Path: path, Lines: lines, Edited: edited, EOL: eol, Diff: lineDiff(lines, edited)})
+	}
+	return edits, nil
+}
+
+// result summarizes the edits: changed and diff cover all files, and files
+// lists each one
+func (edits managedTomlEdits) result() map[string]interface{} {
+	var diffs []string
+	var files []map[string]interface{}
+	for _, e := range edits {
+		if e.Diff != "" {
+			diff := e.Diff
+			if len(edits) > 1 {
+				diff = "# " + e.Path + "\n" + diff
+			}
+			diffs = append(diffs, diff)
+		}
+		files = append(files, map[string]interface{}{"path": e.Path, "changed": e.Diff != "", "diff": e.Diff})
+	}
+	return map[string]interface{}{
+		"status":  "success",
+		"changed": len(diffs) > 0,
+		"diff":    strings.Join(diffs, "\n"),
+		"files":   files,
+	}
+}
+
+// write backs up and rewrites every changed file, recording the backups in
+// result ("backup" is the first one, for single-file clients)
+func (edits managedTomlEdits) write(result map[string]interface{}) error {
+	for i, e := range edits {
+		if e.Diff == "" {
+			continue
+		}
+		name := filepath.Base(e.Path)
+		backupPath, err := backupTomlFile(e.Path)
+		if err != nil {
+			return fmt.Errorf("备份 %s 失败: %v", name, err)
+		}
+		if err := writeTomlFile(e.Path, e.Edited, e.EOL); err != nil {
+			return fmt.Errorf("写入 %s 失败: %v", name, err)
+		}
+		result["files"].([]map[string]interface{})[i]["backup"] = backupPath
+		if result["backup"] == nil {
+			result["backup"] = backupPath
+		}
+	}
+	return nil
+}
+
+// TomlProblem describes a [[proxies]] block that frpc would reject, typically
+// left behind by a write that was interrupted
+type TomlProblem struct {
+	// File is the base name of the file the block is in
+	File string `json:"file,omitempty"`
+	// Line is the 1-based line of the block's [[proxies]] header
+	Line    int    `json:"line"`
+	Name    string `json:"name,omitempty"`
+	Problem string `json:"problem"`
+}
+
+// proxyBlockProblem reports what is wrong with a proxy block, or "" if it
+// looks complete
+func proxyBlockProblem(block tomlBlock) string {
+	body, _ := splitTrailingBlank(block.Lines)
+	keys := make(map[string]bool)
+	hasPlugin := false
+	for _, line := range body[1:] {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "[") {
+			hasPlugin = hasPlugin || trimmed == "[proxies.plugin]"
+			continue
+		}
+		key, value, ok := strings.Cut(trimmed, "=")
+		if !ok {
+			return fmt.Sprintf("无法解析的行: %s", trimmed)
+		}
+		value = strings.TrimSpace(value)
+		if strings.HasPrefix(value, "\"") && (len(value) < 2 || !strings.HasSuffix(value, "\"")) {
+			return fmt.Sprintf("字符串未闭合: %s", trimmed)
+		}
+		if strings.HasPrefix(value, "[") && !strings.HasSuffix(value, "]") {
+			return fmt.Sprintf("数组未闭合: %s", trimmed)
+		}
+		keys[strings.TrimSpace(key)] = true
+	}
+
+	required := []string{"name", "type"}
+	if !hasPlugin {
+		required = append(required, "localPort")
+	}
+	var missing []string
+	for _, key := range required {
+		if !keys[key] {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return "缺少必需的键: " + strings.Join(missing, ", ")
+	}
+	return ""
+}
+
+// findTomlProblems returns the incomplete proxy blocks in lines
+func findTomlProblems(lines []string) []TomlProblem {
+	var problems []TomlProblem
+	line := 1
+	for _, block := range splitTomlBlocks(lines) {
+		if block.Proxy {
+			if problem := proxyBlockProblem(block); problem != "" {
+				problems = append(problems, TomlProblem{Line: line, Name: block.Name, Problem: problem})
+			}
+		}
+		line += len(block.Lines)
+	}
+	return problems
+}
+
+// repairFrpcTomlLines drops every incomplete proxy block
+func repairFrpcTomlLines(lines []string) []string {
+	var kept []tomlBlock
+	for _, block := range splitTomlBlocks(lines) {
+		if block.Proxy && proxyBlockProblem(block) != "" {
+			continue
+		}
+		kept = append(kept, block)
+	}
+	return joinTomlBlocks(kept)
+}
+
+// checkFrpcToml logs any incomplete proxy blocks found at startup in
+// frpc.toml and the managed proxies file
+func checkFrpcToml() {
+	for _, path := range managedTomlPaths() {
+		lines, _, err := readTomlFile(path)
+		if err != nil {
+			continue
+		}
+		for _, p := range findTomlProblems(lines) {
+			log.Printf("警告: %s 第 %d 行的代理 %q 不完整 (%s)，frpc 可能无法启动，可通过 POST /api/frpc/repair 清理", filepath.Base(path), p.Line, p.Name, p.Problem)
+		}
+	}
+}
+
+// handleRepairFrpcToml removes incomplete proxy blocks from frpc.toml. With
+// dryRun it only reports the problems and the diff.
+func handleRepairFrpcToml(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		DryRun bool `json:"dryRun"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+
+	problems := []TomlProblem{}
+	edits, err := editManagedTomls(func(path string, lines []string) ([]string, error) {
+		for _, p := range findTomlProblems(lines) {
+			p.File = filepath.Base(path)
+			problems = append(problems, p)
+		}
+		return repairFrpcTomlLines(lines), nil
+	})
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	result := edits.result()
+	result["problems"] = problems
+	if !req.DryRun {
+		if err := edits.write(result); err != nil {
+			writeError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		if result["changed"] == true {
+			for _, p := range problems {
+				log.Printf("已从 %s 移除不完整的代理 %q (第 %d 行: %s)", p.File, p.Name, p.Line, p.Problem)
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// ========================================
+// FRP Update Check
+// ========================================
+
+const frpLatestReleaseURL = "https://api.github.com/repos/fatedier/frp/releases/latest"
+
+// updateCheckCacheTTL bounds how often GitHub is queried for new releases
+const updateCheckCacheTTL = time.Hour
+
+var (
+	updateCheckMu     sync.Mutex
+	updateCheckCache  map[string]interface{}
+	updateCheckCached time.Time
+)
+
+// getFrpcVersion runs `frpc -v` and returns the reported version
+func getFrpcVersion() (string, error) {
+	exePath, found := probeFrpcExe()
+	if !found {
+		return "", msgError("frpc_exe_not_found", config.FrpcExePath)
+	}
+
+	output, err := runCommand(exePath, "-v")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// compareVersions compares dotted version strings such as "0.61.0" and
+// "v0.62.1", returning -1, 0 or 1
+func compareVersions(a, b string) int {
+	pa := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	pb := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var na, nb int
+		if i < len(pa) {
+			na, _ = strconv.Atoi(pa[i])
+		}
+		if i < len(pb) {
+			nb, _ = strconv.Atoi(pb[i])
+		}
+		if na != nb {
+			if na < nb {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// fetchLatestFrpRelease queries the GitHub releases API for the latest frp release
+func fetchLatestFrpRelease() (version, url string, err error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	req, err := http.NewRequest("GET", frpLatestReleaseURL, nil)
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("GitHub 返回状态 %s", resp.Status)
+	}
+
+	var release struct {
+		TagName string `json:"tag_name"`
+		HTMLURL string `json:"html_url"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		return "", "", err
+	}
+	return strings.TrimPrefix(release.TagName, "v"), release.HTMLURL, nil
+}
+
+// checkFrpcUpdate compares the local frpc version with the latest release,
+// caching the result to stay well clear of GitHub's rate limits
+func checkFrpcUpdate() (map[string]interface{}, error) {
+	updateCheckMu.Lock()
+	defer updateCheckMu.Unlock()
+
+	if updateCheckCache != nil && time.Since(updateCheckCached) < updateCheckCacheTTL {
+		return updateCheckCache, nil
+	}
+
+	latest, releaseURL, err := fetchLatestFrpRelease()
+	if err != nil {
+		return nil, fmt.Errorf("查询最新版本失败: %v", err)
+	}
+
+	result := map[string]interface{}{
+		"currentVersion":  "",
+		"latestVersion":   latest,
+		"updateAvailable": false,
+		"releaseUrl":      releaseURL,
+	}
+
+	current, err := getFrpcVersion()
+	if err != nil {
+		result["error"] = "获取当前 frpc 版本失败: " + err.Error()
+	} else {
+		result["currentVersion"] = current
+		result["updateAvailable"] = compareVersions(current, latest) < 0
+	}
+
+	updateCheckCache = result
+	updateCheckCached = time.Now()
+	return result, nil
+}
+
+func handleFrpcUpdateCheck(w http.ResponseWriter, r *http.Request) {
+	result, err := checkFrpcUpdate()
+	if err != nil {
+		writeError(w, r, err, http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// ========================================
+// FRP Server Settings
+// ========================================
+
+// tomlQuote renders s as a TOML basic string, escaping quotes, backslashes
+// and control characters
+func tomlQuote(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, c := range s {
+		switch {
+		case c == '"':
+			sb.WriteString(`\"`)
+		case c == '\\':
+			sb.WriteString(`\\`)
+		case c == '\n':
+			sb.WriteString(`\n`)
+		case c == '\r':
+			sb.WriteString(`\r`)
+		case c == '\t':
+			sb.WriteString(`\t`)
+		case c < 0x20 || c == 0x7f:
+			sb.WriteString(fmt.Sprintf(`\u%04X`, c))
+		default:
+			sb.WriteRune(c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// tomlBasicString matches a TOML basic string, capturing its still-escaped
+// contents; escaped quotes do not end the match
+const tomlBasicString = `"((?:[^"\\]|\\.)*)"`
+
+// tomlUnquote strips the quotes from a TOML basic or literal string value,
+// resolving escapes in basic strings; other values (numbers, booleans) are
+// returned unchanged
+func tomlUnquote(v string) string {
+	if len(v) >= 2 && strings.HasPrefix(v, `'`) && strings.HasSuffix(v, `'`) {
+		return v[1 : len(v)-1]
+	}
+	if len(v) >= 2 && strings.HasPrefix(v, `"`) && strings.HasSuffix(v, `"`) {
+		return tomlUnescape(v[1 : len(v)-1])
+	}
+	return v
+}
+
+// tomlUnescape resolves the escape sequences of a basic string's contents,
+// the inverse of tomlQuote. Unknown escapes are kept as written.
+func tomlUnescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			sb.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch c := s[i]; c {
+		case '"', '\\':
+			sb.WriteByte(c)
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 't':
+			sb.WriteByte('\t')
+		case 'b':
+			sb.WriteByte('\b')
+		case 'f':
+			sb.WriteByte('\f')
+		case 'u', 'U':
+			n := 4
+			if c == 'U' {
+				n = 8
+			}
+			if i+n < len(s) {
+				if code, err := strconv.ParseUint(s[i+1:i+1+n], 16, 32); err == nil {
+					sb.WriteRune(rune(code))
+					i += n
+					continue
+				}
+			}
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		default:
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// findTomlKey locates a dotted key such as "auth.token" in the top-level
+// section of frpc.toml or in its own table (e.g. token under [auth]). It
+// returns the line index and raw value, or -1 when the key is absent.
+func findTomlKey(lines []string, key string) (int, string) {
+	table, leaf := "", key
+	if i := strings.LastIndex(key, "."); i >= 0 {
+		table, leaf = key[:i], key[i+1:]
+	}
+	reDotted := regexp.MustCompile(`^\s*` + regexp.QuoteMeta(key) + `\s*=\s*(.*?)\s*$`)
+	reLeaf := regexp.MustCompile(`^\s*` + regexp.QuoteMeta(leaf) + `\s*=\s*(.*?)\s*$`)
+
+	section := ""
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			section = trimmed
+			continue
+		}
+		if section == "" {
+			if m := reDotted.FindStringSubmatch(line); m != nil {
+				return i, m[1]
+			}
+		} else if table != "" && section == "["+table+"]" {
+			if m := reLeaf.FindStringSubmatch(line); m != nil {
+				return i, m[1]
+			}
+		}
+	}
+	return -1, ""
+}
+
+// getTomlKey returns the unquoted value of a top-level dotted key in frpc.toml
+func getTomlKey(content, key string) (string, bool) {
+	idx, value := findTomlKey(strings.Split(content, "\n"), key)
+	if idx < 0 {
+		return "", false
+	}
+	return tomlUnquote(value), true
+}
+
+// setTomlKey sets a dotted key to the raw TOML value, updating it in place if
+// present, adding it under its table if that table exists, or otherwise
+// appending it as a dotted key to the end of the top-level section
+func setTomlKey(content, key, value string) string {
+	lines := strings.Split(content, "\n")
+
+	if idx, _ := findTomlKey(lines, key); idx >= 0 {
+		trimmed := strings.TrimSpace(lines[idx])
+		indent := lines[idx][:strings.Index(lines[idx], trimmed)]
+		name := strings.TrimSpace(trimmed[:strings.Index(trimmed, "=")])
+		lines[idx] = fmt.Sprintf("%s%s = %s", indent, name, value)
+		return strings.Join(lines, "\n")
+	}
+
+	if i := strings.LastIndex(key, "."); i >= 0 {
+		header := "[" + key[:i] + "]"
+		for j, line := range lines {
+			if strings.TrimSpace(line) == header {
+				newLine := fmt.Sprintf("%s = %s", key[i+1:], value)
+				lines = append(lines[:j+1], append([]string{newLine}, lines[j+1:]...)...)
+				return strings.Join(lines, "\n")
+			}
+		}
+	}
+
+	// Insert after the last non-blank line of the top-level section
+	insertAt := 0
+	for j, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			break
+		}
+		if trimmed != "" {
+			insertAt = j + 1
+		}
+	}
+	newLine := fmt.Sprintf("%s = %s", key, value)
+	lines = append(lines[:insertAt], append([]string{newLine}, lines[insertAt:]...)...)
+	return strings.Join(lines, "\n")
+}
+
+// updateFrpcTomlKeys applies a set of key/value updates to frpc.toml
+func updateFrpcTomlKeys(updates [][2]string) error {
+	lines, eol, err := readFrpcToml()
+	if err != nil {
+		return err
+	}
+
+	text := strings.Join(lines, "\n")
+	for _, u := range updates {
+		text = setTomlKey(text, u[0], u[1])
+	}
+	return writeFrpcToml(strings.Split(text, "\n"), eol)
+}
+
+// preferredFormat picks "json" or "toml" from the Accept header, honouring
+// q-values. JSON is the default when nothing matches.
+func preferredFormat(r *http.Request) string {
+	type choice struct {
+		format string
+		q      float64
+	}
+	var choices []choice
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		fields := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		switch mediaType {
+		case "application/json", "*/*", "application/*":
+			choices = append(choices, choice{"json", q})
+		case "application/toml", "text/plain", "text/*":
+			choices = append(choices, choice{"toml", q})
+		}
+	}
+	sort.SliceStable(choices, func(i, j int) bool { return choices[i].q > choices[j].q })
+	if len(choices) > 0 && choices[0].q > 0 {
+		return choices[0].format
+	}
+	return "json"
+}
+
+// tomlSettings returns the keys outside [[proxies]]/[[visitors]] as dotted
+// names with unquoted values, masking credentials
+func tomlSettings(lines []string) map[string]string {
+	settings := make(map[string]string)
+	table := ""
+	skip := false
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "[") {
+			skip = strings.HasPrefix(trimmed, "[[") || strings.HasPrefix(trimmed, "[proxies.") || strings.HasPrefix(trimmed, "[visitors.")
+			table = strings.Trim(trimmed, "[] ") + "."
+			continue
+		}
+		key, value, ok := strings.Cut(trimmed, "=")
+		if skip || !ok {
+			continue
+		}
+		key = table + strings.TrimSpace(key)
+		value = tomlUnquote(strings.TrimSpace(value))
+		if isSecretKey(key) && value != "" {
+			value = "***"
+		}
+		settings[key] = value
+	}
+	return settings
+}
+
+// handleExportFrpcConfig returns frpc.toml either raw (Accept: text/plain or
+// application/toml) or parsed into settings and proxies (JSON, the default)
+func handleExportFrpcConfig(w http.ResponseWriter, r *http.Request) {
+	content, err := os.ReadFile(config.FrpcTomlPath)
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Vary", "Accept")
+
+	if preferredFormat(r) == "toml" {
+		w.Header().Set("Content-Type", "application/toml; charset=utf-8")
+		w.Write(content)
+		return
+	}
+
+	proxies, err := parseFrpProxies(bytes.NewReader(content))
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if proxies == nil {
+		proxies = []FrpProxy{}
+	}
+	lines, _ := splitLines(string(content))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"path":     config.FrpcTomlPath,
+		"settings": tomlSettings(lines),
+		"proxies":  proxies,
+	})
+}
+
+// applyServerEnvOverrides rewrites serverAddr/serverPort in frpc.toml from
+// FRP_SERVER_ADDR and FRP_SERVER_PORT, leaving the file untouched when the
+// variables are unset or already match
+func applyServerEnvOverrides() error {
+	addr, port := os.Getenv("FRP_SERVER_ADDR"), os.Getenv("FRP_SERVER_PORT")
+	if addr == "" && port == "" {
+		return nil
+	}
+
+	content, err := os.ReadFile(config.FrpcTomlPath)
+	if err != nil {
+		return err
+	}
+	text := string(content)
+
+	var updates [][2]string
+	if addr != "" {
+		if strings.ContainsAny(addr, "\"\\\r\n ") {
+			return fmt.Errorf("无效的 FRP_SERVER_ADDR: %q", addr)
+		}
+		if current, _ := getTomlKey(text, "serverAddr"); current != addr {
+			log.Printf("环境变量 FRP_SERVER_ADDR 覆盖 serverAddr: %q -> %q", current, addr)
+			updates = append(updates, [2]string{"serverAddr", tomlQuote(addr)})
+		}
+	}
+	if port != "" {
+		if err := validatePort("FRP_SERVER_PORT", port); err != nil {
+			return err
+		}
+		if current, _ := getTomlKey(text, "serverPort"); current != port {
+			log.Printf("环境变量 FRP_SERVER_PORT 覆盖 serverPort: %q -> %q", current, port)
+			updates = append(updates, [2]string{"serverPort", port})
+		}
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+	return updateFrpcTomlKeys(updates)
+}
+
+// frpTransportProtocols are the values frpc accepts for transport.protocol
+var frpTransportProtocols = []string{"tcp", "kcp", "quic", "websocket", "wss"}
+
+// handleFrpServer reports (GET) or updates (POST) the frps connection settings
+// in frpc.toml. Only fields present in the POST body are changed.
+func handleFrpServer(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		content, err := os.ReadFile(config.FrpcTomlPath)
+		if err != nil {
+			writeError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		text := string(content)
+		serverAddr, _ := getTomlKey(text, "serverAddr")
+		serverPort, _ := getTomlKey(text, "serverPort")
+		protocol, _ := getTomlKey(text, "transport.protocol")
+		if protocol == "" {
+			protocol = "tcp"
+		}
+		token, _ := getTomlKey(text, "auth.token")
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"serverAddr":        serverAddr,
+			"serverPort":        serverPort,
+			"transportProtocol": protocol,
+			"tokenConfigured":   token != "",
+		})
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ServerAddr        *string `json:"serverAddr"`
+		ServerPort        *int    `json:"serverPort"`
+		TransportProtocol *string `json:"transportProtocol"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+
+	var updates [][2]string
+	if req.ServerAddr != nil {
+		if *req.ServerAddr == "" || strings.ContainsAny(*req.ServerAddr, "\"\\\r\n ") {
+			writeMsgError(w, r, http.StatusBadRequest, "invalid_server_addr")
+			return
+		}
+		updates = append(updates, [2]string{"serverAddr", tomlQuote(*req.ServerAddr)})
+	}
+	if req.ServerPort != nil {
+		if *req.ServerPort < 1 || *req.ServerPort > 65535 {
+			writeMsgError(w, r, http.StatusBadRequest, "invalid_server_port")
+			return
+		}
+		updates = append(updates, [2]string{"serverPort", strconv.Itoa(*req.ServerPort)})
+	}
+	if req.TransportProtocol != nil {
+		valid := false
+		for _, p := range frpTransportProtocols {
+			valid = valid || p == *req.TransportProtocol
+		}
+		if !valid {
+			writeMsgError(w, r, http.StatusBadRequest, "invalid_transport_protocol", strings.Join(frpTransportProtocols, "/"))
+			return
+		}
+		updates = append(updates, [2]string{"transport.protocol", tomlQuote(*req.TransportProtocol)})
+	}
+	if len(updates) == 0 {
+		writeMsgError(w, r, http.StatusBadRequest, "nothing_to_update")
+		return
+	}
+
+	if err := updateFrpcTomlKeys(updates); err != nil {
+		writeMsgError(w, r, http.StatusInternalServerError, "update_toml_failed", err)
+		return
+	}
+
+	// Restart frpc
+	restart := restartAfterEdit(r)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "success", "restart": restart})
+}
+
+// handleFrpcLogLevel reads (GET) or updates (POST) log.level and log.maxDays
+// in frpc.toml; updates restart frpc
+func handleFrpcLogLevel(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		content, err := os.ReadFile(config.FrpcTomlPath)
+		if err != nil {
+			writeError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		text := string(content)
+		level, _ := getTomlKey(text, "log.level")
+		if level == "" {
+			level = "info"
+		}
+		maxDays, _ := getTomlKey(text, "log.maxDays")
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"level":   level,
+			"maxDays": maxDays,
+		})
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Level   *string `json:"level"`
+		MaxDays *int    `json:"maxDays"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+
+	var updates [][2]string
+	if req.Level != nil {
+		if _, ok := frpLogLevels[*req.Level]; !ok {
+			writeMsgError(w, r, http.StatusBadRequest, "invalid_log_level_setting")
+			return
+		}
+		updates = append(updates, [2]string{"log.level", tomlQuote(*req.Level)})
+	}
+	if req.MaxDays != nil {
+		if *req.MaxDays < 1 || *req.MaxDays > 3650 {
+			writeMsgError(w, r, http.StatusBadRequest, "invalid_max_days")
+			return
+		}
+		updates = append(updates, [2]string{"log.maxDays", strconv.Itoa(*req.MaxDays)})
+	}
+	if len(updates) == 0 {
+		writeMsgError(w, r, http.StatusBadRequest, "nothing_to_update")
+		return
+	}
+
+	if err := updateFrpcTomlKeys(updates); err != nil {
+		writeMsgError(w, r, http.StatusInternalServerError, "update_toml_failed", err)
+		return
+	}
+	if req.Level != nil {
+		log.Printf("frpc 日志级别已设置为 %s", *req.Level)
+	}
+
+	// Restart frpc
+	restart := restartAfterEdit(r)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "success", "restart": restart})
+}
+
+// handleFrpServerToken reports whether an auth token is configured (GET) or
+// sets it (POST). The token value itself is never returned.
+func handleFrpServerToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		content, err := os.ReadFile(config.FrpcTomlPath)
+		if err != nil {
+			writeError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		token, _ := getTomlKey(string(content), "auth.token")
+		method, _ := getTomlKey(string(content), "auth.method")
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"configured": token != "",
+			"method":     method,
+		})
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Token string `json:"token"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+
+	if req.Token == "" || validateTomlString("token", req.Token) != nil {
+		writeMsgError(w, r, http.StatusBadRequest, "invalid_token")
+		return
+	}
+
+	err := updateFrpcTomlKeys([][2]string{
+		{"auth.method", tomlQuote("token")},
+		{"auth.token", tomlQuote(req.Token)},
+	})
+	if err != nil {
+		writeMsgError(w, r, http.StatusInternalServerError, "update_toml_failed", err)
+		return
+	}
+
+	// Restart frpc
+	restart := restartAfterEdit(r)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "success", "restart": restart})
+}
+
+// ========================================
+// FRP Admin API
+// ========================================
+
+// FrpcAdminConfig holds the [webServer] (admin API) settings of frpc.toml
+type FrpcAdminConfig struct {
+	Configured bool   `json:"configured"`
+	Addr       string `json:"addr"`
+	Port       string `json:"port"`
+	User       string `json:"user"`
+	Password   string `json:"-"`
+}
+
+// getFrpcAdminConfig parses the webServer settings from frpc.toml
+func getFrpcAdminConfig() (FrpcAdminConfig, error) {
+	content, err := os.ReadFile(config.FrpcTomlPath)
+	if err != nil {
+		return FrpcAdminConfig{}, err
+	}
+
+	var admin FrpcAdminConfig
+	text := string(content)
+	admin.Port, admin.Configured = getTomlKey(text, "webServer.port")
+	admin.Addr, _ = getTomlKey(text, "webServer.addr")
+	admin.User, _ = getTomlKey(text, "webServer.user")
+	admin.Password, _ = getTomlKey(text, "webServer.password")
+
+	if admin.Addr == "" || admin.Addr == "0.0.0.0" {
+		admin.Addr = "127.0.0.1"
+	}
+	return admin, nil
+}
+
+// frpcAdminRequest calls the frpc admin API with the credentials from
+// frpc.toml. Callers must close the response body.
+func frpcAdminRequest(method, path string, body io.Reader) (*http.Response, error) {
+	admin, err := getFrpcAdminConfig()
+	if err != nil {
+		return nil, err
+	}
+	if !admin.Configured {
+		return nil, fmt.Errorf("frpc.toml 未配置 webServer (管理 API)")
+	}
+
+	url := fmt.Sprintf("http://%s/%s", net.JoinHostPort(admin.Addr, admin.Port), strings.TrimPrefix(path, "/"))
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if admin.User != "" || admin.Password != "" {
+		req.SetBasicAuth(admin.User, admin.Password)
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	return client.Do(req)
+}
+
+func handleFrpcAdminConfig(w http.ResponseWriter, r *http.Request) {
+	admin, err := getFrpcAdminConfig()
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"configured":  admin.Configured,
+		"addr":        admin.Addr,
+		"port":        admin.Port,
+		"user":        admin.User,
+		"hasPassword": admin.Password != "",
+	})
+}
+
+// FrpcAdminCheck is the result of probing the frpc admin API with the
+// credentials from frpc.toml
+type FrpcAdminCheck struct {
+	Configured    bool   `json:"configured"`
+	Addr          string `json:"addr,omitempty"`
+	Reachable     bool   `json:"reachable"`
+	Authorized    bool   `json:"authorized"`
+	StatusCode    int    `json:"statusCode,omitempty"`
+	Version       string `json:"version,omitempty"`
+	VersionSource string `json:"versionSource,omitempty"`
+	Error         string `json:"error,omitempty"`
+	Hint          string `json:"hint,omitempty"`
+}
+
+// checkFrpcAdmin makes an authenticated GET /api/status call so a wrong
+// webServer user/password shows up here instead of as a failed reload
+// later. The admin API has no version call, so the version comes from
+// `frpc -v` of the executable the manager launches.
+func checkFrpcAdmin() FrpcAdminCheck {
+	var check FrpcAdminCheck
+	admin, err := getFrpcAdminConfig()
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	check.Configured = admin.Configured
+	if !admin.Configured {
+		check.Hint = "在 frpc.toml 中配置 webServer.port (以及 user/password) 以启用管理 API"
+		return check
+	}
+	check.Addr = net.JoinHostPort(admin.Addr, admin.Port)
+
+	resp, err := frpcAdminRequest("GET", "/api/status", nil)
+	if err != nil {
+		check.Error = err.Error()
+		check.Hint = "确认 frpc 正在运行且 webServer 监听地址可从本机访问"
+		return check
+	}
+	resp.Body.Close()
+	check.Reachable = true
+	check.StatusCode = resp.StatusCode
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		check.Authorized = true
+	case resp.StatusCode == http.StatusUnauthorized:
+		check.Error = "管理 API 拒绝了 frpc.toml 中的凭据"
+		check.Hint = "检查 webServer.user 与 webServer.password 是否与正在运行的 frpc 一致"
+	default:
+		check.Error = fmt.Sprintf("frpc 管理 API 返回 %s", resp.Status)
+	}
+
+	if version, err := getFrpcVersion(); err == nil {
+		check.Version = version
+		check.VersionSource = "frpc -v"
+	}
+	return check
+}
+
+func handleFrpcAdminCheck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if runtime.GOOS != "windows" {
+		log.Printf("[模拟] 检查 frpc 管理 API 凭据")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"configured": true,
+			"reachable":  true,
+			"authorized": true,
+			"mock":       true,
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(checkFrpcAdmin())
+}
+
+// ProxyHealth is one proxy's state as reported by the frpc admin API
+type ProxyHealth struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Status     string `json:"status"`
+	Err        string `json:"err,omitempty"`
+	LocalAddr  string `json:"localAddr,omitempty"`
+	RemoteAddr string `json:"remoteAddr,omitempty"`
+}
+
+// getFrpcProxyHealth queries GET /api/status on the frpc admin API, which
+// groups proxies by type, and returns them keyed by proxy name
+func getFrpcProxyHealth() (map[string]ProxyHealth, error) {
+	resp, err := frpcAdminRequest("GET", "/api/status", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("frpc 管理 API 返回 %s", resp.Status)
+	}
+
+	var byType map[string][]struct {
+		Name       string `json:"name"`
+		Type       string `json:"type"`
+		Status     string `json:"status"`
+		Err        string `json:"err"`
+		LocalAddr  string `json:"local_addr"`
+		RemoteAddr string `json:"remote_addr"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&byType); err != nil {
+		return nil, fmt.Errorf("解析 frpc 状态失败: %v", err)
+	}
+
+	health := make(map[string]ProxyHealth)
+	for typ, proxies := range byType {
+		for _, p := range proxies {
+			if p.Type == "" {
+				p.Type = typ
+			}
+			health[p.Name] = ProxyHealth{
+				Name:       p.Name,
+				Type:       p.Type,
+				Status:     p.Status,
+				Err:        p.Err,
+				LocalAddr:  p.LocalAddr,
+				RemoteAddr: p.RemoteAddr,
+			}
+		}
+	}
+	return health, nil
+}
+
+// handleFrpcHealth reports each proxy's runtime state from the frpc admin
+// API, including the error frps returned for tunnels that failed to start
+func handleFrpcHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if runtime.GOOS != "windows" {
+		json.NewEncoder(w).Encode(map[string]interface{}{"available": true, "mock": true, "proxies": map[string]ProxyHealth{}})
+		return
+	}
+
+	health, err := getFrpcProxyHealth()
+	if err != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{"available": false, "error": err.Error(), "proxies": map[string]ProxyHealth{}})
+		return
+	}
+
+	failed := 0
+	for _, p := range health {
+		if p.Err != "" {
+			failed++
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"available": true, "proxies": health, "failed": failed})
+}
+
+// handleResetProxyStats would zero one proxy's traffic counters. frpc's admin
+// API (reload, stop, status, config) keeps no per-proxy traffic stats and
+// has no reset call, so after validating the proxy this reports the
+// operation as not supported instead of failing with a generic error.
+func handleResetProxyStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if req.Name == "" {
+		writeMsgError(w, r, http.StatusBadRequest, "missing_proxy_name")
+		return
+	}
+
+	proxies, err := getFrpProxies()
+	if err != nil {
+		writeMsgError(w, r, http.StatusInternalServerError, "proxy_read_failed", err)
+		return
+	}
+	if !slices.ContainsFunc(proxies, func(p FrpProxy) bool { return p.Name == req.Name }) {
+		writeMsgError(w, r, http.StatusNotFound, "proxy_not_found", req.Name)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotImplemented)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    "error",
+		"supported": false,
+		"name":      req.Name,
+		"code":      "stats_reset_unsupported",
+		"message":   msg(r, "stats_reset_unsupported"),
+	})
+}
+
+// frpcSignals lists the signals /api/frpc/signal supports and how each is
+// delivered. Windows has no SIGHUP/SIGTERM for console processes, so both go
+// through the frpc admin API; quit falls back to taskkill.
+var frpcSignals = map[string]string{
+	"reload": "GET /api/reload (frpc 管理 API)",
+	"quit":   "POST /api/stop (frpc 管理 API)，不可用时使用 taskkill",
+}
+
+// sendFrpcSignal delivers signal to frpc and returns how it was delivered
+func sendFrpcSignal(signal string) (string, error) {
+	if runtime.GOOS != "windows" {
+		log.Printf("[模拟] 向 frpc 发送信号: %s", signal)
+		return "mock", nil
+	}
+
+	method, path := "GET", "/api/reload"
+	if signal == "quit" {
+		method, path = "POST", "/api/stop"
+	}
+	resp, err := frpcAdminRequest(method, path, nil)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			return "admin", nil
+		}
+		err = fmt.Errorf("frpc 管理 API 返回 %s", resp.Status)
+	}
+
+	if signal != "quit" {
+		return "", msgError("frpc_signal_failed", signal, err)
+	}
+	log.Printf("通过管理 API 停止 frpc 失败 (%v)，改用 taskkill", err)
+	if err := stopFrpc(); err != nil {
+		return "", err
+	}
+	return "taskkill", nil
+}
+
+// handleFrpcSignal lists the supported signals (GET) or sends one (POST)
+func handleFrpcSignal(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"signals": frpcSignals})
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Signal string `json:"signal"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if _, ok := frpcSignals[req.Signal]; !ok {
+		writeMsgError(w, r, http.StatusBadRequest, "unsupported_signal", req.Signal)
+		return
+	}
+
+	via, err := sendFrpcSignal(req.Signal)
+	if err != nil {
+		writeError(w, r, err, http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success", "signal": req.Signal, "via": via})
+}
+
+// ========================================
+// Connectivity Diagnostics
+// ========================================
+
+// dialTimeout bounds each TCP probe made by the diagnostics endpoints
+const dialTimeout = 3 * time.Second
+
+// ChainStep is the result of one hop of a connectivity test
+type ChainStep struct {
+	Step       string `json:"step"`
+	OK         bool   `json:"ok"`
+	Detail     string `json:"detail"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// dialStep attempts a TCP connection to addr and records the outcome
+func dialStep(step, addr string) ChainStep {
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+	result := ChainStep{Step: step, DurationMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		result.Detail = fmt.Sprintf("连接 %s 失败: %v", addr, err)
+		return result
+	}
+	conn.Close()
+	result.OK = true
+	result.Detail = fmt.Sprintf("已连接 %s", addr)
+	return result
+}
+
+// ConnectCheck is the result of validating a rule's connect address
+type ConnectCheck struct {
+	Address   string   `json:"address"`
+	Resolved  []string `json:"resolved,omitempty"`
+	Probed    bool     `json:"probed"`
+	Reachable bool     `json:"reachable"`
+	OK        bool     `json:"ok"`
+	Detail    string   `json:"detail"`
+}
+
+// checkConnectAddr resolves addr (IP literals are taken as-is) and, when
+// probe is set, tries a TCP connection to addr:port
+func checkConnectAddr(addr, port string, probe bool) ConnectCheck {
+	check := ConnectCheck{Address: addr}
+	if ip := net.ParseIP(addr); ip != nil {
+		check.Resolved = []string{ip.String()}
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+		defer cancel()
+		addrs, err := net.DefaultResolver.LookupHost(ctx, addr)
+		if err != nil {
+			check.Detail = fmt.Sprintf("无法解析 %s: %v", addr, err)
+			return check
+		}
+		check.Resolved = addrs
+	}
+
+	if !probe {
+		check.OK = true
+		check.Detail = fmt.Sprintf("%s 解析为 %s", addr, strings.Join(check.Resolved, ", "))
+		return check
+	}
+	check.Probed = true
+	step := dialStep("connect", net.JoinHostPort(addr, port))
+	check.Reachable = step.OK
+	check.OK = step.OK
+	check.Detail = step.Detail
+	return check
+}
+
+// handleNetworkInfo reports what serverAddr resolves to and, unless
+// ?outbound=false, which local IP the manager uses to reach it
+func handleNetworkInfo(w http.ResponseWriter, r *http.Request) {
+	content, err := os.ReadFile(config.FrpcTomlPath)
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	serverAddr, _ := getTomlKey(string(content), "serverAddr")
+	serverPort, _ := getTomlKey(string(content), "serverPort")
+	if serverPort == "" {
+		serverPort = "7000"
+	}
+
+	result := map[string]interface{}{
+		"serverAddr": serverAddr,
+		"serverPort": serverPort,
+	}
+
+	var resolved []string
+	if serverAddr != "" {
+		ctx, cancel := context.WithTimeout(r.Context(), dialTimeout)
+		addrs, err := net.DefaultResolver.LookupHost(ctx, serverAddr)
+		cancel()
+		if err != nil {
+			result["resolveError"] = err.Error()
+		} else {
+			resolved = addrs
+		}
+	}
+	if resolved == nil {
+		resolved = []string{}
+	}
+	result["resolvedIPs"] = resolved
+
+	// A UDP "dial" only picks a route, so no packet is sent
+	if r.URL.Query().Get("outbound") != "false" {
+		target := "8.8.8.8:53"
+		if len(resolved) > 0 {
+			target = net.JoinHostPort(resolved[0], serverPort)
+		}
+		conn, err := net.DialTimeout("udp", target, dialTimeout)
+		if err != nil {
+			result["outboundError"] = err.Error()
+		} else {
+			result["outboundIP"] = conn.LocalAddr().(*net.UDPAddr).IP.String()
+			conn.Close()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func handleTestChain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ListenPort string `json:"listenPort"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+
+	rules, err := getNetshRules()
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	var rule *Rule
+	for i := range rules {
+		if rules[i].ListenPort == req.ListenPort {
+			rule = &rules[i]
+			break
+		}
+	}
+
+	steps := []ChainStep{{Step: "netsh", OK: rule != nil}}
+	if rule == nil {
+		steps[0].Detail = fmt.Sprintf("未找到监听端口 %s 的 netsh 规则", req.ListenPort)
+	} else {
+		steps[0].Detail = fmt.Sprintf("%s:%s -> %s:%s", rule.ListenAddress, rule.ListenPort, rule.ConnectAddress, rule.ConnectPort)
+
+		// A wildcard listener is reachable on loopback
+		listenHost := rule.ListenAddress
+		if listenHost == "0.0.0.0" || listenHost == "*" {
+			listenHost = "127.0.0.1"
+		}
+		steps = append(steps,
+			dialStep("listen", net.JoinHostPort(listenHost, rule.ListenPort)),
+			dialStep("backend", net.JoinHostPort(rule.ConnectAddress, rule.ConnectPort)),
+		)
+	}
+
+	ok := true
+	for _, step := range steps {
+		ok = ok && step.OK
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ok":    ok,
+		"steps": steps,
+	})
+}
+
+// PublicCheck is the result of probing a proxy through frps from outside
+type PublicCheck struct {
+	Check      string   `json:"check"`
+	Target     string   `json:"target"`
+	OK         bool     `json:"ok"`
+	Detail     string   `json:"detail"`
+	DurationMs int64    `json:"durationMs"`
+	TLS        *TLSInfo `json:"tls,omitempty"`
+}
+
+// TLSInfo summarizes the certificate presented by an https endpoint
+type TLSInfo struct {
+	Subject   string   `json:"subject"`
+	Issuer    string   `json:"issuer"`
+	DNSNames  []string `json:"dnsNames"`
+	NotAfter  string   `json:"notAfter"`
+	ExpiresIn int      `json:"expiresInDays"`
+}
+
+// probeHTTP sends a HEAD request to url and records the status and, for
+// https, the server certificate
+func probeHTTP(check, url string) PublicCheck {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		// Report the proxy's own response rather than following redirects
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	start := time.Now()
+	resp, err := client.Head(url)
+	result := PublicCheck{Check: check, Target: url, DurationMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		result.Detail = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+
+	result.OK = resp.StatusCode < 500
+	result.Detail = resp.Status
+	if resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
+		cert := resp.TLS.PeerCertificates[0]
+		result.TLS = &TLSInfo{
+			Subject:   cert.Subject.String(),
+			Issuer:    cert.Issuer.String(),
+			DNSNames:  cert.DNSNames,
+			NotAfter:  cert.NotAfter.Format(time.RFC3339),
+			ExpiresIn: int(time.Until(cert.NotAfter).Hours() / 24),
+		}
+	}
+	return result
+}
+
+func handleVerifyPublic(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+
+	proxies, err := getFrpProxies()
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	var proxy *FrpProxy
+	for i := range proxies {
+		if proxies[i].Name == req.Name {
+			proxy = &proxies[i]
+			break
+		}
+	}
+	if proxy == nil {
+		writeMsgError(w, r, http.StatusNotFound, "proxy_not_found", req.Name)
+		return
+	}
+
+	content, err := os.ReadFile(config.FrpcTomlPath)
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	serverAddr, _ := getTomlKey(string(content), "serverAddr")
+
+	checks := []PublicCheck{}
+	switch proxy.Type {
+	case "tcp":
+		if serverAddr == "" || proxy.RemotePort == "" {
+			writeMsgError(w, r, http.StatusBadRequest, "missing_public_addr")
+			return
+		}
+		step := dialStep("tcp", net.JoinHostPort(serverAddr, proxy.RemotePort))
+		checks = append(checks, PublicCheck{
+			Check:      "tcp",
+			Target:     net.JoinHostPort(serverAddr, proxy.RemotePort),
+			OK:         step.OK,
+			Detail:     step.Detail,
+			DurationMs: step.DurationMs,
+		})
+	case "http", "https":
+		if len(proxy.CustomDomains) == 0 {
+			writeMsgError(w, r, http.StatusBadRequest, "no_custom_domains")
+			return
+		}
+		for _, domain := range proxy.CustomDomains {
+			checks = append(checks, probeHTTP(proxy.Type, proxy.Type+"://"+domain+"/"))
+		}
+	default:
+		writeMsgError(w, r, http.StatusBadRequest, "unsupported_check_type", proxy.Type)
+		return
+	}
+
+	ok := true
+	for _, c := range checks {
+		ok = ok && c.OK
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"name":   proxy.Name,
+		"ok":     ok,
+		"checks": checks,
+	})
+}
+
+// ========================================
+// FRP Logs
+// ========================================
+
+// defaultTailLines is how many log lines /api/frpc/tail returns by default
+const defaultTailLines = 200
+
+// defaultLogBufferLines is how many frpc output lines are kept in memory
+const defaultLogBufferLines = 1000
+
+// frpcLogRing holds recent frpc output for the logs endpoints
+var frpcLogRing = newLineRing(defaultLogBufferLines)
+
+// frpLogLevels maps level names to the single-letter markers frp writes,
+// e.g. "2024/01/02 15:04:05 [W] [service.go:123] ..."
+var frpLogLevels = map[string]string{
+	"trace": "T",
+	"debug": "D",
+	"info":  "I",
+	"warn":  "W",
+	"error": "E",
+}
+
+// tailLines returns up to n of the last lines in the file at path for which
+// match returns true, reading backwards so large logs are not loaded whole
+func tailLines(path string, n int, match func(string) bool) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	const chunkSize = 64 * 1024
+	var matched []string
+	var partial []byte
+	offset := info.Size()
+
+	for offset > 0 && len(matched) < n {
+		size := int64(chunkSize)
+		if offset < size {
+			size = offset
+		}
+		offset -= size
+
+		buf := make([]byte, size)
+		if _, err := f.ReadAt(buf, offset); err != nil {
+			return nil, err
+		}
+		buf = append(buf, partial...)
+
+		// The first line of the chunk may be incomplete; keep it for the next round
+		lines := strings.Split(string(buf), "\n")
+		partial = []byte(lines[0])
+		for i := len(lines) - 1; i >= 1 && len(matched) < n; i-- {
+			line := strings.TrimSuffix(lines[i], "\r")
+			if line != "" && match(line) {
+				matched = append(matched, line)
+			}
+		}
+	}
+	if offset == 0 && len(matched) < n {
+		line := strings.TrimSuffix(string(partial), "\r")
+		if line != "" && match(line) {
+			matched = append(matched, line)
+		}
+	}
+
+	// Restore file order
+	for i, j := 0, len(matched)-1; i < j; i, j = i+1, j-1 {
+		matched[i], matched[j] = matched[j], matched[i]
+	}
+	return matched, nil
+}
+
+// handleFrpcTail returns the last lines of frpc.log, optionally filtered by
+// ?contains= substring and ?level= (I/W/E or info/warn/error)
+func handleFrpcTail(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	n := defaultTailLines
+	if v := query.Get("lines"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed <= 0 {
+			writeMsgError(w, r, http.StatusBadRequest, "invalid_lines")
+			return
+		}
+		n = parsed
+	}
+
+	contains := query.Get("contains")
+	level := strings.ToUpper(query.Get("level"))
+	if letter, ok := frpLogLevels[strings.ToLower(level)]; ok {
+		level = letter
+	}
+	if level != "" && (len(level) != 1 || !strings.Contains("TDIWE", level)) {
+		writeMsgError(w, r, http.StatusBadRequest, "invalid_level")
+		return
+	}
+
+	match := func(line string) bool {
+		if level != "" && !strings.Contains(line, "["+level+"]") {
+			return false
+		}
+		return contains == "" || strings.Contains(line, contains)
+	}
+
+	// Serve from memory when the buffer holds enough; fall back to the file
+	// for older history
+	source := "memory"
+	logPath := frpcLogPath()
+	buffered, complete := frpcLogRing.Lines()
+	var lines []string
+	for i := len(buffered) - 1; i >= 0 && len(lines) < n; i-- {
+		if match(buffered[i]) {
+			lines = append([]string{buffered[i]}, lines...)
+		}
+	}
+	if len(buffered) == 0 || (len(lines) < n && !complete) {
+		source = "file"
+		var err error
+		lines, err = tailLines(logPath, n, match)
+		if err != nil && !os.IsNotExist(err) {
+			writeError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+	}
+	if lines == nil {
+		lines = []string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"file":   logPath,
+		"source": source,
+		"lines":  lines,
+	})
+}
+
+// handleFrpcLogClear reports the size of frpc.log (GET) or empties it (POST).
+// The file is truncated in place rather than deleted: frpc keeps its handle
+// open in append mode, so it simply carries on writing at the new end.
+func handleFrpcLogClear(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	logPath := frpcLogPath()
+	var size int64
+	info, err := os.Stat(logPath)
+	switch {
+	case err == nil:
+		size = info.Size()
+	case !os.IsNotExist(err):
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "GET" {
+		json.NewEncoder(w).Encode(map[string]interface{}{"file": logPath, "size": size})
+		return
+	}
+
+	if err := os.Truncate(logPath, 0); err != nil && !os.IsNotExist(err) {
+		writeMsgError(w, r, http.StatusInternalServerError, "log_clear_failed", err)
+		return
+	}
+	frpcLogRing.Reset()
+	log.Printf("已清空 frpc 日志 %s (原大小 %d 字节，来自 %s)", logPath, size, r.RemoteAddr)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":       "success",
+		"file":         logPath,
+		"previousSize": size,
+	})
+}
+
+// logStreamHeartbeat is how often an idle log stream sends a keep-alive
+// comment; a client that cannot take a write within two heartbeats is dropped
+const logStreamHeartbeat = 15 * time.Second
+
+// handleFrpcLogStream streams frpc output as server-sent events, starting
+// with the buffered recent lines and following new ones as they arrive
+func handleFrpcLogStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeMsgError(w, r, http.StatusInternalServerError, "streaming_unsupported")
+		return
+	}
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	heartbeat := time.NewTicker(logStreamHeartbeat)
+	defer heartbeat.Stop()
+
+	// Each write gets its own deadline instead of the server's WriteTimeout,
+	// so a vanished client fails the next write and ends the handler
+	send := func(chunk string) bool {
+		rc.SetWriteDeadline(time.Now().Add(2 * logStreamHeartbeat))
+		if _, err := io.WriteString(w, chunk); err != nil {
+			return false
+		}
+		flusher.Flush()
+		return true
+	}
+
+	// The first send flushes the headers even when there is no backlog
+	lines, seq, changed := frpcLogRing.Since(0)
+	for first := true; ; first = false {
+		var sb strings.Builder
+		for _, line := range lines {
+			fmt.Fprintf(&sb, "data: %s\n\n", line)
+		}
+		if (first || sb.Len() > 0) && !send(sb.String()) {
+			return
+		}
+
+		lines = nil
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if !send(": ping\n\n") {
+				return
+			}
+		case <-changed:
+			lines, seq, changed = frpcLogRing.Since(seq)
+		}
+	}
+}
+
+// lineRing is a thread-safe, fixed-size buffer of the most recent output
+// lines. It implements io.Writer so it can sit behind an io.MultiWriter.
+type lineRing struct {
+	mu      sync.Mutex
+	lines   []string
+	next    int
+	full    bool
+	seq     uint64
+	partial []byte
+	changed chan struct{}
+}
+
+func newLineRing(size int) *lineRing {
+	if size <= 0 {
+		size = defaultLogBufferLines
+	}
+	return &lineRing{lines: make([]string, size), changed: make(chan struct{})}
+}
+
+func (l *lineRing) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	data := append(l.partial, p...)
+	added := false
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		l.lines[l.next] = strings.TrimSuffix(string(data[:i]), "\r")
+		l.next = (l.next + 1) % len(l.lines)
+		l.full = l.full || l.next == 0
+		l.seq++
+		data = data[i+1:]
+		added = true
+	}
+	l.partial = append([]byte(nil), data...)
+
+	// Wake up followers
+	if added {
+		close(l.changed)
+		l.changed = make(chan struct{})
+	}
+	return len(p), nil
+}
+
+// Lines returns the buffered lines oldest first and whether they are the
+// complete output so far (nothing has been overwritten yet)
+func (l *lineRing) Lines() ([]string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.snapshot(), !l.full
+}
+
+// Since returns lines written after sequence number seq (as many as are
+// still buffered), the current sequence number, and a channel that is closed
+// when more lines arrive
+func (l *lineRing) Since(seq uint64) ([]string, uint64, <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lines := l.snapshot()
+	if missing := l.seq - seq; missing < uint64(len(lines)) {
+		lines = lines[uint64(len(lines))-missing:]
+	}
+	return lines, l.seq, l.changed
+}
+
+// Reset drops the buffered lines. The sequence number keeps counting so
+// followers only see lines written afterwards.
+func (l *lineRing) Reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	clear(l.lines)
+	l.next = 0
+	l.full = false
+	l.partial = nil
+}
+
+func (l *lineRing) snapshot() []string {
+	if !l.full {
+		return append([]string(nil), l.lines[:l.next]...)
+	}
+	return append(append([]string(nil), l.lines[l.next:]...), l.lines[:l.next]...)
+}
+
+// ========================================
+// Self Test
+// ========================================
+
+// SelfTestCheck is one item of the environment readiness report
+type SelfTestCheck struct {
+	Name   string `json:"name"`
+	Pass   bool   `json:"pass"`
+	Detail string `json:"detail"`
+	Hint   string `json:"hint,omitempty"`
+}
+
+// runSelfTest checks that everything the manager depends on is in place
+func runSelfTest() []SelfTestCheck {
+	var checks []SelfTestCheck
+
+	// frpc executable
+	exe := SelfTestCheck{Name: "frpcExe"}
+	if path, found := probeFrpcExe(); !found {
+		exe.Detail = fmt.Sprintf("未找到 %s", config.FrpcExePath)
+		exe.Hint = "在 config.json 中将 frpcExePath 设置为 frpc.exe 的完整路径"
+	} else if version, err := getFrpcVersion(); err != nil {
+		exe.Detail = fmt.Sprintf("%s 无法运行: %v", path, err)
+		exe.Hint = "确认该文件是适用于本系统的 frpc 可执行文件"
+	} else {
+		exe.Pass = true
+		exe.Detail = fmt.Sprintf("%s (版本 %s)", path, version)
+	}
+	checks = append(checks, exe)
+
+	// frpc.toml
+	toml := SelfTestCheck{Name: "frpcToml"}
+	proxies, err := getFrpProxies()
+	if err != nil {
+		toml.Detail = fmt.Sprintf("无法读取 %s: %v", proxiesTomlPath(), err)
+		toml.Hint = "在 config.json 中检查 frpcTomlPath，并确认文件存在且可读"
+	} else if content, _ := os.ReadFile(config.FrpcTomlPath); !hasTomlKey(string(content), "serverAddr") {
+		toml.Detail = "缺少 serverAddr"
+		toml.Hint = "在 frpc.toml 顶部添加 serverAddr = \"<frps 地址>\""
+	} else {
+		toml.Pass = true
+		toml.Detail = fmt.Sprintf("%s (%d 个代理)", proxiesTomlPath(), len(proxies))
+		for _, p := range proxies {
+			if p.Name == "" || p.Type == "" {
+				toml.Pass = false
+				toml.Detail = "存在缺少 name 或 type 的代理"
+				toml.Hint = "为每个 [[proxies]] 补全 name 和 type"
+				break
+			}
+		}
+	}
+	checks = append(checks, toml)
+
+	// Administrator privileges (netsh portproxy changes require elevation)
+	admin := SelfTestCheck{Name: "admin"}
+	if runtime.GOOS != "windows" {
+		admin.Pass = true
+		admin.Detail = "模拟模式"
+	} else if _, err := runCommand("net", "session"); err != nil {
+		admin.Detail = "当前进程没有管理员权限"
+		admin.Hint = "右键以管理员身份运行 portproxy-manager.exe"
+	} else {
+		admin.Pass = true
+		admin.Detail = "已具有管理员权限"
+	}
+	checks = append(checks, admin)
+
+	// netsh
+	netsh := SelfTestCheck{Name: "netsh"}
+	if runtime.GOOS != "windows" {
+		netsh.Pass = true
+		netsh.Detail = "模拟模式"
+	} else if _, err := getNetshRules(); err != nil {
+		netsh.Detail = fmt.Sprintf("netsh 不可用: %v", err)
+		netsh.Hint = "确认 netsh 在 PATH 中，且 IP Helper (iphlpsvc) 服务正在运行"
+	} else {
+		netsh.Pass = true
+		netsh.Detail = "netsh portproxy 可用"
+	}
+	checks = append(checks, netsh)
+
+	// Port conflicts among configured proxies
+	ports := SelfTestCheck{Name: "ports", Pass: true, Detail: "未发现端口冲突"}
+	owner := make(map[string]string)
+	all, _ := allFrpProxies()
+	for _, p := range all {
+		if p.RemotePort == "" {
+			continue
+		}
+		if other, exists := owner[p.RemotePort]; exists {
+			ports.Pass = false
+			ports.Detail = fmt.Sprintf("远程端口 %s 同时被 %s 和 %s 使用", p.RemotePort, other, p.Name)
+			ports.Hint = "为其中一个代理修改 remotePort"
+			break
+		}
+		owner[p.RemotePort] = p.Name
+	}
+	if ports.Pass {
+		if conflict := checkLocalPorts(); conflict != nil {
+			ports.Pass = false
+			ports.Detail = conflict.Detail
+			ports.Hint = conflict.Hint
+		}
+	}
+	checks = append(checks, ports)
+
+	return checks
+}
+
+// localPort is a port on this machine that the setup needs
+type localPort struct {
+	addr, port, owner string
+	rule              bool
+	// held means the port is already bound by this process or by an existing
+	// rule, so a failed listen probe is expected
+	held bool
+}
+
+// configuredLocalPorts lists the manager port, the netsh listen ports, and
+// the frpc admin API and visitor bind ports from frpc.toml
+func configuredLocalPorts() []localPort {
+	managerAddr := ""
+	if config.LocalOnly {
+		managerAddr = "127.0.0.1"
+	}
+	ports := []localPort{{addr: managerAddr, port: strconv.Itoa(config.Port), owner: "管理器 (port)", held: true}}
+	if rules, err := getNetshRules(); err == nil {
+		for _, r := range rules {
+			ports = append(ports, localPort{addr: r.ListenAddress, port: r.ListenPort, owner: "netsh 规则 " + ruleKey(r.ListenAddress, r.ListenPort), rule: true, held: true})
+		}
+	}
+
+	// frpc holds its own ports while it runs
+	frpcRunning, _ := getFrpcStatus()["running"].(bool)
+	if admin, err := getFrpcAdminConfig(); err == nil && admin.Configured {
+		ports = append(ports, localPort{addr: admin.Addr, port: admin.Port, owner: "frpc webServer.port", held: frpcRunning})
+	}
+	if visitors, err := getFrpVisitors(); err == nil {
+		for _, v := range visitors {
+			ports = append(ports, localPort{addr: cmp.Or(v.BindAddr, "127.0.0.1"), port: v.BindPort, owner: "visitor " + v.Name, held: frpcRunning})
+		}
+	}
+	return ports
+}
+
+// bindAddrsOverlap reports whether listeners on a and b with the same port
+// would collide; an empty or wildcard address covers every address
+func bindAddrsOverlap(a, b string) bool {
+	wildcard := func(addr string) bool {
+		return addr == "" || addr == "*" || addr == "0.0.0.0" || addr == "::"
+	}
+	return a == b || wildcard(a) || wildcard(b)
+}
+
+// checkLocalPorts reports the first configured local port that is claimed
+// twice, or that cannot be bound although nothing of ours should hold it
+func checkLocalPorts() *SelfTestCheck {
+	var claimed []localPort
+	for _, p := range configuredLocalPorts() {
+		for _, other := range claimed {
+			// netsh itself refuses duplicate rules
+			if other.port != p.port || (other.rule && p.rule) || !bindAddrsOverlap(other.addr, p.addr) {
+				continue
+			}
+			return &SelfTestCheck{
+				Detail: fmt.Sprintf("本地端口 %s 同时被 %s 和 %s 使用", p.port, other.owner, p.owner),
+				Hint:   "修改其中一方的端口",
+			}
+		}
+		claimed = append(claimed, p)
+		if p.held {
+			continue
+		}
+		ln, err := net.Listen("tcp", net.JoinHostPort(p.addr, p.port))
+		if err != nil {
+			return &SelfTestCheck{
+				Detail: fmt.Sprintf("%s 的端口 %s:%s 已被其他程序占用: %v", p.owner, p.addr, p.port, err),
+				Hint:   "用 netstat -ano 找到占用该端口的进程，或修改配置中的端口",
+			}
+		}
+		ln.Close()
+	}
+	return nil
+}
+
+// hasTomlKey reports whether a top-level dotted key is set in the content
+func hasTomlKey(content, key string) bool {
+	_, ok := getTomlKey(content, key)
+	return ok
+}
+
+func handleSelfTest(w http.ResponseWriter, r *http.Request) {
+	checks := runSelfTest()
+
+	pass := true
+	for _, c := range checks {
+		pass = pass && c.Pass
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"pass":   pass,
+		"checks": checks,
+	})
+}
+
+// ========================================
+// Rule Metadata
+// ========================================
+
+// rulesMetaFile stores metadata netsh cannot keep, keyed by listenAddress:listenPort
+const rulesMetaFile = "rules-meta.json"
+
+// RuleMeta is the sidecar metadata kept for a netsh rule
+type RuleMeta struct {
+	Description string `json:"description,omitempty"`
+	CreatedAt   string `json:"createdAt,omitempty"`
+	CreatedBy   string `json:"createdBy,omitempty"`
+	// ProxyName links the rule to the frp proxy that forwards to it
+	ProxyName string `json:"proxyName,omitempty"`
+	// Family is the portproxy table the rule was added to, needed to
+	// delete it again
+	Family string `json:"family,omitempty"`
+}
+
+var rulesMetaMu sync.Mutex
+
+// ruleKey identifies a netsh rule in the metadata file
+func ruleKey(listenAddress, listenPort string) string {
+	return listenAddress + ":" + listenPort
+}
+
+// loadRulesMeta reads the metadata file; a missing file means no metadata
+func loadRulesMeta() (map[string]RuleMeta, error) {
+	meta := make(map[string]RuleMeta)
+	content, err := os.ReadFile(rulesMetaFile)
+	if os.IsNotExist(err) {
+		return meta, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// saveRulesMeta writes the metadata file
+func saveRulesMeta(meta map[string]RuleMeta) error {
+	content, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(rulesMetaFile, content, 0644)
+}
+
+// updateRulesMeta applies fn to the metadata under the lock and saves it
+func updateRulesMeta(fn func(meta map[string]RuleMeta)) {
+	rulesMetaMu.Lock()
+	defer rulesMetaMu.Unlock()
+
+	meta, err := loadRulesMeta()
+	if err != nil {
+		log.Printf("警告: 读取 %s 失败: %v", rulesMetaFile, err)
+		return
+	}
+	fn(meta)
+	if err := saveRulesMeta(meta); err != nil {
+		log.Printf("警告: 写入 %s 失败: %v", rulesMetaFile, err)
+	}
+}
+
+// recordRuleMeta stores metadata for a newly added rule
+func recordRuleMeta(family, listenAddress, listenPort, description, createdBy, proxyName string) {
+	updateRulesMeta(func(meta map[string]RuleMeta) {
+		meta[ruleKey(listenAddress, listenPort)] = RuleMeta{
+			Description: description,
+			CreatedAt:   time.Now().Format(time.RFC3339),
+			CreatedBy:   createdBy,
+			ProxyName:   proxyName,
+			Family:      family,
+		}
+	})
+}
+
+// linkRuleMeta links an existing rule to proxyName, keeping its other
+// metadata; an empty proxyName removes the link
+func linkRuleMeta(listenAddress, listenPort, proxyName string) {
+	updateRulesMeta(func(meta map[string]RuleMeta) {
+		key := ruleKey(listenAddress, listenPort)
+		m := meta[key]
+		m.ProxyName = proxyName
+		meta[key] = m
+	})
+}
+
+// linkedProxyName returns the frp proxy linked to a rule, if any
+func linkedProxyName(listenAddress, listenPort string) string {
+	rulesMetaMu.Lock()
+	defer rulesMetaMu.Unlock()
+	meta, err := loadRulesMeta()
+	if err != nil {
+		return ""
+	}
+	return meta[ruleKey(listenAddress, listenPort)].ProxyName
+}
+
+// storedRuleFamily returns the portproxy table recorded for a rule, falling
+// back to v4tov4 for rules added before the family was recorded
+func storedRuleFamily(listenAddress, listenPort string) string {
+	rulesMetaMu.Lock()
+	defer rulesMetaMu.Unlock()
+	meta, err := loadRulesMeta()
+	if err != nil || meta[ruleKey(listenAddress, listenPort)].Family == "" {
+		return "v4tov4"
+	}
+	return meta[ruleKey(listenAddress, listenPort)].Family
+}
+
+// linkedRules returns the rules linked to an frp proxy, ordered by key. A
+// proxy added with several listenAddresses has one rule per address.
+func linkedRules(proxyName string) []Rule {
+	rulesMetaMu.Lock()
+	defer rulesMetaMu.Unlock()
+	meta, err := loadRulesMeta()
+	if err != nil || proxyName == "" {
+		return nil
+	}
+	var rules []Rule
+	for key, m := range meta {
+		if m.ProxyName == proxyName {
+			i := strings.LastIndex(key, ":")
+			rules = append(rules, Rule{ListenAddress: key[:i], ListenPort: key[i+1:], Family: cmp.Or(m.Family, "v4tov4")})
+		}
+	}
+	slices.SortFunc(rules, func(a, b Rule) int {
+		return strings.Compare(ruleKey(a.ListenAddress, a.ListenPort), ruleKey(b.ListenAddress, b.ListenPort))
+	})
+	return rules
+}
+
+// forgetRuleMeta removes the metadata of a deleted rule
+func forgetRuleMeta(listenAddress, listenPort string) {
+	updateRulesMeta(func(meta map[string]RuleMeta) {
+		delete(meta, ruleKey(listenAddress, listenPort))
+	})
+}
+
+// attachRulesMeta fills in the metadata of each rule and drops entries for
+// rules that no longer exist
+func attachRulesMeta(rules []Rule) {
+	updateRulesMeta(func(meta map[string]RuleMeta) {
+		live := make(map[string]bool)
+		for i := range rules {
+			key := ruleKey(rules[i].ListenAddress, rules[i].ListenPort)
+			live[key] = true
+			if m, ok := meta[key]; ok {
+				// Backfill the table of rules recorded without one
+				if m.Family == "" && rules[i].Family != "" {
+					m.Family = rules[i].Family
+					meta[key] = m
+				}
+				rules[i].Meta = &m
+			}
+		}
+		for key := range meta {
+			if !live[key] {
+				delete(meta, key)
+			}
+		}
+	})
+}
+
+// clientIP returns the address of the client that made the request
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ========================================
+// Audit Log
+// ========================================
+
+// auditLogFile records every mutating API call, one JSON object per line
+const auditLogFile = "audit.log"
+
+// defaultAuditMaxBytes is the size at which audit.log is rotated to audit.log.1
+const defaultAuditMaxBytes = 5 << 20
+
+// AuditEntry is one line of the audit log
+type AuditEntry struct {
+	Time     string      `json:"time"`
+	ClientIP string      `json:"clientIP"`
+	Action   string      `json:"action"`
+	Params   interface{} `json:"params,omitempty"`
+	Status   int         `json:"status"`
+	// Reason is the optional "reason" request field, e.g. why a proxy was deleted
+	Reason string `json:"reason,omitempty"`
+}
+
+// maxAuditReasonLen caps the reason recorded with an audited request
+const maxAuditReasonLen = 500
+
+var auditMu sync.Mutex
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// auditMiddleware records POST requests to the audit log along with their
+// (redacted) JSON parameters and the resulting status code
+func auditMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			next(w, r)
+			return
+		}
+
+		limit := config.MaxBodyBytes
+		if limit <= 0 {
+			limit = defaultMaxBodyBytes
+		}
+
+		// Capture the body for the log while leaving it readable for the
+		// handler, which still enforces the size limit itself
+		var body []byte
+		if r.ContentLength <= limit {
+			body, _ = io.ReadAll(io.LimitReader(r.Body, limit+1))
+			r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+		}
+
+		var params interface{}
+		if len(body) > 0 && int64(len(body)) <= limit {
+			if err := json.Unmarshal(body, &params); err == nil {
+				params = redactParams(params)
+			}
+		}
+		if len(r.URL.RawQuery) > 0 {
+			params = map[string]interface{}{"query": r.URL.RawQuery, "body": params}
+		}
+
+		// Edits are still saved while paused but only take effect on resume
+		if frpcPaused() {
+			w.Header().Set("X-Frpc-Paused", "true")
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+
+		entry := AuditEntry{
+			Time:     time.Now().Format(time.RFC3339),
+			ClientIP: clientIP(r),
+			Action:   r.URL.Path,
+			Params:   params,
+			Status:   rec.status,
+		}
+		if m, ok := params.(map[string]interface{}); ok {
+			if reason, ok := m["reason"].(string); ok && len(reason) <= maxAuditReasonLen {
+				entry.Reason = reason
+			}
+		}
+		writeAudit(entry)
+	}
+}
+
+// redactParams masks credential-looking fields in decoded JSON
+func redactParams(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for key, inner := range val {
+			if isSecretKey(key) {
+				val[key] = "***"
+			} else {
+				val[key] = redactParams(inner)
+			}
+		}
+	case []interface{}:
+		for i := range val {
+			val[i] = redactParams(val[i])
+		}
+	}
+	return v
+}
+
+// writeAudit appends an entry to the audit log, rotating it when too large
+func writeAudit(entry AuditEntry) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	maxBytes := config.AuditMaxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultAuditMaxBytes
+	}
+	if info, err := os.Stat(auditLogFile); err == nil && info.Size() >= maxBytes {
+		if err := os.Rename(auditLogFile, auditLogFile+".1"); err != nil {
+			log.Printf("警告: 轮转 %s 失败: %v", auditLogFile, err)
+		}
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	f, err := os.OpenFile(auditLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		log.Printf("警告: 写入 %s 失败: %v", auditLogFile, err)
+		return
+	}
+	defer f.Close()
+	f.Write(append(line, '\n'))
+}
+
+// readAudit returns audit entries newest first, skipping offset entries and
+// returning at most limit
+func readAudit(offset, limit int) ([]AuditEntry, int, error) {
+	auditMu.Lock()
+	content, err := os.ReadFile(auditLogFile)
+	auditMu.Unlock()
+	if os.IsNotExist(err) {
+		return []AuditEntry{}, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	entries := []AuditEntry{}
+	total := 0
+	for i := len(lines) - 1; i >= 0; i-- {
+		var entry AuditEntry
+		if lines[i] == "" || json.Unmarshal([]byte(lines[i]), &entry) != nil {
+			continue
+		}
+		if total >= offset && len(entries) < limit {
+			entries = append(entries, entry)
+		}
+		total++
+	}
+	return entries, total, nil
+}
+
+func handleGetAudit(w http.ResponseWriter, r *http.Request) {
+	offset, limit := 0, 50
+	if v := r.URL.Query().Get("offset"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 0 {
+			writeMsgError(w, r, http.StatusBadRequest, "invalid_offset")
+			return
+		}
+		offset = parsed
+	}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed <= 0 || parsed > 1000 {
+			writeMsgError(w, r, http.StatusBadRequest, "invalid_limit")
+			return
+		}
+		limit = parsed
+	}
+
+	entries, total, err := readAudit(offset, limit)
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"entries": entries,
+		"total":   total,
+		"offset":  offset,
+		"limit":   limit,
+	})
+}
+
+// ========================================
+// Scheduled Restart
+// ========================================
+
+// cronSchedule is a parsed five-field cron expression; each field is the set
+// of allowed values
+type cronSchedule struct {
+	minute, hour, dom, month, dow map[int]bool
+	// domStar and dowStar follow cron's rule that when both day fields are
+	// restricted, matching either one is enough. As in Vixie cron, a field
+	// starting with "*" (including "*/2") counts as unrestricted here.
+	domStar, dowStar bool
+}
+
+var (
+	scheduledRestartMu   sync.Mutex
+	scheduledRestartNext time.Time
+)
+
+// parseRestartSchedule accepts "HH:MM" for a daily restart or a standard
+// "minute hour day-of-month month day-of-week" cron expression
+func parseRestartSchedule(spec string) (*cronSchedule, error) {
+	spec = strings.TrimSpace(spec)
+	if t, err := time.Parse("15:04", spec); err == nil {
+		spec = fmt.Sprintf("%d %d * * *", t.Minute(), t.Hour())
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("无效的计划重启时间 %q (应为 HH:MM 或 5 段 cron 表达式)", spec)
+	}
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	sets := make([]map[int]bool, 5)
+	for i, field := range fields {
+		set, err := parseCronField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("无效的 cron 字段 %q: %v", field, err)
+		}
+		sets[i] = set
+	}
+	// Both 0 and 7 mean Sunday
+	if sets[4][7] {
+		sets[4][0] = true
+	}
+	return &cronSchedule{
+		minute: sets[0], hour: sets[1], dom: sets[2], month: sets[3], dow: sets[4],
+		domStar: strings.HasPrefix(fields[2], "*"), dowStar: strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+// parseCronField expands "*", "a", "a-b" and "/step" forms, comma separated
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	set := make(map[int]bool)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if base, s, ok := strings.Cut(part, "/"); ok {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("无效的步长 %q", s)
+			}
+			part, step = base, n
+		}
+		lo, hi := min, max
+		if part != "*" {
+			from, to, isRange := strings.Cut(part, "-")
+			var err error
+			if lo, err = strconv.Atoi(from); err != nil {
+				return nil, fmt.Errorf("无效的数值 %q", from)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(to); err != nil {
+					return nil, fmt.Errorf("无效的数值 %q", to)
+				}
+			} else if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return nil, fmt.Errorf("超出范围 %d-%d", min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+// next returns the first matching minute strictly after t, or the zero time
+// if nothing matches within a year (e.g. "0 0 31 2 *")
+func (c *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	for limit := t.AddDate(1, 0, 1); t.Before(limit); t = t.Add(time.Minute) {
+		if !c.month[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location()).Add(-time.Minute)
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location()).Add(-time.Minute)
+			continue
+		}
+		if !c.hour[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 59, 0, 0, t.Location())
+			continue
+		}
+		if c.minute[t.Minute()] {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (c *cronSchedule) dayMatches(t time.Time) bool {
+	domOK, dowOK := c.dom[t.Day()], c.dow[int(t.Weekday())]
+	if !c.domStar && !c.dowStar {
+		return domOK || dowOK
+	}
+	return domOK && dowOK
+}
+
+// nextScheduledRestart reports when the scheduler will next restart frpc,
+// or the zero time when scheduled restarts are disabled
+func nextScheduledRestart() time.Time {
+	scheduledRestartMu.Lock()
+	defer scheduledRestartMu.Unlock()
+	return scheduledRestartNext
+}
+
+func setNextScheduledRestart(t time.Time) {
+	scheduledRestartMu.Lock()
+	scheduledRestartNext = t
+	scheduledRestartMu.Unlock()
+}
+
+// startRestartScheduler launches the background scheduled-restart loop for
+// spec; it does nothing when spec is empty or "off"
+func startRestartScheduler(spec string) {
+	if spec == "" || strings.EqualFold(spec, "off") {
+		return
+	}
+	schedule, err := parseRestartSchedule(spec)
+	if err != nil {
+		log.Printf("警告: %v，已禁用计划重启", err)
+		return
+	}
+	log.Printf("已启用 frpc 计划重启: %s", spec)
+
+	go func() {
+		for {
+			next := schedule.next(time.Now())
+			setNextScheduledRestart(next)
+			if next.IsZero() {
+				log.Printf("警告: 计划重启表达式 %q 在一年内没有匹配的时间，已停止计划重启", spec)
+				return
+			}
+			time.Sleep(time.Until(next))
+
+			restarted, err := restartFrpcIfRunning()
+			switch {
+			case err != nil:
+				log.Printf("计划重启 frpc 失败: %v", err)
+			case restarted:
+				log.Println("已按计划重启 frpc")
+			default:
+				log.Println("frpc 未运行，跳过本次计划重启")
+			}
+		}
+	}()
+}
+
+// ========================================
+// Config Watcher
+// ========================================
+
+// tomlWatchInterval is how often frpc.toml and the managed proxies file are
+// polled for external edits. A
+// change is only acted on once the file has stayed the same for one more
+// interval, which debounces editors that save in several steps.
+const tomlWatchInterval = 2 * time.Second
+
+var (
+	tomlWatchMu   sync.Mutex
+	tomlKnownHash [sha256.Size]byte
+)
+
+// managedTomlHash hashes frpc.toml together with the managed proxies file
+func managedTomlHash() ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	h := sha256.New()
+	for _, path := range managedTomlPaths() {
+		content, err := readManagedFile(path)
+		if err != nil {
+			return sum, err
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00", path, len(content))
+		h.Write(content)
+	}
+	h.Sum(sum[:0])
+	return sum, nil
+}
+
+// noteFrpcTomlWrite records the current content of frpc.toml and the managed
+// proxies file as the manager's own, so the watcher does not treat it as an
+// external edit
+func noteFrpcTomlWrite() {
+	sum, err := managedTomlHash()
+	if err != nil {
+		return
+	}
+	tomlWatchMu.Lock()
+	tomlKnownHash = sum
+	tomlWatchMu.Unlock()
+}
+
+// startTomlWatcher polls frpc.toml and the managed proxies file and restarts
+// frpc when another program changes either
+func startTomlWatcher() {
+	noteFrpcTomlWrite()
+	log.Printf("已启用 frpc.toml 外部修改监控: %s", strings.Join(managedTomlPaths(), ", "))
+
+	go func() {
+		var pending [sha256.Size]byte
+		hasPending := false
+		for range time.Tick(tomlWatchInterval) {
+			sum, err := managedTomlHash()
+			if err != nil {
+				continue
+			}
+
+			tomlWatchMu.Lock()
+			known := tomlKnownHash
+			tomlWatchMu.Unlock()
+			if sum == known {
+				hasPending = false
+				continue
+			}
+			if !hasPending || sum != pending {
+				pending, hasPending = sum, true
+				continue
+			}
+
+			hasPending = false
+			tomlWatchMu.Lock()
+			tomlKnownHash = sum
+			tomlWatchMu.Unlock()
+
+			log.Printf("检测到 frpc.toml 或代理文件被外部修改，正在重新加载 frpc")
+			if restarted, err := restartFrpcIfRunning(); err != nil {
+				log.Printf("警告: 重启 frpc 失败: %v", err)
+			} else if restarted {
+				log.Println("frpc 已按外部修改后的配置重启")
+			}
+		}
+	}()
+}
+
+// ========================================
+// Managed Files
+// ========================================
+
+// FileStatus describes one file the manager reads or writes
+type FileStatus struct {
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	Exists  bool   `json:"exists"`
+	Size    int64  `json:"size"`
+	ModTime string `json:"modTime,omitempty"`
+	Warning string `json:"warning,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// statManagedFile stats path and resolves it to an absolute path
+func statManagedFile(name, path string) FileStatus {
+	status := FileStatus{Name: name, Path: path}
+	if abs, err := filepath.Abs(path); err == nil {
+		status.Path = abs
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			status.Error = err.Error()
+		}
+		return status
+	}
+	status.Exists = true
+	status.Size = info.Size()
+	status.ModTime = info.ModTime().Format(time.RFC3339)
+	return status
+}
+
+func handleFilesStatus(w http.ResponseWriter, r *http.Request) {
+	toml := statManagedFile("frpc.toml", config.FrpcTomlPath)
+	switch {
+	case !toml.Exists && toml.Error == "":
+		toml.Warning = "frpc.toml 不存在"
+	case toml.Exists && toml.Size == 0:
+		toml.Warning = "frpc.toml 为空，可能是上次写入失败，请在重启 frpc 前检查"
+	}
+
+	files := []FileStatus{toml, statManagedFile("frpc.log", frpcLogPath())}
+	for _, path := range configFiles {
+		files = append(files, statManagedFile(filepath.Base(path), path))
+	}
+	files = append(files,
+		statManagedFile("rules-meta.json", rulesMetaFile),
+		statManagedFile("audit.log", auditLogFile),
+	)
+
+	healthy := true
+	for _, f := range files {
+		if f.Warning != "" || f.Error != "" {
+			healthy = false
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"files":   files,
+		"healthy": healthy,
+	})
+}
+
+// ========================================
+// FRP Capabilities
+// ========================================
+
+// frpcKnownSubcommands are the subcommands the UI cares about
+var frpcKnownSubcommands = []string{"reload", "status", "stop", "verify", "nathole"}
+
+var (
+	frpcCapabilitiesMu    sync.Mutex
+	frpcCapabilitiesCache map[string]interface{}
+)
+
+// parseFrpcSubcommands extracts subcommand names from `frpc --help`. Cobra
+// lists them under "Available Commands:"; if that section is missing the
+// known names are looked for as the first word of any line instead.
+func parseFrpcSubcommands(help string) []string {
+	lines, _ := splitLines(help)
+	var commands []string
+	inSection := false
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "Available Commands:") {
+			inSection = true
+			continue
+		}
+		if !inSection {
+			continue
+		}
+		if trimmed == "" || !strings.HasPrefix(line, " ") {
+			break
+		}
+		commands = append(commands, strings.Fields(trimmed)[0])
+	}
+	if len(commands) > 0 {
+		return commands
+	}
+
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		for _, known := range frpcKnownSubcommands {
+			if fields[0] == known {
+				commands = append(commands, known)
+			}
+		}
+	}
+	return commands
+}
+
+// getFrpcCapabilities runs `frpc --help` once and caches what it reports.
+// Failures are not cached so a later call can retry once frpc is installed.
+func getFrpcCapabilities() (map[string]interface{}, error) {
+	frpcCapabilitiesMu.Lock()
+	defer frpcCapabilitiesMu.Unlock()
+	if frpcCapabilitiesCache != nil {
+		return frpcCapabilitiesCache, nil
+	}
+
+	exePath, found := probeFrpcExe()
+	if !found {
+		return nil, msgError("frpc_exe_not_found", config.FrpcExePath)
+	}
+	// Some versions exit non-zero for --help, so only fail on empty output
+	output, err := runCommand(exePath, "--help")
+	if len(output) == 0 {
+		if err == nil {
+			err = fmt.Errorf("没有输出")
+		}
+		return nil, fmt.Errorf("执行 frpc --help 失败: %v", err)
+	}
+
+	subcommands := parseFrpcSubcommands(string(output))
+	supported := make(map[string]bool)
+	for _, known := range frpcKnownSubcommands {
+		supported[known] = false
+	}
+	for _, c := range subcommands {
+		supported[c] = true
+	}
+
+	frpcCapabilitiesCache = map[string]interface{}{
+		"exePath":     exePath,
+		"subcommands": subcommands,
+		"supported":   supported,
+	}
+	return frpcCapabilitiesCache, nil
+}
+
+func handleFrpcCapabilities(w http.ResponseWriter, r *http.Request) {
+	capabilities, err := getFrpcCapabilities()
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(capabilities)
+}
+
+// ========================================
+// Support Bundle
+// ========================================
+
+// supportBundleLogLines is how much of frpc.log goes into the bundle
+const supportBundleLogLines = 1000
+
+// reSecretAssignment matches "token = ..." style credentials in free text
+var reSecretAssignment = regexp.MustCompile(`(?i)((?:[\w.]*(?:token|password|secret)[\w.]*|[\w.]*key)\s*[=:]\s*)("[^"]*"|\S+)`)
+
+// redactText masks credentials in TOML or log text
+func redactText(text string) string {
+	return reSecretAssignment.ReplaceAllString(text, `${1}"***"`)
+}
+
+// handleSupportBundle streams a zip with redacted frpc.toml and managed
+// proxies file, the frpc.log tail, version, status, self-test and recent
+// audit entries
+func handleSupportBundle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := fmt.Sprintf("support-bundle-%s.zip", time.Now().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+	disableWriteDeadline(w)
+
+	zw := zip.NewWriter(w)
+	defer zw.Close()
+	add := func(file string, content []byte) {
+		f, err := zw.Create(file)
+		if err == nil {
+			_, err = f.Write(content)
+		}
+		if err != nil {
+			log.Printf("警告: 写入诊断包 %s 失败: %v", file, err)
+		}
+	}
+	addJSON := func(file string, v interface{}) {
+		content, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			content = []byte(err.Error())
+		}
+		add(file, content)
+	}
+
+	addJSON("version.json", map[string]string{
+		"version":   version,
+		"goVersion": runtime.Version(),
+		"os":        runtime.GOOS,
+		"arch":      runtime.GOARCH,
+	})
+	addJSON("config.json", redactConfig(config))
+
+	if content, err := os.ReadFile(config.FrpcTomlPath); err != nil {
+		add("frpc.toml.error.txt", []byte(err.Error()))
+	} else {
+		add("frpc.toml", []byte(redactText(string(content))))
+	}
+	// The managed proxies file holds the proxies' secretKey lines
+	if path := proxiesTomlPath(); path != config.FrpcTomlPath {
+		if content, err := readManagedFile(path); err != nil {
+			add("managed-proxies.toml.error.txt", []byte(err.Error()))
+		} else {
+			add("managed-proxies.toml", []byte(redactText(string(content))))
+		}
+	}
+
+	if lines, err := tailLines(frpcLogPath(), supportBundleLogLines, func(string) bool { return true }); err != nil {
+		add("frpc.log.error.txt", []byte(err.Error()))
+	} else {
+		add("frpc.log", []byte(redactText(strings.Join(lines, "\n"))))
+	}
+
+	addJSON("status.json", getFrpcStatus())
+	addJSON("selftest.json", runSelfTest())
+
+	if entries, _, err := readAudit(0, 100); err != nil {
+		add("audit.error.txt", []byte(err.Error()))
+	} else {
+		// Audit params are redacted when written
+		addJSON("audit.json", entries)
+	}
+}
+
+// ========================================
+// Service Port Discovery
+// ========================================
+
+// reServiceName limits service names to what sc.exe accepts in practice
+var reServiceName = regexp.MustCompile(`^[\w.\- ]{1,256}$`)
+
+// ServicePort is a TCP address a service is listening on
+type ServicePort struct {
+	Address string `json:"address"`
+	Port    string `json:"port"`
+}
+
+// parseServicePID extracts the PID from `sc queryex` output; 0 means the
+// service is not running
+func parseServicePID(output string) (int, bool) {
+	lines, _ := splitLines(output)
+	for _, line := range lines {
+		key, value, ok := strings.Cut(line, ":")
+		if ok && strings.TrimSpace(key) == "PID" {
+			pid, err := strconv.Atoi(strings.TrimSpace(value))
+			return pid, err == nil
+		}
+	}
+	return 0, false
+}
+
+// parseListeningPorts returns the TCP listeners owned by pid in `netstat -ano`
+// output. Listening rows are recognised by their ":0" foreign address, since
+// the state column is localised on some Windows versions.
+func parseListeningPorts(output string, pid int) []ServicePort {
+	ports := []ServicePort{}
+	seen := make(map[string]bool)
+	lines, _ := splitLines(output)
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[0] != "TCP" || !strings.HasSuffix(fields[2], ":0") {
+			continue
+		}
+		if owner, err := strconv.Atoi(fields[len(fields)-1]); err != nil || owner != pid {
+			continue
+		}
+		i := strings.LastIndex(fields[1], ":")
+		if i < 0 {
+			continue
+		}
+		port := ServicePort{Address: strings.Trim(fields[1][:i], "[]"), Port: fields[1][i+1:]}
+		if key := port.Address + ":" + port.Port; !seen[key] {
+			seen[key] = true
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
+
+// getServicePorts finds the PID of a Windows service and the TCP ports it
+// listens on
+func getServicePorts(service string) (int, []ServicePort, error) {
+	if runtime.GOOS != "windows" {
+		log.Printf("[模拟] 查询服务 %s 的监听端口", service)
+		return 1234, []ServicePort{{Address: "0.0.0.0", Port: "3389"}}, nil
+	}
+
+	output, err := runCommand("sc", "queryex", service)
+	if err != nil {
+		return 0, nil, fmt.Errorf("查询服务 %s 失败: %v", service, err)
+	}
+	pid, ok := parseServicePID(string(output))
+	if !ok {
+		return 0, nil, fmt.Errorf("无法解析服务 %s 的 PID", service)
+	}
+	if pid == 0 {
+		return 0, nil, fmt.Errorf("服务 %s 未运行", service)
+	}
+
+	output, err = runCommand("netstat", "-ano", "-p", "TCP")
+	if err != nil {
+		return pid, nil, fmt.Errorf("执行 netstat 失败: %v", err)
+	}
+	ports := parseListeningPorts(string(output), pid)
+	if v6, err := runCommand("netstat", "-ano", "-p", "TCPv6"); err == nil {
+		ports = append(ports, parseListeningPorts(string(v6), pid)...)
+	}
+	return pid, ports, nil
+}
+
+// handleServicePorts lists the ports a service listens on together with an
+// add request the UI can complete with a listen and remote port
+func handleServicePorts(w http.ResponseWriter, r *http.Request) {
+	service := r.URL.Query().Get("name")
+	if !reServiceName.MatchString(service) {
+		writeMsgError(w, r, http.StatusBadRequest, "invalid_service_name")
+		return
+	}
+
+	pid, ports, err := getServicePorts(service)
+	if err != nil {
+		writeError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	suggestions := []AddRuleRequest{}
+	for _, p := range ports {
+		suggestions = append(suggestions, AddRuleRequest{ConnectAddr: "127.0.0.1", ConnectPort: p.Port, Type: "tcp", Name: service})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"service":     service,
+		"pid":         pid,
+		"ports":       ports,
+		"suggestions": suggestions,
+	})
+}
+
+// handleForwardService adds a netsh rule and frp proxy for a service's port.
+// port may be omitted when the service listens on a single port; otherwise
+// the candidates are returned with 409 so the user can choose.
+func handleForwardService(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Service    string `json:"service"`
+		Port       string `json:"port"`
+		ListenPort string `json:"listenPort"`
+		RemotePort string `json:"remotePort"`
+		Manager    string `json:"manager"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if !reServiceName.MatchString(req.Service) {
+		writeMsgError(w, r, http.StatusBadRequest, "invalid_service_name")
+		return
+	}
+
+	_, ports, err := getServicePorts(req.Service)
+	if err != nil {
+		writeError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	port := req.Port
+	switch {
+	case len(ports) == 0:
+		writeMsgError(w, r, http.StatusBadRequest, "service_no_ports", req.Service)
+		return
+	case port == "" && len(ports) == 1:
+		port = ports[0].Port
+	case !slices.ContainsFunc(ports, func(p ServicePort) bool { return p.Port == port }):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  "error",
+			"code":    "choose_service_port",
+			"message": msg(r, "choose_service_port"),
+			"ports":   ports,
+		})
+		return
+	}
+
+	addRule(w, r, AddRuleRequest{
+		ListenPort:  req.ListenPort,
+		ConnectAddr: "127.0.0.1",
+		ConnectPort: port,
+		RemotePort:  req.RemotePort,
+		Type:        "tcp",
+		Name:        req.Service,
+		Manager:     req.Manager,
+		Description: fmt.Sprintf("服务 %s", req.Service),
+	})
+}
+
+// ========================================
+// Config Trial Run
+// ========================================
+
+// Bounds for the optional trial launch of /api/frpc/try
+const (
+	defaultTryLaunchSeconds = 5
+	maxTryLaunchSeconds     = 15
+)
+
+// handleTryFrpcToml checks a proposed frpc.toml with `frpc verify` and,
+// optionally, a short launch, using a temporary copy so the live config and
+// process are left alone
+func handleTryFrpcToml(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Config        string `json:"config"`
+		Launch        bool   `json:"launch"`
+		LaunchSeconds int    `json:"launchSeconds"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if strings.TrimSpace(req.Config) == "" {
+		writeMsgError(w, r, http.StatusBadRequest, "missing_config")
+		return
+	}
+	if req.LaunchSeconds == 0 {
+		req.LaunchSeconds = defaultTryLaunchSeconds
+	}
+	if req.LaunchSeconds < 1 || req.LaunchSeconds > maxTryLaunchSeconds {
+		writeMsgError(w, r, http.StatusBadRequest, "invalid_launch_seconds", maxTryLaunchSeconds)
+		return
+	}
+
+	result := map[string]interface{}{"problems": findTomlProblems(strings.Split(req.Config, "\n"))}
+	w.Header().Set("Content-Type", "application/json")
+	if runtime.GOOS != "windows" {
+		log.Println("[模拟] 试运行 frpc 配置")
+		result["verified"] = true
+		result["verifyOutput"] = "[模拟] frpc verify"
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+
+	exePath, found := probeFrpcExe()
+	if !found {
+		writeMsgError(w, r, http.StatusInternalServerError, "frpc_exe_not_found", config.FrpcExePath)
+		return
+	}
+
+	// Keep the copy next to frpc.toml so relative paths such as includes
+	// resolve the same way
+	tmp, err := os.CreateTemp(filepath.Dir(config.FrpcTomlPath), "frpc-try-*.toml")
+	if err != nil {
+		writeMsgError(w, r, http.StatusInternalServerError, "temp_config_create_failed", err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.WriteString(tmp, req.Config)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		writeMsgError(w, r, http.StatusInternalServerError, "temp_config_write_failed", err)
+		return
+	}
+
+	// verify writes its verdict to stdout and exits non-zero on failure
+	output, err := runCommand(exePath, "verify", "-c", tmp.Name())
+	result["verified"] = err == nil
+	result["verifyOutput"] = strings.TrimSpace(string(output))
+	if err != nil {
+		result["verifyError"] = err.Error()
+	}
+
+	if req.Launch && err == nil {
+		disableWriteDeadline(w)
+		launch := time.Duration(req.LaunchSeconds) * time.Second
+		launchLog, exited, launchErr := tryLaunchFrpc(exePath, tmp.Name(), launch)
+		result["launchLog"] = launchLog
+		result["launchExited"] = exited
+		if launchErr != nil {
+			result["launchError"] = launchErr.Error()
+		}
+		result["launchNote"] = "试运行的 frpc 会真实连接 frps；与正在运行的代理同名的代理会被 frps 拒绝"
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// tryLaunchFrpc runs frpc with configPath for up to d, returning what it
+// logged and whether it exited on its own before being stopped
+func tryLaunchFrpc(exePath, configPath string, d time.Duration) (string, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, exePath, "-c", configPath)
+	hideWindow(cmd)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err := cmd.Run()
+
+	exited := ctx.Err() == nil
+	if !exited {
+		// Stopped by the timeout: frpc stayed up for the whole trial
+		err = nil
+	}
+	return redactText(output.String()), exited, err
+}
+
+// ========================================
+// IP Helper Service
+// ========================================
+
+// ipHelperService is the service netsh portproxy depends on
+const ipHelperService = "iphlpsvc"
+
+// parseServiceState extracts the state name (e.g. RUNNING, STOPPED) from
+// `sc query` output
+func parseServiceState(output string) string {
+	lines, _ := splitLines(output)
+	for _, line := range lines {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || strings.TrimSpace(key) != "STATE" {
+			continue
+		}
+		// "4  RUNNING" followed on some versions by flags in parentheses
+		if fields := strings.Fields(value); len(fields) >= 2 {
+			return fields[1]
+		}
+	}
+	return "UNKNOWN"
+}
+
+// getIPHelperState returns the current state of the IP Helper service
+func getIPHelperState() (string, error) {
+	if runtime.GOOS != "windows" {
+		return "RUNNING", nil
+	}
+	output, err := runCommand("sc", "query", ipHelperService)
+	if err != nil {
+		return "", fmt.Errorf("查询 %s 服务失败: %v", ipHelperService, err)
+	}
+	return parseServiceState(string(output)), nil
+}
+
+// restartIPHelper restarts (or starts, if stopped) the IP Helper service and
+// optionally flushes the DNS cache
+func restartIPHelper(wasRunning, flushDNS bool) error {
+	if runtime.GOOS != "windows" {
+		log.Printf("[模拟] 重启 %s 服务", ipHelperService)
+		return nil
+	}
+
+	if wasRunning {
+		// net stop waits for the service to stop, unlike sc stop
+		if _, err := runCommand("net", "stop", ipHelperService, "/y"); err != nil {
+			return fmt.Errorf("停止 %s 服务失败: %v", ipHelperService, err)
+		}
+	}
+	if _, err := runCommand("net", "start", ipHelperService); err != nil {
+		return fmt.Errorf("启动 %s 服务失败: %v", ipHelperService, err)
+	}
+	if flushDNS {
+		if _, err := runCommand("ipconfig", "/flushdns"); err != nil {
+			log.Printf("警告: 刷新 DNS 缓存失败: %v", err)
+		}
+	}
+	log.Printf("%s 服务已重启", ipHelperService)
+	return nil
+}
+
+// handleIPHelper reports the IP Helper service state (GET) or restarts it
+// (POST with confirm: true), returning the state before and after
+func handleIPHelper(w http.ResponseWriter, r *http.Request) {
+	before, err := getIPHelperState()
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method == "GET" {
+		result := map[string]interface{}{"service": ipHelperService, "state": before, "running": before == "RUNNING"}
+		if before != "RUNNING" {
+			result["hint"] = "netsh portproxy 依赖 IP Helper 服务，服务未运行时规则不会生效"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Confirm  bool `json:"confirm"`
+		FlushDNS bool `json:"flushDns"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if !req.Confirm {
+		writeMsgError(w, r, http.StatusBadRequest, "iphlpsvc_confirm")
+		return
+	}
+
+	if err := restartIPHelper(before == "RUNNING", req.FlushDNS); err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	after, err := getIPHelperState()
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":     "success",
+		"before":     before,
+		"after":      after,
+		"running":    after == "RUNNING",
+		"dnsFlushed": req.FlushDNS,
+	})
+}
+
+// ========================================
+// Maintenance Pause
+// ========================================
+
+// pauseStateFile persists the paused state across manager restarts
+const pauseStateFile = "paused.json"
+
+// PauseState records who paused forwarding and when
+type PauseState struct {
+	PausedAt string `json:"pausedAt"`
+	PausedBy string `json:"pausedBy,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+var (
+	pauseMu    sync.Mutex
+	pauseState *PauseState
+)
+
+// loadPauseState reads pauseStateFile at startup; a missing file means not paused
+func loadPauseState() {
+	content, err := os.ReadFile(pauseStateFile)
+	if os.IsNotExist(err) {
+		return
+	}
+	var state PauseState
+	if err == nil {
+		err = json.Unmarshal(content, &state)
+	}
+	if err != nil {
+		log.Printf("警告: 读取 %s 失败: %v", pauseStateFile, err)
+		return
+	}
+
+	pauseMu.Lock()
+	pauseState = &state
+	pauseMu.Unlock()
+	log.Printf("转发处于暂停状态 (自 %s)，调用 /api/resume 恢复", state.PausedAt)
+}
+
+// currentPauseState returns the pause record, or nil when not paused
+func currentPauseState() *PauseState {
+	pauseMu.Lock()
+	defer pauseMu.Unlock()
+	return pauseState
+}
+
+func frpcPaused() bool {
+	return currentPauseState() != nil
+}
+
+// frpcState summarises status as running, paused or stopped
+func frpcState(running bool) string {
+	switch {
+	case running:
+		return "running"
+	case frpcPaused():
+		return "paused"
+	default:
+		return "stopped"
+	}
+}
+
+// handlePause stops frpc and records the paused state
+func handlePause(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 && !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if len(req.Reason) > maxAuditReasonLen {
+		writeMsgError(w, r, http.StatusBadRequest, "reason_too_long", maxAuditReasonLen)
+		return
+	}
+
+	pauseMu.Lock()
+	defer pauseMu.Unlock()
+	if pauseState != nil {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "success", "paused": true, "alreadyPaused": true, "state": pauseState})
+		return
+	}
+
+	state := &PauseState{
+		PausedAt: time.Now().Format(time.RFC3339),
+		PausedBy: clientIP(r),
+		Reason:   req.Reason,
+	}
+	content, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	// Persist first so a crash after stopping cannot lose the paused state
+	if err := os.WriteFile(pauseStateFile, content, 0644); err != nil {
+		writeMsgError(w, r, http.StatusInternalServerError, "write_file_failed", pauseStateFile, err)
+		return
+	}
+	if err := stopFrpc(); err != nil {
+		os.Remove(pauseStateFile)
+		writeMsgError(w, r, http.StatusInternalServerError, "frpc_stop_failed", err)
+		return
+	}
+	pauseState = state
+	invalidateFrpcStatus()
+	log.Printf("转发已暂停 (来自 %s)", state.PausedBy)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "success", "paused": true, "state": state})
+}
+
+// handleResume clears the paused state and starts frpc again, loading every
+// edit made while paused
+func handleResume(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	pauseMu.Lock()
+	previous := pauseState
+	if previous == nil {
+		pauseMu.Unlock()
+		writeMsgError(w, r, http.StatusConflict, "not_paused")
+		return
+	}
+	if err := os.Remove(pauseStateFile); err != nil && !os.IsNotExist(err) {
+		pauseMu.Unlock()
+		writeMsgError(w, r, http.StatusInternalServerError, "delete_file_failed", pauseStateFile, err)
+		return
+	}
+	pauseState = nil
+	pauseMu.Unlock()
+
+	result := restartFrpcDetailed()
+	w.Header().Set("Content-Type", "application/json")
+	if err := result.err(); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "error", "message": msg(r, "resume_start_failed", err), "result": result})
+		return
+	}
+	restartPending.Store(false)
+	log.Printf("转发已恢复 (暂停于 %s)", previous.PausedAt)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "success", "paused": false, "pausedAt": previous.PausedAt, "result": result})
+}
+
+// ========================================
+// Localization
+// ========================================
+
+// defaultLang is used when a request asks for no supported language
+const defaultLang = "zh"
+
+// messageCatalogs hold the localized human-readable text by message ID. IDs
+// double as the machine-readable codes clients should match on.
+var messageCatalogs = map[string]map[string]string{
+	"zh": {
+		"unauthorized":                  "未授权",
+		"body_too_large":                "请求体过大",
+		"paused":                        "转发已暂停，请调用 /api/resume 恢复",
+		"not_paused":                    "转发未处于暂停状态",
+		"frpc_started":                  "frpc 已启动",
+		"frpc_stopped":                  "frpc 已停止",
+		"frpc_restarted":                "frpc 已重启",
+		"frpc_not_running_no_restart":   "frpc 未运行，未执行重启",
+		"choose_service_port":           "请从服务监听的端口中选择一个",
+		"stats_reset_unsupported":       "当前 frpc 管理 API 不提供代理流量统计，无法重置计数",
+		"frpc_restarted_stop_err":       "frpc 已启动，但停止旧进程时出错: %s",
+		"nothing_pending":               "没有待应用的修改",
+		"simulation":                    "模拟模式",
+		"config_unspecified":            "运行中的 frpc 未通过 -c 指定配置，与管理器的 %s 不一致",
+		"config_mismatch":               "运行中的 frpc 使用的配置 (%s) 与管理器的 %s 不一致",
+		errCodeTasklistFailed:           "tasklist 执行失败",
+		errCodeTasklistParse:            "解析 tasklist 输出失败",
+		errCodeWmicFailed:               "wmic 执行失败",
+		errCodeUnknown:                  "查询 frpc 进程失败",
+		"local_only_needs_token":        "localOnly 模式下该接口需要在 config.json 中配置 authToken",
+		"webui_exposed_needs_token":     "Web UI 已通过 frp 公开，该接口需要在 config.json 中配置 authToken",
+		"local_only":                    "该接口仅允许本机访问 (或在 config.json 中配置 authToken)",
+		"webui_register_failed":         "注册 Web UI 代理失败: %v",
+		"webui_unregister_failed":       "移除 Web UI 代理失败: %v",
+		"invalid_webui_action":          "action 必须是 register 或 unregister",
+		"missing_listen_port":           "缺少 listenPort 参数",
+		"missing_rule_params":           "缺少 listenPort、connectAddr 或 connectPort 参数",
+		"invalid_family":                "无效的 family: %q (可选 %s)",
+		"tags_update_failed":            "更新标签失败: %v",
+		"missing_rename_names":          "缺少 oldName 或 newName",
+		"rename_webui_proxy":            "不能重命名 Web UI 代理 (请修改 config.json 中的 webUIProxyName)",
+		"rename_failed":                 "重命名代理失败: %v",
+		"reason_too_long":               "reason 不能超过 %d 字节",
+		"proxy_delete_failed":           "删除 FRP 代理失败: %v",
+		"reorder_failed":                "调整代理顺序失败: %v",
+		"backup_toml_failed":            "备份 frpc.toml 失败: %v",
+		"write_toml_failed":             "写入 frpc.toml 失败: %v",
+		"proxy_copy_failed":             "复制 FRP 代理失败: %v",
+		"unknown_proxy_type":            "未知的代理类型 %q (可选 %s)",
+		"unsupported_add_type":          "不支持的代理类型: %s (仅支持 tcp、udp、stcp、xtcp 和 sudp)",
+		"udp_link_netsh":                "udp 代理不能关联 netsh 规则",
+		"link_with_listen_addresses":    "linkNetshPort 不能与 listenAddresses 同时使用",
+		"proxy_name_taken":              "代理名称已存在: %s",
+		"netsh_add_failed":              "添加 netsh 规则失败: %v",
+		"update_toml_failed":            "更新 frpc.toml 失败: %v",
+		"preset_not_found":              "预设 %q 不存在",
+		"invalid_overrides":             "无效的 overrides: %v",
+		"missing_connect_addr":          "缺少 connectAddr",
+		"invalid_range_chars":           "name、manager 或 connectAddr 包含非法字符",
+		"invalid_listen_range":          "无效的监听端口范围",
+		"range_too_large":               "一次最多添加 %d 个端口",
+		"range_out_of_bounds":           "端口范围超出 1-65535",
+		"netsh_batch_add_failed":        "批量添加 netsh 规则失败: %v",
+		"netsh_add_port_failed":         "添加 netsh 规则 %s 失败: %v",
+		"netsh_delete_failed":           "删除 netsh 规则失败: %v",
+		"invalid_new_connect_address":   "无效的 newConnectAddress",
+		"netsh_edit_failed":             "修改 netsh 规则失败: %v",
+		"netsh_batch_delete_failed":     "批量删除 netsh 规则失败: %v",
+		"unsupported_policy":            "不支持的 policy: %s (仅支持 report 和 prune)",
+		"invalid_stop_delay":            "无效的 delay 参数 (例如 30s，最长 5m)",
+		"invalid_server_addr":           "无效的 serverAddr",
+		"invalid_server_port":           "无效的 serverPort",
+		"invalid_transport_protocol":    "transportProtocol 必须是 %s 之一",
+		"nothing_to_update":             "没有需要更新的字段",
+		"invalid_log_level_setting":     "level 必须是 trace/debug/info/warn/error 之一",
+		"invalid_max_days":              "无效的 maxDays (1-3650)",
+		"invalid_token":                 "无效的 token",
+		"missing_proxy_name":            "缺少代理名称",
+		"proxy_read_failed":             "读取 FRP 代理失败: %v",
+		"proxy_not_found":               "代理不存在: %s",
+		"unsupported_signal":            "不支持的信号: %s (仅支持 reload 和 quit)",
+		"missing_public_addr":           "缺少 serverAddr 或 remotePort，无法确定公网地址",
+		"no_custom_domains":             "代理没有配置 customDomains",
+		"unsupported_check_type":        "不支持检测该类型的代理: %s",
+		"invalid_lines":                 "无效的 lines 参数",
+		"invalid_level":                 "无效的 level 参数",
+		"log_clear_failed":              "清空日志失败: %v",
+		"streaming_unsupported":         "不支持流式响应",
+		"invalid_offset":                "无效的 offset 参数",
+		"invalid_limit":                 "无效的 limit 参数 (1-1000)",
+		"invalid_service_name":          "无效的服务名称",
+		"service_no_ports":              "服务 %s 没有监听任何 TCP 端口",
+		"missing_config":                "缺少 config",
+		"invalid_launch_seconds":        "launchSeconds 必须在 1-%d 之间",
+		"frpc_exe_not_found":            "未找到 frpc 可执行文件: %s",
+		"temp_config_create_failed":     "创建临时配置失败: %v",
+		"temp_config_write_failed":      "写入临时配置失败: %v",
+		"iphlpsvc_confirm":              "重启 IP Helper 会短暂中断所有 portproxy 转发，请设置 confirm: true 确认",
+		"write_file_failed":             "写入 %s 失败: %v",
+		"delete_file_failed":            "删除 %s 失败: %v",
+		"frpc_stop_failed":              "停止 frpc 失败: %v",
+		"visitor_name_taken":            "visitor 名称已存在: %s",
+		"visitor_bind_taken":            "%s:%s 已被 visitor %s 使用",
+		"visitor_not_found":             "visitor 不存在: %s",
+		"connect_check_failed":          "connectAddr 检查未通过，如确认无误请设置 ignoreConnectCheck 后重试",
+		"resume_start_failed":           "已取消暂停，但启动 frpc 失败: %v",
+		"invalid_chars":                 "%s 包含非法字符 %q",
+		"field_empty":                   "%s 不能为空",
+		"field_too_long":                "%s 过长 (%d 字节，最多 %d)",
+		"invalid_port":                  "%s 必须是 1-65535 之间的端口号: %q",
+		"invalid_connect_addr":          "无效的 connectAddr: %q",
+		"secret_no_remote_port":         "%s 代理不使用 remotePort",
+		"secret_key_required":           "%s 代理必须指定 secretKey",
+		"invalid_proxy_protocol":        "proxyProtocolVersion 只能是 v1 或 v2: %q",
+		"tcp_only":                      "Windows portproxy 仅支持 tcp 协议: %q",
+		"listen_addresses_v4_only":      "listenAddresses 仅适用于 IPv4 监听 (family %s)",
+		"invalid_listen_address":        "无效的监听地址 (需要 IPv4): %q",
+		"duplicate_listen_address":      "监听地址重复: %s",
+		"health_check_type_required":    "设置健康检查参数时必须指定 healthCheckType",
+		"unsupported_health_check":      "不支持的健康检查类型: %s (仅支持 tcp 和 http)",
+		"invalid_health_check_interval": "healthCheckIntervalSeconds 必须在 1-3600 之间",
+		"invalid_health_check_timeout":  "healthCheckTimeoutSeconds 必须在 1-60 之间",
+		"health_check_timeout_too_long": "healthCheckTimeoutSeconds 不能大于 healthCheckIntervalSeconds",
+		"health_check_path_http_only":   "healthCheckPath 仅适用于 http 健康检查",
+		"health_check_path_slash":       "healthCheckPath 必须以 / 开头",
+		"health_check_extra_conflict":   "extraConfig 中的 %s 与健康检查设置冲突",
+		"invalid_tag":                   "无效的标签: %q",
+		"invalid_visitor_type":          "visitor 类型只能是 %s: %q",
+		"visitor_server_name_required":  "visitor 必须指定 serverName",
+		"visitor_secret_required":       "visitor 必须指定 secretKey",
+		"invalid_bind_addr":             "无效的 bindAddr: %q",
+		"proxy_name_type_required":      "代理必须指定 name 和 type",
+		"proxy_error":                   "%s: %v",
+		"frpc_check_failed":             "检查进程状态失败: %v",
+		"frpc_already_running":          "frpc 已经在运行",
+		"frpc_log_create_failed":        "创建日志文件失败: %v",
+		"frpc_start_failed":             "启动 frpc 失败: %v",
+		"frpc_find_failed":              "查找进程失败: %v",
+		"frpc_kill_failed":              "停止进程失败: %v (TerminateProcess: %v)",
+		"frpc_signal_failed":            "发送 %s 失败: %v",
+		"invalid_local_addr":            "无效的 localAddr %q: %v",
+		"local_addr_connect_mismatch":   "localAddr %s 与 connectAddr/connectPort 不一致",
+		"local_addr_not_loopback":       "tcp 代理的 localAddr 必须是本机地址 (经由 netsh 转发): %s",
+		"local_addr_port_mismatch":      "localAddr %s 与 %s %s 不一致",
+		"invalid_extra_key":             "extraConfig 键名无效: %q",
+		"reserved_extra_key":            "extraConfig 不能覆盖由管理器维护的键: %s",
+		"invalid_extra_value":           "extraConfig.%s 的值不是合法的单行 TOML 值: %q",
+		"remote_port_pool_exhausted":    "remotePortPool 中的端口已全部被占用 (%s)",
+		"netsh_port_not_found":          "未找到监听端口为 %s 的 netsh 规则",
+		"netsh_rule_not_found":          "未找到规则 %s:%s",
+		"unsupported_rule_sort":         "不支持的 sort: %s (仅支持 listenPort 和 connectPort)",
+		"remote_port_taken":             "远程端口 %s 已被代理 %s 使用",
+		"listen_port_taken":             "监听端口 %s 已被 netsh 规则 %s 使用",
+		"copy_remote_port_required":     "源代理使用远程端口，必须为副本指定新的远程端口",
+		"copy_no_remote_port":           "源代理没有 remotePort 字段",
+		"duplicate_proxy_name":          "代理名称重复: %s",
+		"proxy_missing_name":            "第 %d 个代理缺少名称",
+		"reorder_count_mismatch":        "名称数量 (%d) 与现有代理数量 (%d) 不一致",
+		"duplicate_name":                "名称重复: %s",
+		"frpc_stop_delayed":             "frpc 已在等待延迟停止，请稍后再试",
+		"stop_delay_cancelled":          "等待停止时请求已取消，frpc 未停止",
+		"unsupported_sort":              "不支持的排序方式: %s",
+	},
+	"en": {
+		"unauthorized":                  "Unauthorized",
+		"body_too_large":                "Request body too large",
+		"paused":                        "Forwarding is paused; call /api/resume to resume",
+		"not_paused":                    "Forwarding is not paused",
+		"frpc_started":                  "frpc started",
+		"frpc_stopped":                  "frpc stopped",
+		"frpc_restarted":                "frpc restarted",
+		"frpc_not_running_no_restart":   "frpc is not running; nothing was restarted",
+		"choose_service_port":           "Choose one of the ports the service listens on",
+		"stats_reset_unsupported":       "The frpc admin API has no per-proxy traffic statistics to reset",
+		"frpc_restarted_stop_err":       "frpc started, but stopping the old process failed: %s",
+		"nothing_pending":               "No pending changes to apply",
+		"simulation":                    "Simulation mode",
+		"config_unspecified":            "The running frpc was not started with -c and may not use the manager's %s",
+		"config_mismatch":               "The running frpc uses %s instead of the manager's %s",
+		errCodeTasklistFailed:           "tasklist failed",
+		errCodeTasklistParse:            "Could not parse tasklist output",
+		errCodeWmicFailed:               "wmic failed",
+		errCodeUnknown:                  "Could not query the frpc process",
+		"local_only_needs_token":        "In localOnly mode this endpoint requires authToken in config.json",
+		"webui_exposed_needs_token":     "The web UI is published through frp; this endpoint requires authToken in config.json",
+		"local_only":                    "This endpoint only accepts local requests (or set authToken in config.json)",
+		"webui_register_failed":         "Failed to register the web UI proxy: %v",
+		"webui_unregister_failed":       "Failed to remove the web UI proxy: %v",
+		"invalid_webui_action":          "action must be register or unregister",
+		"missing_listen_port":           "Missing listenPort parameter",
+		"missing_rule_params":           "Missing listenPort, connectAddr or connectPort parameter",
+		"invalid_family":                "Invalid family %q (one of %s)",
+		"tags_update_failed":            "Failed to update tags: %v",
+		"missing_rename_names":          "Missing oldName or newName",
+		"rename_webui_proxy":            "The web UI proxy cannot be renamed (change webUIProxyName in config.json instead)",
+		"rename_failed":                 "Failed to rename the proxy: %v",
+		"reason_too_long":               "reason must not exceed %d bytes",
+		"proxy_delete_failed":           "Failed to delete the FRP proxy: %v",
+		"reorder_failed":                "Failed to reorder proxies: %v",
+		"backup_toml_failed":            "Failed to back up frpc.toml: %v",
+		"write_toml_failed":             "Failed to write frpc.toml: %v",
+		"proxy_copy_failed":             "Failed to copy the FRP proxy: %v",
+		"unknown_proxy_type":            "Unknown proxy type %q (one of %s)",
+		"unsupported_add_type":          "Unsupported proxy type %s (only tcp, udp, stcp, xtcp and sudp)",
+		"udp_link_netsh":                "udp proxies cannot be linked to a netsh rule",
+		"link_with_listen_addresses":    "linkNetshPort cannot be combined with listenAddresses",
+		"proxy_name_taken":              "Proxy name already exists: %s",
+		"netsh_add_failed":              "Failed to add the netsh rule: %v",
+		"update_toml_failed":            "Failed to update frpc.toml: %v",
+		"preset_not_found":              "Preset %q does not exist",
+		"invalid_overrides":             "Invalid overrides: %v",
+		"missing_connect_addr":          "Missing connectAddr",
+		"invalid_range_chars":           "name, manager or connectAddr contains illegal characters",
+		"invalid_listen_range":          "Invalid listen port range",
+		"range_too_large":               "At most %d ports can be added at once",
+		"range_out_of_bounds":           "Port range exceeds 1-65535",
+		"netsh_batch_add_failed":        "Failed to add netsh rules in batch: %v",
+		"netsh_add_port_failed":         "Failed to add the netsh rule for port %s: %v",
+		"netsh_delete_failed":           "Failed to delete the netsh rule: %v",
+		"invalid_new_connect_address":   "Invalid newConnectAddress",
+		"netsh_edit_failed":             "Failed to edit the netsh rule: %v",
+		"netsh_batch_delete_failed":     "Failed to delete netsh rules in batch: %v",
+		"unsupported_policy":            "Unsupported policy %s (only report and prune)",
+		"invalid_stop_delay":            "Invalid delay parameter (e.g. 30s, at most 5m)",
+		"invalid_server_addr":           "Invalid serverAddr",
+		"invalid_server_port":           "Invalid serverPort",
+		"invalid_transport_protocol":    "transportProtocol must be one of %s",
+		"nothing_to_update":             "No fields to update",
+		"invalid_log_level_setting":     "level must be one of trace/debug/info/warn/error",
+		"invalid_max_days":              "Invalid maxDays (1-3650)",
+		"invalid_token":                 "Invalid token",
+		"missing_proxy_name":            "Missing proxy name",
+		"proxy_read_failed":             "Failed to read FRP proxies: %v",
+		"proxy_not_found":               "Proxy does not exist: %s",
+		"unsupported_signal":            "Unsupported signal %s (only reload and quit)",
+		"missing_public_addr":           "Missing serverAddr or remotePort; cannot determine the public address",
+		"no_custom_domains":             "The proxy has no customDomains",
+		"unsupported_check_type":        "Checking %s proxies is not supported",
+		"invalid_lines":                 "Invalid lines parameter",
+		"invalid_level":                 "Invalid level parameter",
+		"log_clear_failed":              "Failed to clear the log: %v",
+		"streaming_unsupported":         "Streaming responses are not supported",
+		"invalid_offset":                "Invalid offset parameter",
+		"invalid_limit":                 "Invalid limit parameter (1-1000)",
+		"invalid_service_name":          "Invalid service name",
+		"service_no_ports":              "Service %s is not listening on any TCP port",
+		"missing_config":                "Missing config",
+		"invalid_launch_seconds":        "launchSeconds must be between 1 and %d",
+		"frpc_exe_not_found":            "frpc executable not found: %s",
+		"temp_config_create_failed":     "Failed to create the temporary config: %v",
+		"temp_config_write_failed":      "Failed to write the temporary config: %v",
+		"iphlpsvc_confirm":              "Restarting IP Helper briefly interrupts all portproxy forwarding; set confirm: true to proceed",
+		"write_file_failed":             "Failed to write %s: %v",
+		"delete_file_failed":            "Failed to delete %s: %v",
+		"frpc_stop_failed":              "Failed to stop frpc: %v",
+		"visitor_name_taken":            "Visitor name already exists: %s",
+		"visitor_bind_taken":            "%s:%s is already used by visitor %s",
+		"visitor_not_found":             "Visitor does not exist: %s",
+		"connect_check_failed":          "The connectAddr check failed; set ignoreConnectCheck to add the rule anyway",
+		"resume_start_failed":           "Resumed, but starting frpc failed: %v",
+		"invalid_chars":                 "%s contains the illegal character %q",
+		"field_empty":                   "%s must not be empty",
+		"field_too_long":                "%s is too long (%d bytes, at most %d)",
+		"invalid_port":                  "%s must be a port number between 1 and 65535: %q",
+		"invalid_connect_addr":          "Invalid connectAddr: %q",
+		"secret_no_remote_port":         "%s proxies do not use remotePort",
+		"secret_key_required":           "%s proxies require a secretKey",
+		"invalid_proxy_protocol":        "proxyProtocolVersion must be v1 or v2: %q",
+		"tcp_only":                      "Windows portproxy only supports tcp: %q",
+		"listen_addresses_v4_only":      "listenAddresses only apply to IPv4 listeners (family %s)",
+		"invalid_listen_address":        "Invalid listen address (IPv4 required): %q",
+		"duplicate_listen_address":      "Duplicate listen address: %s",
+		"health_check_type_required":    "healthCheckType is required when setting health check options",
+		"unsupported_health_check":      "Unsupported health check type %s (only tcp and http)",
+		"invalid_health_check_interval": "healthCheckIntervalSeconds must be between 1 and 3600",
+		"invalid_health_check_timeout":  "healthCheckTimeoutSeconds must be between 1 and 60",
+		"health_check_timeout_too_long": "healthCheckTimeoutSeconds must not exceed healthCheckIntervalSeconds",
+		"health_check_path_http_only":   "healthCheckPath only applies to http health checks",
+		"health_check_path_slash":       "healthCheckPath must start with /",
+		"health_check_extra_conflict":   "%s in extraConfig conflicts with the health check settings",
+		"invalid_tag":                   "Invalid tag: %q",
+		"invalid_visitor_type":          "Visitor type must be one of %s: %q",
+		"visitor_server_name_required":  "A visitor requires serverName",
+		"visitor_secret_required":       "A visitor requires secretKey",
+		"invalid_bind_addr":             "Invalid bindAddr: %q",
+		"proxy_name_type_required":      "A proxy requires name and type",
+		"proxy_error":                   "%s: %v",
+		"frpc_check_failed":             "Failed to check the process state: %v",
+		"frpc_already_running":          "frpc is already running",
+		"frpc_log_create_failed":        "Failed to create the log file: %v",
+		"frpc_start_failed":             "Failed to start frpc: %v",
+		"frpc_find_failed":              "Failed to find the process: %v",
+		"frpc_kill_failed":              "Failed to stop the process: %v (TerminateProcess: %v)",
+		"frpc_signal_failed":            "Failed to send %s: %v",
+		"invalid_local_addr":            "Invalid localAddr %q: %v",
+		"local_addr_connect_mismatch":   "localAddr %s does not match connectAddr/connectPort",
+		"local_addr_not_loopback":       "localAddr of a tcp proxy must be a local address (forwarded by netsh): %s",
+		"local_addr_port_mismatch":      "localAddr %s does not match %s %s",
+		"invalid_extra_key":             "Invalid extraConfig key: %q",
+		"reserved_extra_key":            "extraConfig cannot override the managed key %s",
+		"invalid_extra_value":           "extraConfig.%s is not a valid single-line TOML value: %q",
+		"remote_port_pool_exhausted":    "All ports in remotePortPool are in use (%s)",
+		"netsh_port_not_found":          "No netsh rule listens on port %s",
+		"netsh_rule_not_found":          "Rule %s:%s not found",
+		"unsupported_rule_sort":         "Unsupported sort %s (only listenPort and connectPort)",
+		"remote_port_taken":             "Remote port %s is already used by proxy %s",
+		"listen_port_taken":             "Listen port %s is already used by netsh rule %s",
+		"copy_remote_port_required":     "The source proxy uses a remote port; specify a new one for the copy",
+		"copy_no_remote_port":           "The source proxy has no remotePort field",
+		"duplicate_proxy_name":          "Duplicate proxy name: %s",
+		"proxy_missing_name":            "Proxy #%d has no name",
+		"reorder_count_mismatch":        "%d names given but there are %d proxies",
+		"duplicate_name":                "Duplicate name: %s",
+		"frpc_stop_delayed":             "A delayed stop of frpc is already pending; try again later",
+		"stop_delay_cancelled":          "The request was cancelled during the stop delay; frpc was not stopped",
+		"unsupported_sort":              "Unsupported sort order: %s",
+	},
+}
+
+// matchLang maps a language tag such as "en-US" or "zh_CN" to a catalog
+func matchLang(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if i := strings.IndexAny(tag, "-_"); i >= 0 {
+		tag = tag[:i]
+	}
+	if _, ok := messageCatalogs[tag]; ok {
+		return tag
+	}
+	return ""
+}
+
+// requestLang picks the response language from ?lang=, then the
+// Accept-Language header in order of preference, then defaultLang
+func requestLang(r *http.Request) string {
+	if lang := matchLang(r.URL.Query().Get("lang")); lang != "" {
+		return lang
+	}
+
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var tags []weighted
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		tags = append(tags, weighted{tag, q})
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+	for _, t := range tags {
+		if lang := matchLang(t.tag); lang != "" && t.q > 0 {
+			return lang
+		}
+	}
+	return defaultLang
+}
+
+// msg returns the text for id in the request's language, formatted with
+// args; unknown IDs fall back to the default catalog and then to the ID
+func msg(r *http.Request, id string, args ...interface{}) string {
+	return localize(requestLang(r), id, args)
+}
+
+// localize formats id from lang's catalog. Arguments that are themselves
+// localized errors are rendered in the same language
+func localize(lang, id string, args []interface{}) string {
+	text, ok := messageCatalogs[lang][id]
+	if !ok {
+		if text, ok = messageCatalogs[defaultLang][id]; !ok {
+			text = id
+		}
 	}
-	return nil
+	if len(args) == 0 {
+		return text
+	}
+	rendered := make([]interface{}, len(args))
+	for i, arg := range args {
+		if le, ok := arg.(*localizedError); ok {
+			arg = localize(lang, le.id, le.args)
+		}
+		rendered[i] = arg
+	}
+	return fmt.Sprintf(text, rendered...)
 }
 
-func deleteFrpProxy(proxyName string) error {
-	// Read the entire file
-	content, err := os.ReadFile(config.FrpcTomlPath)
-	if err != nil {
-		return err
-	}
+// localizedError is an error whose text comes from messageCatalogs, so a
+// handler can report it in the client's language while logs keep the
+// default one
+type localizedError struct {
+	id   string
+	args []interface{}
+}
 
-	lines := strings.Split(string(content), "\n")
-	var newLines []string
-	var skipProxy bool
-	reName := regexp.MustCompile(`^\s*name\s*=\s*"(.*)"`)
+func (e *localizedError) Error() string {
+	return localize(defaultLang, e.id, e.args)
+}
 
-	for i := 0; i < len(lines); i++ {
-		line := lines[i]
-		trimmed := strings.TrimSpace(line)
+// msgError returns an error rendered from the catalog entry id
+func msgError(id string, args ...interface{}) error {
+	return &localizedError{id: id, args: args}
+}
 
-		// Check if we're starting a new proxy block
-		if trimmed == "[[proxies]]" {
-			// Look ahead to check the name
-			if i+1 < len(lines) {
-				nextLine := lines[i+1]
-				if matches := reName.FindStringSubmatch(nextLine); len(matches) > 1 {
-					if matches[1] == proxyName {
-						// This is the proxy to delete
-						skipProxy = true
-						continue // Skip the [[proxies]] line
-					}
-				}
-			}
-			skipProxy = false
-		}
+// errorCodeHeader carries the catalog ID of an error response, which the
+// version 2 envelope reports as error.code
+const errorCodeHeader = "X-Error-Code"
 
-		// If we're in the target proxy block, skip all lines until next [[proxies]]
-		if skipProxy {
-			// Check if this is the start of a new section
-			if trimmed == "[[proxies]]" || (strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]")) {
-				skipProxy = false
-				newLines = append(newLines, line)
-			}
-			continue
-		}
+// writeMsgError is http.Error with the text of catalog entry id; the ID goes
+// out as errorCodeHeader so clients can match on it
+func writeMsgError(w http.ResponseWriter, r *http.Request, code int, id string, args ...interface{}) {
+	w.Header().Set(errorCodeHeader, id)
+	http.Error(w, msg(r, id, args...), code)
+}
+
+// writeError reports err like http.Error, with its catalog ID as
+// errorCodeHeader when it came from msgError
+func writeError(w http.ResponseWriter, r *http.Request, err error, code int) {
+	if le, ok := err.(*localizedError); ok {
+		w.Header().Set(errorCodeHeader, le.id)
+	}
+	http.Error(w, errText(r, err), code)
+}
 
-		newLines = append(newLines, line)
+// errText is the client-facing text of err: localized if it came from
+// msgError, otherwise its plain message (e.g. netsh or OS output)
+func errText(r *http.Request, err error) string {
+	if le, ok := err.(*localizedError); ok {
+		return localize(requestLang(r), le.id, le.args)
 	}
+	return err.Error()
+}
 
-	// Write back to file
-	return os.WriteFile(config.FrpcTomlPath, []byte(strings.Join(newLines, "\n")), 0644)
+// localizeFrpcStatus rewrites the human-readable fields of a status map for
+// the request; messageCode and errorInfo.code stay as they are
+func localizeFrpcStatus(r *http.Request, status map[string]interface{}) {
+	switch status["messageCode"] {
+	case "simulation":
+		status["message"] = msg(r, "simulation")
+	case "config_unspecified":
+		status["message"] = msg(r, "config_unspecified", config.FrpcTomlPath)
+	case "config_mismatch":
+		status["message"] = msg(r, "config_mismatch", status["configPath"], config.FrpcTomlPath)
+	}
+	// errorInfo.message keeps the full detail in the default language
+	if info, ok := status["errorInfo"].(*StatusError); ok && requestLang(r) != defaultLang {
+		status["error"] = msg(r, info.Code)
+	}
 }
 
 // ========================================
-// FRP Process Management
+// FRP Visitors
 // ========================================
 
-// getFrpcExeName extracts the executable name from frpcExePath
-func getFrpcExeName() string {
-	return filepath.Base(config.FrpcExePath)
+// FrpVisitor is a [[visitors]] entry: a local listener on bindAddr:bindPort
+// that reaches another client's stcp/xtcp/sudp proxy named serverName
+type FrpVisitor struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	ServerName string `json:"serverName"`
+	// ServerUser is the user of the client that owns serverName, if different
+	ServerUser string `json:"serverUser,omitempty"`
+	SecretKey  string `json:"secretKey"`
+	BindAddr   string `json:"bindAddr,omitempty"`
+	BindPort   string `json:"bindPort"`
 }
 
-// getFrpcProcess finds the running frpc process
-func getFrpcProcess() (*os.Process, error) {
-	if runtime.GOOS != "windows" {
-		log.Println("[模拟] 查找 frpc 进程")
-		return nil, nil
-	}
-
-	// Get the executable name from config
-	exeName := getFrpcExeName()
+// reVisitorKey matches the visitor keys the manager models
+var reVisitorKey = regexp.MustCompile(`^\s*(name|type|serverName|serverUser|secretKey|bindAddr|bindPort)\s*=\s*(.+?)\s*$`)
 
-	// Use tasklist to find the process
-	cmd := exec.Command("tasklist", "/FI", fmt.Sprintf("IMAGENAME eq %s", exeName), "/FO", "CSV", "/NH")
-	hideWindow(cmd)
-	output, err := cmd.Output()
+// getFrpVisitors lists the [[visitors]] of the managed proxies file
+func getFrpVisitors() ([]FrpVisitor, error) {
+	lines, _, err := readProxiesToml()
 	if err != nil {
 		return nil, err
 	}
+	return parseFrpVisitors(lines), nil
+}
 
-	// Parse CSV output
-	reader := csv.NewReader(strings.NewReader(string(output)))
-	records, err := reader.ReadAll()
-	if err != nil {
-		return nil, fmt.Errorf("parsing tasklist output: %v", err)
-	}
-
-	for _, record := range records {
-		if len(record) >= 2 && record[0] == exeName {
-			pidStr := record[1]
-			var pid int
-			if _, err := fmt.Sscanf(pidStr, "%d", &pid); err != nil {
-				continue
+// parseFrpVisitors parses the [[visitors]] tables in lines. Keys of
+// [visitors.x] sub-tables are not modelled and are skipped.
+func parseFrpVisitors(lines []string) []FrpVisitor {
+	visitors := []FrpVisitor{}
+	var current *FrpVisitor
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") {
+			if current != nil {
+				visitors = append(visitors, *current)
+				current = nil
 			}
-
-			process, err := os.FindProcess(pid)
-			if err != nil {
-				return nil, err
+			if trimmed == "[[visitors]]" {
+				current = &FrpVisitor{}
 			}
-			return process, nil
+			continue
+		}
+		if current == nil {
+			continue
+		}
+
+		matches := reVisitorKey.FindStringSubmatch(trimmed)
+		if matches == nil {
+			continue
 		}
+		value := tomlUnquote(matches[2])
+		switch matches[1] {
+		case "name":
+			current.Name = value
+		case "type":
+			current.Type = normalizeProxyType(value)
+		case "serverName":
+			current.ServerName = value
+		case "serverUser":
+			current.ServerUser = value
+		case "secretKey":
+			current.SecretKey = value
+		case "bindAddr":
+			current.BindAddr = value
+		case "bindPort":
+			current.BindPort = value
+		}
+	}
+	if current != nil {
+		visitors = append(visitors, *current)
 	}
+	return visitors
+}
 
-	return nil, nil // Process not found
+// frpVisitorBlock renders a visitor as a [[visitors]] block
+func frpVisitorBlock(v FrpVisitor) []string {
+	lines := []string{
+		"[[visitors]]",
+		"name = " + tomlQuote(v.Name),
+		"type = " + tomlQuote(v.Type),
+		"serverName = " + tomlQuote(v.ServerName),
+	}
+	if v.ServerUser != "" {
+		lines = append(lines, "serverUser = "+tomlQuote(v.ServerUser))
+	}
+	lines = append(lines, "secretKey = "+tomlQuote(v.SecretKey))
+	if v.BindAddr != "" {
+		lines = append(lines, "bindAddr = "+tomlQuote(v.BindAddr))
+	}
+	return append(lines, "bindPort = "+v.BindPort)
 }
 
-// stopFrpc stops the running frpc process
-func stopFrpc() error {
-	if runtime.GOOS != "windows" {
-		log.Println("[模拟] 停止 frpc 进程")
-		return nil
+// validateFrpVisitor checks the fields each visitor type requires
+func validateFrpVisitor(v FrpVisitor) error {
+	if err := validateProxyName("name", v.Name); err != nil {
+		return err
 	}
+	if !slices.Contains(secretProxyTypes, v.Type) {
+		return msgError("invalid_visitor_type", strings.Join(secretProxyTypes, ", "), v.Type)
+	}
+	if v.ServerName == "" {
+		return msgError("visitor_server_name_required")
+	}
+	if v.SecretKey == "" {
+		return msgError("visitor_secret_required")
+	}
+	for field, value := range map[string]string{
+		"serverName": v.ServerName,
+		"serverUser": v.ServerUser,
+		"secretKey":  v.SecretKey,
+		"bindAddr":   v.BindAddr,
+	} {
+		if err := validateTomlString(field, value); err != nil {
+			return err
+		}
+	}
+	if v.BindAddr != "" && net.ParseIP(v.BindAddr) == nil {
+		return msgError("invalid_bind_addr", v.BindAddr)
+	}
+	return validatePort("bindPort", v.BindPort)
+}
 
-	process, err := getFrpcProcess()
+// handleGetFrpVisitors lists the visitors with their secretKey redacted
+func handleGetFrpVisitors(w http.ResponseWriter, r *http.Request) {
+	visitors, err := getFrpVisitors()
 	if err != nil {
-		return fmt.Errorf("查找进程失败: %v", err)
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	for i := range visitors {
+		if visitors[i].SecretKey != "" {
+			visitors[i].SecretKey = redactedSecret
+		}
 	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(visitors)
+}
 
-	if process == nil {
-		return nil // Already stopped
+// handleAddFrpVisitor appends a [[visitors]] block
+func handleAddFrpVisitor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
 
-	// Get the executable name from config
-	exeName := getFrpcExeName()
+	var v FrpVisitor
+	if !decodeJSONBody(w, r, &v) {
+		return
+	}
+	v.Type = normalizeProxyType(v.Type)
+	if v.BindAddr == "" {
+		v.BindAddr = "127.0.0.1"
+	}
+	if err := validateFrpVisitor(v); err != nil {
+		writeError(w, r, err, http.StatusBadRequest)
+		return
+	}
 
-	// Kill the process using taskkill for more reliable termination
-	cmd := exec.Command("taskkill", "/F", "/IM", exeName)
-	hideWindow(cmd)
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("停止进程失败: %v", err)
+	visitors, err := getFrpVisitors()
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	for _, existing := range visitors {
+		if existing.Name == v.Name {
+			writeMsgError(w, r, http.StatusConflict, "visitor_name_taken", v.Name)
+			return
+		}
+		// A wildcard bindAddr takes the port on every address
+		existingAddr := cmp.Or(existing.BindAddr, "127.0.0.1")
+		if existing.BindPort == v.BindPort && bindAddrsOverlap(existingAddr, v.BindAddr) {
+			writeMsgError(w, r, http.StatusConflict, "visitor_bind_taken", existingAddr, v.BindPort, existing.Name)
+			return
+		}
 	}
 
-	log.Printf("%s 进程已停止", exeName)
-	return nil
+	if err := appendProxiesToml("\n" + strings.Join(frpVisitorBlock(v), "\n") + "\n"); err != nil {
+		writeMsgError(w, r, http.StatusInternalServerError, "update_toml_failed", err)
+		return
+	}
+	log.Printf("已添加 visitor: %s (%s -> %s, 监听 %s:%s)", v.Name, v.Type, v.ServerName, v.BindAddr, v.BindPort)
+
+	restart := restartAfterEdit(r)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "success", "name": v.Name, "restart": restart})
 }
 
-// startFrpc starts the frpc process
-func startFrpc() error {
-	if runtime.GOOS != "windows" {
-		log.Println("[模拟] 启动 frpc 进程")
-		return nil
+// handleDeleteFrpVisitor removes the named [[visitors]] block along with its
+// sub-tables
+func handleDeleteFrpVisitor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
 
-	// Check if already running
-	process, err := getFrpcProcess()
-	if err != nil {
-		return fmt.Errorf("检查进程状态失败: %v", err)
+	var req struct {
+		Name string `json:"name"`
 	}
-
-	if process != nil {
-		return fmt.Errorf("frpc 已经在运行")
+	if !decodeJSONBody(w, r, &req) {
+		return
 	}
 
-	// Start frpc in background
-	cmd := exec.Command(config.FrpcExePath, "-c", config.FrpcTomlPath)
-	hideWindow(cmd)
-
-	// Redirect output to log files
-	logFile, err := os.OpenFile("frpc.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	lines, eol, err := readProxiesToml()
 	if err != nil {
-		return fmt.Errorf("创建日志文件失败: %v", err)
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
 	}
 
-	cmd.Stdout = logFile
-	cmd.Stderr = logFile
-
-	if err := cmd.Start(); err != nil {
-		logFile.Close()
-		return fmt.Errorf("启动 frpc 失败: %v", err)
+	var kept []string
+	found, skipping := false, false
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") {
+			if skipping && strings.HasPrefix(trimmed, "[visitors.") {
+				continue
+			}
+			skipping = false
+			if trimmed == "[[visitors]]" {
+				if block := parseFrpVisitors(lines[i:]); len(block) > 0 && block[0].Name == req.Name {
+					found, skipping = true, true
+					continue
+				}
+			}
+		}
+		if !skipping {
+			kept = append(kept, line)
+		}
+	}
+	if !found {
+		writeMsgError(w, r, http.StatusNotFound, "visitor_not_found", req.Name)
+		return
 	}
 
-	// Don't wait for the process
-	go func() {
-		cmd.Wait()
-		logFile.Close()
-	}()
+	if err := writeProxiesToml(kept, eol); err != nil {
+		writeMsgError(w, r, http.StatusInternalServerError, "update_toml_failed", err)
+		return
+	}
+	log.Printf("已删除 visitor: %s", req.Name)
 
-	log.Printf("frpc 已启动 (PID: %d, 日志: frpc.log)", cmd.Process.Pid)
-	return nil
+	restart := restartAfterEdit(r)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "success", "restart": restart})
 }
 
-// restartFrpc restarts the frpc process
-func restartFrpc() error {
-	log.Println("正在重启 frpc...")
-
-	// Stop if running
-	if err := stopFrpc(); err != nil {
-		log.Printf("警告: 停止 frpc 时出错: %v", err)
-	}
+// ========================================
+// Response Envelope
+// ========================================
 
-	// Wait a moment for the process to fully stop
-	if runtime.GOOS == "windows" {
-		timeoutCmd := exec.Command("timeout", "/t", "1", "/nobreak")
-		hideWindow(timeoutCmd)
-		timeoutCmd.Run()
-	}
+// API versions: 1 is the legacy per-handler shape, 2 wraps every /api/
+// response as {"ok":true,"apiVersion":2,"data":...} or
+// {"ok":false,"apiVersion":2,"error":{...}}
+const (
+	apiVersionLegacy   = 1
+	apiVersionEnvelope = 2
+)
 
-	// Start frpc
-	return startFrpc()
+// EnvelopeError is the error object of a version 2 failure response
+type EnvelopeError struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Details is the handler's own JSON error body, if it sent one
+	Details json.RawMessage `json:"details,omitempty"`
 }
 
-// getFrpcStatus returns the status of frpc process
-func getFrpcStatus() map[string]interface{} {
-	status := map[string]interface{}{
-		"running": false,
-		"pid":     0,
+// requestAPIVersion picks the response shape from ?apiVersion= or the
+// X-API-Version header; otherwise legacyResponses decides
+func requestAPIVersion(r *http.Request) int {
+	v := r.URL.Query().Get("apiVersion")
+	if v == "" {
+		v = r.Header.Get("X-API-Version")
 	}
-
-	if runtime.GOOS != "windows" {
-		status["running"] = false
-		status["message"] = "模拟模式"
-		return status
+	switch v {
+	case "1":
+		return apiVersionLegacy
+	case "2":
+		return apiVersionEnvelope
 	}
-
-	process, err := getFrpcProcess()
-	if err != nil {
-		status["error"] = err.Error()
-		return status
+	if config.LegacyResponses {
+		return apiVersionLegacy
 	}
+	return apiVersionEnvelope
+}
 
-	if process != nil {
-		status["running"] = true
-		status["pid"] = process.Pid
-	}
+// envelopeMiddleware wraps /api/ responses in the version 2 envelope.
+// Streams and non-JSON bodies (raw text, TOML, zip) pass through unchanged.
+func envelopeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		version := requestAPIVersion(r)
+		w.Header().Set("X-API-Version", strconv.Itoa(version))
+		if version == apiVersionLegacy || gzipSkipPaths[r.URL.Path] || r.Method == "OPTIONS" {
+			next.ServeHTTP(w, r)
+			return
+		}
 
-	return status
+		ew := &envelopeWriter{ResponseWriter: w}
+		defer ew.close()
+		next.ServeHTTP(ew, r)
+	})
 }
 
-// ========================================
-// FRP Control API Handlers
-// ========================================
+// envelopeWriter modes, decided on the first write
+const (
+	envelopeUndecided = iota
+	envelopePass
+	envelopeData
+	envelopeError
+)
 
-func handleStartFrpc(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+// envelopeWriter streams successful JSON bodies between the envelope's
+// prefix and suffix and buffers error bodies to rebuild them
+type envelopeWriter struct {
+	http.ResponseWriter
+	status  int
+	mode    int
+	started bool
+	buf     bytes.Buffer
+}
 
-	if err := startFrpc(); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+func (e *envelopeWriter) WriteHeader(code int) {
+	if e.status == 0 {
+		e.status = code
 	}
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "success", "message": "frpc 已启动"})
+func (e *envelopeWriter) Write(p []byte) (int, error) {
+	if e.status == 0 {
+		e.status = http.StatusOK
+	}
+	if e.mode == envelopeUndecided {
+		e.decide(p)
+	}
+	switch e.mode {
+	case envelopeError:
+		return e.buf.Write(p)
+	case envelopeData:
+		if !e.started {
+			e.started = true
+			e.ResponseWriter.WriteHeader(e.status)
+			fmt.Fprintf(e.ResponseWriter, `{"ok":true,"apiVersion":%d,"data":`, apiVersionEnvelope)
+		}
+	}
+	return e.ResponseWriter.Write(p)
 }
 
-func handleStopFrpc(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// decide picks the mode from the status, Content-Type and, for handlers that
+// leave Content-Type unset, the first byte of the body
+func (e *envelopeWriter) decide(p []byte) {
+	ct := e.Header().Get("Content-Type")
+	first := bytes.TrimLeft(p, " \t\r\n")
+	switch {
+	case e.status >= 400:
+		e.mode = envelopeError
+	case strings.HasPrefix(ct, "application/json"),
+		ct == "" && len(first) > 0 && (first[0] == '{' || first[0] == '['):
+		e.mode = envelopeData
+	default:
+		e.mode = envelopePass
+		e.ResponseWriter.WriteHeader(e.status)
 		return
 	}
+	e.Header().Set("Content-Type", "application/json")
+	e.Header().Del("Content-Length")
+}
 
-	if err := stopFrpc(); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+// Flush passes through once the body has started streaming
+func (e *envelopeWriter) Flush() {
+	if e.mode == envelopePass || e.started {
+		if f, ok := e.ResponseWriter.(http.Flusher); ok {
+			f.Flush()
+		}
 	}
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "success", "message": "frpc 已停止"})
+func (e *envelopeWriter) Unwrap() http.ResponseWriter {
+	return e.ResponseWriter
 }
 
-func handleRestartFrpc(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+// close finishes the envelope once the handler has returned
+func (e *envelopeWriter) close() {
+	if e.status == 0 {
+		e.status = http.StatusOK
 	}
-
-	if err := restartFrpc(); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	switch e.mode {
+	case envelopePass:
+		return
+	case envelopeData:
+		io.WriteString(e.ResponseWriter, "}\n")
 		return
+	case envelopeUndecided:
+		if e.status < 400 {
+			// No body: nothing to wrap for 204/304, null data otherwise
+			if e.status == http.StatusNoContent || e.status == http.StatusNotModified {
+				e.ResponseWriter.WriteHeader(e.status)
+				return
+			}
+			e.Header().Set("Content-Type", "application/json")
+			e.ResponseWriter.WriteHeader(e.status)
+			fmt.Fprintf(e.ResponseWriter, `{"ok":true,"apiVersion":%d,"data":null}`+"\n", apiVersionEnvelope)
+			return
+		}
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "success", "message": "frpc 已重启"})
-}
+	// The catalog ID from writeMsgError/writeError, else the status slug
+	envErr := EnvelopeError{
+		Status:  e.status,
+		Code:    cmp.Or(e.Header().Get(errorCodeHeader), strings.ReplaceAll(strings.ToLower(http.StatusText(e.status)), " ", "_")),
+		Message: strings.TrimSpace(e.buf.String()),
+	}
+	if body := bytes.TrimSpace(e.buf.Bytes()); json.Valid(body) && len(body) > 0 {
+		envErr.Details = body
+		var fields struct {
+			Message string `json:"message"`
+			Error   string `json:"error"`
+			Code    string `json:"code"`
+		}
+		if json.Unmarshal(body, &fields) == nil {
+			envErr.Message = cmp.Or(fields.Message, fields.Error)
+			if fields.Code != "" {
+				envErr.Code = fields.Code
+			}
+		}
+	}
+	if envErr.Message == "" {
+		envErr.Message = http.StatusText(e.status)
+	}
 
-func handleFrpcStatus(w http.ResponseWriter, r *http.Request) {
-	status := getFrpcStatus()
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(status)
+	e.Header().Set("Content-Type", "application/json")
+	e.ResponseWriter.WriteHeader(e.status)
+	json.NewEncoder(e.ResponseWriter).Encode(struct {
+		OK         bool          `json:"ok"`
+		APIVersion int           `json:"apiVersion"`
+		Error      EnvelopeError `json:"error"`
+	}{false, apiVersionEnvelope, envErr})
 }
@@ -0,0 +1,1140 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setTestConfig replaces the global config for the duration of a test
+func setTestConfig(t *testing.T, c Config) {
+	t.Helper()
+	saved := config
+	config = c
+	t.Cleanup(func() { config = saved })
+}
+
+// writeTestToml writes content to frpc.toml in a temp dir and points the
+// config at it
+func writeTestToml(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "frpc.toml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	setTestConfig(t, Config{FrpcTomlPath: path})
+	return path
+}
+
+func TestNamePrefix(t *testing.T) {
+	tests := []struct {
+		name      string
+		separator string
+		pattern   string
+		want      string
+	}{
+		{name: "yzwj-it-10.0.0.5-22", want: "yzwj"},
+		{name: "web-app-1", want: "web"},
+		{name: "dashless", want: "dashless"},
+		{name: "-leading", want: "-leading"},
+		{name: "", want: ""},
+		{name: "web_app_1", separator: "_", want: "web"},
+		{name: "web-app-1", separator: "_", want: "web-app-1"},
+		{name: "web-app-1", pattern: `^(\w+-\w+)-`, want: "web-app"},
+		{name: "web-app-1", pattern: `^\w+`, want: "web"},
+		{name: "dashless", pattern: `^(\w+)-`, want: "dashless"},
+		{name: "web-app-1", pattern: `(`, want: "web"},
+	}
+	for _, tt := range tests {
+		setTestConfig(t, Config{NameSeparator: tt.separator, NamePrefixPattern: tt.pattern})
+		if got := namePrefix(tt.name); got != tt.want {
+			t.Errorf("namePrefix(%q) sep=%q pattern=%q = %q, want %q", tt.name, tt.separator, tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestGetFirstProxyName(t *testing.T) {
+	tests := []struct {
+		desc    string
+		content string
+		want    string
+	}{
+		{"empty file", "", ""},
+		{"no proxies", "serverAddr = \"example.com\"\nserverPort = 7000\n", ""},
+		{"dashless name", "[[proxies]]\nname = \"ssh\"\ntype = \"tcp\"\n", "ssh"},
+		{"multi-dash name", "[[proxies]]\nname = \"web-app-1\"\ntype = \"tcp\"\n", "web"},
+		{"first proxy wins", "[[proxies]]\nname = \"a-1\"\n\n[[proxies]]\nname = \"b-2\"\n", "a"},
+		{"name before proxies ignored", "name = \"client-x\"\n\n[[proxies]]\nname = \"yzwj-it\"\n", "yzwj"},
+		{"escaped name", "[[proxies]]\nname = \"a\\\"b-c\"\n", "a\"b"},
+	}
+	for _, tt := range tests {
+		writeTestToml(t, tt.content)
+		if got := getFirstProxyName(); got != tt.want {
+			t.Errorf("%s: getFirstProxyName() = %q, want %q", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestGetFirstProxyNameMissingFile(t *testing.T) {
+	setTestConfig(t, Config{FrpcTomlPath: filepath.Join(t.TempDir(), "missing.toml")})
+	if got := getFirstProxyName(); got != "" {
+		t.Errorf("getFirstProxyName() = %q, want empty", got)
+	}
+}
+
+// crlfToml is a Windows-style frpc.toml fixture
+const crlfToml = "serverAddr = \"example.com\"\r\n" +
+	"serverPort = 7000\r\n" +
+	"\r\n" +
+	"[[proxies]]\r\n" +
+	"name = \"web-1\"\r\n" +
+	"type = \"tcp\"\r\n" +
+	"localIP = \"127.0.0.1\"\r\n" +
+	"localPort = 8080\r\n" +
+	"remotePort = 18080\r\n" +
+	"\r\n" +
+	"[[proxies]]\r\n" +
+	"# ssh access\r\n" +
+	"name = \"ssh-1\"\r\n" +
+	"type = \"tcp\"\r\n" +
+	"localPort = 22\r\n" +
+	"remotePort = 10022\r\n"
+
+// assertCRLF fails unless every line break in path is "\r\n"
+func assertCRLF(t *testing.T, path string) string {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := string(content)
+	if n, crlf := strings.Count(text, "\n"), strings.Count(text, "\r\n"); n != crlf {
+		t.Errorf("%d of %d line breaks are not CRLF:\n%q", n-crlf, n, text)
+	}
+	return text
+}
+
+func TestSplitLinesCRLF(t *testing.T) {
+	lines, eol := splitLines("a = 1\r\nb = 2\r\n")
+	if eol != "\r\n" {
+		t.Errorf("eol = %q, want CRLF", eol)
+	}
+	want := []string{"a = 1", "b = 2", ""}
+	if !slices.Equal(lines, want) {
+		t.Errorf("lines = %q, want %q", lines, want)
+	}
+	if _, eol := splitLines("a = 1\nb = 2\n"); eol != "\n" {
+		t.Errorf("eol = %q, want LF", eol)
+	}
+}
+
+func TestParseFrpProxiesCRLF(t *testing.T) {
+	writeTestToml(t, crlfToml)
+	proxies, err := getFrpProxies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range proxies {
+		names = append(names, p.Name)
+		if strings.ContainsRune(p.Name+p.Type+p.LocalPort+p.RemotePort, '\r') {
+			t.Errorf("proxy %q has a stray \\r: %+v", p.Name, p)
+		}
+	}
+	if want := []string{"web-1", "ssh-1"}; !slices.Equal(names, want) {
+		t.Errorf("names = %q, want %q", names, want)
+	}
+}
+
+func TestRenameFrpProxyKeepsCRLF(t *testing.T) {
+	path := writeTestToml(t, crlfToml)
+	if err := renameFrpProxy("ssh-1", "ssh-2"); err != nil {
+		t.Fatal(err)
+	}
+	text := assertCRLF(t, path)
+	if want := strings.Replace(crlfToml, `"ssh-1"`, `"ssh-2"`, 1); text != want {
+		t.Errorf("got:\n%q\nwant:\n%q", text, want)
+	}
+}
+
+func TestDeleteFrpProxyKeepsCRLF(t *testing.T) {
+	path := writeTestToml(t, crlfToml)
+	if err := deleteFrpProxy("web-1"); err != nil {
+		t.Fatal(err)
+	}
+	text := assertCRLF(t, path)
+	if strings.Contains(text, "web-1") || !strings.Contains(text, "name = \"ssh-1\"\r\n") {
+		t.Errorf("unexpected content after delete:\n%q", text)
+	}
+}
+
+func TestAppendProxiesTomlKeepsCRLF(t *testing.T) {
+	path := writeTestToml(t, crlfToml)
+	if err := appendProxiesToml("\n[[proxies]]\nname = \"new-1\"\ntype = \"tcp\"\nlocalPort = 80\n"); err != nil {
+		t.Fatal(err)
+	}
+	text := assertCRLF(t, path)
+	if !strings.HasSuffix(text, "name = \"new-1\"\r\ntype = \"tcp\"\r\nlocalPort = 80\r\n") {
+		t.Errorf("appended block not converted to CRLF:\n%q", text)
+	}
+}
+
+// maliciousTomlStrings try to break out of a TOML basic string
+var maliciousTomlStrings = []string{
+	"foo\"\n[[proxies]]",
+	"foo\"\nname = \"evil\"",
+	"a\\",
+	"a\\\"b",
+	"line\r\nbreak",
+	"tab\there",
+	"nul\x00byte",
+	"bell\x07",
+	"del\x7f",
+	"\"",
+}
+
+func TestValidateTomlStringRejectsInjection(t *testing.T) {
+	for _, value := range maliciousTomlStrings {
+		if err := validateTomlString("name", value); err == nil {
+			t.Errorf("validateTomlString(%q) = nil, want error", value)
+		}
+	}
+	for _, value := range []string{"", "web-app-1", "10.0.0.5", "办公室 NAS", "a.b_c:d/e"} {
+		if err := validateTomlString("name", value); err != nil {
+			t.Errorf("validateTomlString(%q) = %v, want nil", value, err)
+		}
+	}
+}
+
+func TestValidateAddRuleRequestRejectsInjection(t *testing.T) {
+	base := AddRuleRequest{
+		ListenPort:  "8080",
+		ConnectAddr: "10.0.0.5",
+		ConnectPort: "80",
+		RemotePort:  "18080",
+		Type:        "tcp",
+	}
+	if err := validateAddRuleRequest(base); err != nil {
+		t.Fatalf("base request rejected: %v", err)
+	}
+
+	fields := map[string]func(req *AddRuleRequest, v string){
+		"name":        func(req *AddRuleRequest, v string) { req.Name = v },
+		"manager":     func(req *AddRuleRequest, v string) { req.Manager = v },
+		"connectAddr": func(req *AddRuleRequest, v string) { req.ConnectAddr = v },
+		"description": func(req *AddRuleRequest, v string) { req.Description = v },
+		"group":       func(req *AddRuleRequest, v string) { req.Group = v },
+		"groupKey":    func(req *AddRuleRequest, v string) { req.GroupKey = v },
+		"secretKey":   func(req *AddRuleRequest, v string) { req.SecretKey = v },
+		"listenPort":  func(req *AddRuleRequest, v string) { req.ListenPort = v },
+		"connectPort": func(req *AddRuleRequest, v string) { req.ConnectPort = v },
+		"remotePort":  func(req *AddRuleRequest, v string) { req.RemotePort = v },
+	}
+	for field, set := range fields {
+		for _, value := range append(maliciousTomlStrings, "80\n[[proxies]]") {
+			req := base
+			set(&req, value)
+			if err := validateAddRuleRequest(req); err == nil {
+				t.Errorf("%s = %q accepted, want error", field, value)
+			}
+		}
+	}
+}
+
+func TestTomlQuoteCannotBreakOut(t *testing.T) {
+	reLine := regexp.MustCompile(`^name = ` + tomlBasicString + `$`)
+	for _, value := range maliciousTomlStrings {
+		quoted := tomlQuote(value)
+		if strings.ContainsAny(quoted, "\r\n") {
+			t.Errorf("tomlQuote(%q) = %q spans lines", value, quoted)
+		}
+		if !reLine.MatchString("name = " + quoted) {
+			t.Errorf("tomlQuote(%q) = %q is not a single basic string", value, quoted)
+		}
+
+		writeTestToml(t, "[[proxies]]\nname = "+quoted+"\ntype = \"tcp\"\nlocalPort = 80\n")
+		proxies, err := getFrpProxies()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(proxies) != 1 || proxies[0].Name != value {
+			t.Errorf("tomlQuote(%q) round trip: got %+v", value, proxies)
+		}
+	}
+}
+
+func TestTomlStringRoundTrip(t *testing.T) {
+	reValue := regexp.MustCompile(`^\s*name\s*=\s*` + tomlBasicString)
+	for _, value := range []string{
+		"",
+		"plain",
+		`a"b`,
+		`""`,
+		`c:\frp\frpc.exe`,
+		`trailing\`,
+		`\"`,
+		`\\server\share`,
+		"mixed \\\" and \\\\ \"quoted\"",
+		"tab\tnew\nline",
+		"é 中文 🙂",
+	} {
+		line := "name = " + tomlQuote(value) + ` # comment with "quotes"`
+		m := reValue.FindStringSubmatch(line)
+		if m == nil {
+			t.Errorf("tomlQuote(%q): %q does not match tomlBasicString", value, line)
+			continue
+		}
+		if got := tomlUnescape(m[1]); got != value {
+			t.Errorf("round trip of %q via %q = %q", value, line, got)
+		}
+		if got := tomlUnquote(tomlQuote(value)); got != value {
+			t.Errorf("tomlUnquote(tomlQuote(%q)) = %q", value, got)
+		}
+	}
+}
+
+func TestTomlUnquote(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{`"a\"b"`, `a"b`},
+		{`"c:\\path\\x"`, `c:\path\x`},
+		{`"trailing\\"`, `trailing\`},
+		{`"\u00e9\U0001F642"`, "é🙂"},
+		{`"unknown \q escape"`, `unknown \q escape`},
+		{`"short \u00"`, `short \u00`},
+		{`'literal \n "kept"'`, `literal \n "kept"`},
+		{`8080`, `8080`},
+		{`true`, `true`},
+	}
+	for _, tt := range tests {
+		if got := tomlUnquote(tt.in); got != tt.want {
+			t.Errorf("tomlUnquote(%s) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseFrpProxiesEscapedValues(t *testing.T) {
+	writeTestToml(t, `[[proxies]]
+name = "say \"hi\"-1"
+type = "tcp"
+localIP = "127.0.0.1"
+localPort = 80
+
+[[proxies]]
+name = "c:\\share\\"
+type = "tcp"
+localPort = 81
+`)
+	proxies, err := getFrpProxies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range proxies {
+		names = append(names, p.Name)
+	}
+	if want := []string{`say "hi"-1`, `c:\share\`}; !slices.Equal(names, want) {
+		t.Errorf("names = %q, want %q", names, want)
+	}
+}
+
+func TestDedupeRulesDuplicatedOutput(t *testing.T) {
+	// netsh occasionally lists a rule twice, e.g. after a re-add
+	output := `
+Listen on ipv4:             Connect to ipv4:
+
+Address         Port        Address         Port
+--------------- ----------  --------------- ----------
+0.0.0.0         8080        192.168.1.10    80
+0.0.0.0         2222        192.168.1.11    22
+0.0.0.0         8080        192.168.1.12    8080
+0.0.0.0         2222        192.168.1.11    22
+
+Listen on ipv4:             Connect to ipv6:
+
+Address         Port        Address         Port
+--------------- ----------  --------------- ----------
+0.0.0.0         8080        fd00::10        80
+`
+	rules, duplicates := dedupeRules(parseNetshOutput(output))
+	if duplicates != 2 {
+		t.Errorf("duplicates = %d, want 2", duplicates)
+	}
+	want := []Rule{
+		// The last duplicate's values at the first one's position
+		{ListenAddress: "0.0.0.0", ListenPort: "8080", ConnectAddress: "192.168.1.12", ConnectPort: "8080", Family: "v4tov4", Protocol: "tcp"},
+		{ListenAddress: "0.0.0.0", ListenPort: "2222", ConnectAddress: "192.168.1.11", ConnectPort: "22", Family: "v4tov4", Protocol: "tcp"},
+		// Same listener in another table is a different rule
+		{ListenAddress: "0.0.0.0", ListenPort: "8080", ConnectAddress: "fd00::10", ConnectPort: "80", Family: "v4tov6", Protocol: "tcp"},
+	}
+	if !slices.Equal(rules, want) {
+		t.Errorf("rules = %+v, want %+v", rules, want)
+	}
+
+	if rules, duplicates := dedupeRules(nil); len(rules) != 0 || duplicates != 0 {
+		t.Errorf("dedupeRules(nil) = %v, %d", rules, duplicates)
+	}
+}
+
+func TestParseNetshOutputTables(t *testing.T) {
+	english := `
+Listen on ipv4:             Connect to ipv4:
+
+Address         Port        Address         Port
+--------------- ----------  --------------- ----------
+0.0.0.0         8080        192.168.1.10    80
+
+Listen on ipv6:             Connect to ipv4:
+
+Address         Port        Address         Port
+--------------- ----------  --------------- ----------
+::              2222        192.168.1.11    22
+fe80::1         3389        192.168.1.12    3389
+`
+	chinese := strings.ReplaceAll(`
+侦听 ipv4:                 连接到 ipv4:
+
+地址            端口        地址            端口
+--------------- ----------  --------------- ----------
+0.0.0.0         8080        192.168.1.10    80
+
+侦听 ipv6:                 连接到 ipv4:
+
+地址            端口        地址            端口
+--------------- ----------  --------------- ----------
+::              2222        192.168.1.11    22
+fe80::1         3389        192.168.1.12    3389
+`, "\n", "\r\n")
+	want := []Rule{
+		{ListenAddress: "0.0.0.0", ListenPort: "8080", ConnectAddress: "192.168.1.10", ConnectPort: "80", Family: "v4tov4", Protocol: "tcp"},
+		{ListenAddress: "::", ListenPort: "2222", ConnectAddress: "192.168.1.11", ConnectPort: "22", Family: "v6tov4", Protocol: "tcp"},
+		{ListenAddress: "fe80::1", ListenPort: "3389", ConnectAddress: "192.168.1.12", ConnectPort: "3389", Family: "v6tov4", Protocol: "tcp"},
+	}
+	for desc, output := range map[string]string{"english": english, "chinese crlf": chinese} {
+		if got := parseNetshOutput(output); !slices.Equal(got, want) {
+			t.Errorf("%s: got %+v, want %+v", desc, got, want)
+		}
+	}
+}
+
+func TestParseNetshOutputEmpty(t *testing.T) {
+	for _, output := range []string{"", "\r\n", "\nListen on ipv4:             Connect to ipv4:\n\nAddress         Port        Address         Port\n--------------- ----------  --------------- ----------\n"} {
+		if got := parseNetshOutput(output); len(got) != 0 {
+			t.Errorf("parseNetshOutput(%q) = %+v, want none", output, got)
+		}
+	}
+}
+
+func TestNormalizeProxyType(t *testing.T) {
+	tests := []struct {
+		in    string
+		want  string
+		known bool
+	}{
+		{"tcp", "tcp", true},
+		{"TCP", "tcp", true},
+		{"Tcp", "tcp", true},
+		{" udp ", "udp", true},
+		{"\tSTCP\n", "stcp", true},
+		{"HTTPS", "https", true},
+		{"", "", false},
+		{"Socks5", "socks5", false},
+		{"t c p", "t c p", false},
+	}
+	for _, tt := range tests {
+		got := normalizeProxyType(tt.in)
+		if got != tt.want {
+			t.Errorf("normalizeProxyType(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		if known := slices.Contains(frpProxyTypes, got); known != tt.known {
+			t.Errorf("type %q known = %v, want %v", got, known, tt.known)
+		}
+		if !tt.known {
+			if err := validateFrpProxy(FrpProxy{Name: "p", Type: got, LocalPort: "80"}); err == nil {
+				t.Errorf("validateFrpProxy accepted type %q", got)
+			}
+		}
+	}
+}
+
+func TestParseFrpProxiesNormalizesType(t *testing.T) {
+	writeTestToml(t, "[[proxies]]\nname = \"a\"\ntype = \"TCP\"\nlocalPort = 80\n\n[[proxies]]\nname = \"b\"\ntype = \" Udp \"\nlocalPort = 53\n")
+	proxies, err := getFrpProxies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, p := range proxies {
+		types = append(types, p.Type)
+	}
+	if want := []string{"tcp", "udp"}; !slices.Equal(types, want) {
+		t.Errorf("types = %q, want %q", types, want)
+	}
+}
+
+func TestFrpcLogStreamExitsWhenAbandoned(t *testing.T) {
+	saved := frpcLogRing
+	frpcLogRing = newLineRing(10)
+	t.Cleanup(func() { frpcLogRing = saved })
+	io.WriteString(frpcLogRing, "backlog line\n")
+
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		handleFrpcLogStream(w, r)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(resp.Body)
+	if line, _ := reader.ReadString('\n'); line != "data: backlog line\n" {
+		t.Fatalf("first line = %q", line)
+	}
+	io.WriteString(frpcLogRing, "live line\n")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line == "data: live line\n" {
+			break
+		}
+	}
+
+	// Abandon the stream without reading further
+	cancel()
+	resp.Body.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler still running after the client went away")
+	}
+}
+
+func TestEncryptConfigFileRoundTrip(t *testing.T) {
+	t.Setenv(configKeyEnv, "correct horse")
+	path := filepath.Join(t.TempDir(), "config.json")
+	plain := []byte(`{"port": 8090, "authToken": "s3cret"}`)
+	if err := os.WriteFile(path, plain, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := encryptConfigFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	content, _ := os.ReadFile(path)
+	var enc EncryptedConfig
+	if err := json.Unmarshal(content, &enc); err != nil {
+		t.Fatal(err)
+	}
+	if enc.Salt == "" || enc.Iterations != configKDFIterations {
+		t.Errorf("salt = %q, iterations = %d; want a salt and %d", enc.Salt, enc.Iterations, configKDFIterations)
+	}
+	if strings.Contains(string(content), "s3cret") {
+		t.Error("encrypted file contains the plaintext")
+	}
+
+	got, err := decryptConfig(content)
+	if err != nil || string(got) != string(plain) {
+		t.Fatalf("decryptConfig = %q, %v", got, err)
+	}
+	if err := encryptConfigFile(path); err == nil {
+		t.Error("encrypting an encrypted file succeeded")
+	}
+
+	for _, field := range []string{"salt", "iterations"} {
+		var fields map[string]interface{}
+		json.Unmarshal(content, &fields)
+		delete(fields, field)
+		stripped, _ := json.Marshal(fields)
+		if _, err := decryptConfig(stripped); err == nil {
+			t.Errorf("decrypted a file without %s", field)
+		}
+	}
+
+	t.Setenv(configKeyEnv, "wrong")
+	if _, err := decryptConfig(content); err == nil {
+		t.Error("decrypted with the wrong passphrase")
+	}
+}
+
+func visitorToml(bindPort string) string {
+	return "serverAddr = \"frps.example.com\"\n\n[[visitors]]\nname = \"v\"\ntype = \"stcp\"\nserverName = \"s\"\nsecretKey = \"k\"\nbindAddr = \"127.0.0.1\"\nbindPort = " + bindPort + "\n"
+}
+
+func TestCheckLocalPorts(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	_, busyPort, _ := net.SplitHostPort(busy.Addr().String())
+
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, freePort, _ := net.SplitHostPort(free.Addr().String())
+	free.Close()
+	freeNumber, _ := strconv.Atoi(freePort)
+
+	cases := []struct {
+		name, bindPort string
+		managerPort    int
+		want           string
+	}{
+		{"free", freePort, 18091, ""},
+		{"held by another program", busyPort, 18091, "已被其他程序占用"},
+		{"same as a netsh rule", "8080", 18091, "netsh 规则 0.0.0.0:8080"},
+		{"same as the manager", freePort, freeNumber, "管理器"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			writeTestToml(t, visitorToml(tc.bindPort))
+			config.Port = tc.managerPort
+			conflict := checkLocalPorts()
+			switch {
+			case tc.want == "" && conflict != nil:
+				t.Errorf("unexpected conflict: %s", conflict.Detail)
+			case tc.want != "" && conflict == nil:
+				t.Errorf("no conflict reported, want %q", tc.want)
+			case tc.want != "" && !strings.Contains(conflict.Detail, tc.want):
+				t.Errorf("Detail = %q, want it to mention %q", conflict.Detail, tc.want)
+			}
+		})
+	}
+}
+
+func TestBindAddrsOverlap(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want bool
+	}{
+		{"127.0.0.1", "127.0.0.1", true},
+		{"127.0.0.1", "192.168.1.5", false},
+		{"0.0.0.0", "192.168.1.5", true},
+		{"::", "127.0.0.1", true},
+		{"", "10.0.0.1", true},
+	}
+	for _, tc := range cases {
+		if got := bindAddrsOverlap(tc.a, tc.b); got != tc.want {
+			t.Errorf("bindAddrsOverlap(%q, %q) = %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestAddFrpVisitorWildcardBindConflict(t *testing.T) {
+	existing := "serverAddr = \"frps.example.com\"\n\n" +
+		"[[visitors]]\nname = \"any\"\ntype = \"stcp\"\nserverName = \"s\"\nsecretKey = \"k\"\nbindAddr = \"0.0.0.0\"\nbindPort = 9000\n\n" +
+		"[[visitors]]\nname = \"loopback\"\ntype = \"stcp\"\nserverName = \"s\"\nsecretKey = \"k\"\nbindPort = 9001\n"
+
+	cases := []struct {
+		name, bindAddr, bindPort string
+		want                     int
+	}{
+		{"specific address under a wildcard", "127.0.0.1", "9000", http.StatusConflict},
+		{"wildcard over the default loopback", "0.0.0.0", "9001", http.StatusConflict},
+		{"ipv6 wildcard", "::", "9001", http.StatusConflict},
+		{"another address, same port", "192.168.1.5", "9001", http.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			writeTestToml(t, existing)
+			body := fmt.Sprintf(`{"name":"new","type":"stcp","serverName":"s","secretKey":"k","bindAddr":%q,"bindPort":%q}`, tc.bindAddr, tc.bindPort)
+			req := httptest.NewRequest("POST", "/api/frp/visitors/add", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			handleAddFrpVisitor(rec, req)
+			if rec.Code != tc.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tc.want, rec.Body)
+			}
+		})
+	}
+}
+
+func TestSupportBundleRedactsManagedProxies(t *testing.T) {
+	path := writeTestToml(t, "serverAddr = \"frps.example.com\"\nauth.token = \"tok3n\"\n")
+	config.ManagedProxiesFile = "managed.toml"
+	managed := "[[proxies]]\nname = \"s\"\ntype = \"stcp\"\nsecretKey = \"topsecret\"\nlocalPort = 22\n"
+	if err := os.WriteFile(filepath.Join(filepath.Dir(path), "managed.toml"), []byte(managed), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	handleSupportBundle(rec, httptest.NewRequest("GET", "/api/support-bundle", nil))
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		for _, secret := range []string{"topsecret", "tok3n"} {
+			if strings.Contains(string(content), secret) {
+				t.Errorf("%s contains %q", f.Name, secret)
+			}
+		}
+		if f.Name == "managed-proxies.toml" {
+			found = true
+			if !strings.Contains(string(content), "secretKey") {
+				t.Errorf("managed-proxies.toml lost the redacted key:\n%s", content)
+			}
+		}
+	}
+	if !found {
+		t.Error("bundle has no managed-proxies.toml")
+	}
+}
+
+func TestAddRuleFailsWhenProxiesUnreadable(t *testing.T) {
+	// A directory in place of frpc.toml makes every read fail
+	setTestConfig(t, Config{FrpcTomlPath: t.TempDir()})
+	body := `{"listenPort":"8081","connectAddr":"10.0.0.5","connectPort":"80","remotePort":"18081","type":"tcp"}`
+	req := httptest.NewRequest("POST", "/api/add", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	handleAddRule(rec, req)
+	if rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), "读取 FRP 代理失败") {
+		t.Errorf("status = %d, body %q; want a proxy read failure", rec.Code, rec.Body)
+	}
+}
+
+func TestDeleteNetshRuleCascadeFailureKeepsSuccess(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.WriteFile(rulesMetaFile, []byte(`{"0.0.0.0:8080":{"proxyName":"web"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// frpc.toml is missing, so deleting the linked proxy fails
+	setTestConfig(t, Config{FrpcTomlPath: filepath.Join(t.TempDir(), "frpc.toml")})
+
+	req := httptest.NewRequest("POST", "/api/netsh/delete", strings.NewReader(`{"listenPort":"8080","cascade":true}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	handleDeleteNetshRule(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+
+	var result map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result["netshDeleted"] != true || result["cascaded"] != false || result["proxyError"] == nil {
+		t.Errorf("result = %v, want netshDeleted, cascaded false and a proxyError", result)
+	}
+	if name := linkedProxyName("0.0.0.0", "8080"); name != "" {
+		t.Errorf("rule meta still links %q", name)
+	}
+}
+
+func TestAuthMiddlewareLoopback(t *testing.T) {
+	const plainToml = "serverAddr = \"frps.example.com\"\n"
+	const exposedToml = plainToml + "\n[[proxies]]\nname = \"default-web\"\ntype = \"tcp\"\nlocalIP = \"127.0.0.1\"\nlocalPort = 8080\nremotePort = 18080\n"
+
+	cases := []struct {
+		name       string
+		toml       string
+		autoReg    bool
+		token      string
+		remoteAddr string
+		authHeader string
+		want       int
+	}{
+		{"loopback, not exposed", plainToml, false, "", "127.0.0.1:5000", "", http.StatusOK},
+		{"remote, not exposed", plainToml, false, "", "192.168.1.20:5000", "", http.StatusForbidden},
+		{"loopback, auto-register", plainToml, true, "", "127.0.0.1:5000", "", http.StatusForbidden},
+		{"loopback, registered manually", exposedToml, false, "", "127.0.0.1:5000", "", http.StatusForbidden},
+		{"exposed with token", exposedToml, true, "t0k", "127.0.0.1:5000", "Bearer t0k", http.StatusOK},
+		{"exposed with wrong token", exposedToml, true, "t0k", "127.0.0.1:5000", "Bearer nope", http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			writeTestToml(t, tc.toml)
+			config.Name, config.WebUIProxyName = "default", "web"
+			config.AutoRegisterToFrp, config.AuthToken = tc.autoReg, tc.token
+
+			req := httptest.NewRequest("GET", "/api/rules/raw", nil)
+			req.RemoteAddr = tc.remoteAddr
+			if tc.authHeader != "" {
+				req.Header.Set("Authorization", tc.authHeader)
+			}
+			rec := httptest.NewRecorder()
+			authMiddleware(func(w http.ResponseWriter, r *http.Request) {})(rec, req)
+			if rec.Code != tc.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tc.want, rec.Body)
+			}
+		})
+	}
+}
+
+func TestAddRangeRejectsConflicts(t *testing.T) {
+	t.Chdir(t.TempDir())
+	const existing = "serverAddr = \"frps.example.com\"\n\n[[proxies]]\nname = \"old\"\ntype = \"tcp\"\nlocalPort = 9000\nremotePort = 19001\n"
+
+	cases := []struct {
+		name string
+		body string
+		want int
+	}{
+		// mockRules already listens on 8080
+		{"existing netsh listener", `{"listenPortStart":8079,"listenPortEnd":8081,"connectAddr":"10.0.0.5","remotePortStart":18079}`, http.StatusConflict},
+		{"existing remote port", `{"listenPortStart":9000,"listenPortEnd":9002,"connectAddr":"10.0.0.5","remotePortStart":19000}`, http.StatusConflict},
+		{"invalid generated rule", `{"listenPortStart":9000,"listenPortEnd":9001,"connectAddr":"10.0.0.5\"","remotePortStart":19100}`, http.StatusBadRequest},
+		{"free range", `{"listenPortStart":9000,"listenPortEnd":9002,"connectAddr":"10.0.0.5","remotePortStart":19100}`, http.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := writeTestToml(t, existing)
+			req := httptest.NewRequest("POST", "/api/add-range", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			handleAddRange(rec, req)
+			if rec.Code != tc.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tc.want, rec.Body)
+			}
+
+			content, _ := os.ReadFile(path)
+			added := strings.Count(string(content), "[[proxies]]") - 1
+			if tc.want == http.StatusOK {
+				if added != 3 || rec.Header().Get("Content-Type") != "application/json" {
+					t.Errorf("added %d proxies, Content-Type %q", added, rec.Header().Get("Content-Type"))
+				}
+			} else if added != 0 {
+				t.Errorf("rejected range still added %d proxies", added)
+			}
+		})
+	}
+}
+
+func TestUniquenessChecksSeeBothProxyFiles(t *testing.T) {
+	path := writeTestToml(t, "serverAddr = \"frps.example.com\"\n\n[[proxies]]\nname = \"legacy\"\ntype = \"tcp\"\nlocalPort = 22\nremotePort = 19001\n")
+	config.ManagedProxiesFile = "managed.toml"
+	config.RemotePortPool = []string{"19001-19002"}
+	managed := "[[proxies]]\nname = \"managed\"\ntype = \"tcp\"\nlocalPort = 80\nremotePort = 19003\n"
+	if err := os.WriteFile(filepath.Join(filepath.Dir(path), "managed.toml"), []byte(managed), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if proxies, _ := getFrpProxies(); len(proxies) != 1 || proxies[0].Name != "managed" {
+		t.Errorf("getFrpProxies = %v, want only the managed file", proxies)
+	}
+	for _, name := range []string{"legacy", "managed"} {
+		if taken, err := frpProxyNameTaken(name); err != nil || !taken {
+			t.Errorf("frpProxyNameTaken(%q) = %v, %v; want true", name, taken, err)
+		}
+	}
+	if err := copyFrpProxy("managed", "legacy", "19010"); err == nil {
+		t.Error("copy to a name used in frpc.toml succeeded")
+	}
+	if err := copyFrpProxy("managed", "copy", "19001"); err == nil {
+		t.Error("copy to a remotePort used in frpc.toml succeeded")
+	}
+	if err := renameFrpProxy("managed", "legacy"); err == nil {
+		t.Error("rename to a name used in frpc.toml succeeded")
+	}
+
+	port, release, err := assignRemotePort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	if port != "19002" {
+		t.Errorf("assignRemotePort = %s, want 19002", port)
+	}
+}
+
+func TestEnvelopeWriter(t *testing.T) {
+	cases := []struct {
+		name     string
+		handler  http.HandlerFunc
+		status   int
+		wantBody string
+		wantCode string
+	}{
+		{"json success", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"a":1}`))
+		}, http.StatusOK, `{"ok":true,"apiVersion":2,"data":{"a":1}}`, ""},
+		{"raw array", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`[1,2]`))
+		}, http.StatusOK, `{"ok":true,"apiVersion":2,"data":[1,2]}`, ""},
+		{"text passes through", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("hello"))
+		}, http.StatusOK, "hello", ""},
+		{"http.Error", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "boom", http.StatusInternalServerError)
+		}, http.StatusInternalServerError, "", "internal_server_error"},
+		{"catalog error", func(w http.ResponseWriter, r *http.Request) {
+			writeMsgError(w, r, http.StatusConflict, "proxy_name_taken", "web")
+		}, http.StatusConflict, "", "proxy_name_taken"},
+		{"validation error", func(w http.ResponseWriter, r *http.Request) {
+			writeError(w, r, validatePort("listenPort", "0"), http.StatusBadRequest)
+		}, http.StatusBadRequest, "", "invalid_port"},
+		{"json error body", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write([]byte(`{"status":"error","code":"connect_check_failed","message":"m"}`))
+		}, http.StatusUnprocessableEntity, "", "connect_check_failed"},
+		{"empty 200", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}, http.StatusOK, `{"ok":true,"apiVersion":2,"data":null}`, ""},
+		{"empty 204", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}, http.StatusNoContent, "", ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/test?lang=en", nil)
+			req.Header.Set("X-API-Version", "2")
+			rec := httptest.NewRecorder()
+			envelopeMiddleware(tc.handler).ServeHTTP(rec, req)
+
+			if rec.Code != tc.status {
+				t.Errorf("status = %d, want %d", rec.Code, tc.status)
+			}
+			body := strings.TrimSpace(rec.Body.String())
+			if tc.wantCode == "" {
+				if body != tc.wantBody {
+					t.Errorf("body = %q, want %q", body, tc.wantBody)
+				}
+				return
+			}
+
+			var env struct {
+				OK    bool          `json:"ok"`
+				Error EnvelopeError `json:"error"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &env); err != nil {
+				t.Fatalf("body %q: %v", body, err)
+			}
+			if env.OK || env.Error.Status != tc.status || env.Error.Code != tc.wantCode || env.Error.Message == "" {
+				t.Errorf("error = %+v, want status %d and code %q", env.Error, tc.status, tc.wantCode)
+			}
+		})
+	}
+}
+
+func TestStopFrpcDelayParameter(t *testing.T) {
+	cases := []struct {
+		query       string
+		wantStatus  int
+		wantDelayed bool
+	}{
+		{"", http.StatusOK, false},
+		{"?delay=1ms", http.StatusOK, true},
+		{"?drain=1ms", http.StatusOK, true},
+		{"?drain=10m", http.StatusBadRequest, false},
+		{"?delay=bogus", http.StatusBadRequest, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.query, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handleStopFrpc(rec, httptest.NewRequest("POST", "/api/frpc/stop"+tc.query, nil))
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tc.wantStatus, rec.Body)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+			var result map[string]interface{}
+			json.Unmarshal(rec.Body.Bytes(), &result)
+			if result["delayed"] != tc.wantDelayed {
+				t.Errorf("delayed = %v, want %v", result["delayed"], tc.wantDelayed)
+			}
+		})
+	}
+}
+
+func TestCheckProxyNameLocalizesReason(t *testing.T) {
+	writeTestToml(t, "[[proxies]]\nname = \"web\"\ntype = \"tcp\"\nlocalPort = 80\n")
+	cases := []struct{ query, want string }{
+		{"?name=web&lang=en", "Proxy name already exists: web"},
+		{"?name=web", "代理名称已存在: web"},
+		{"?name=&lang=en", "name must not be empty"},
+	}
+	for _, tc := range cases {
+		rec := httptest.NewRecorder()
+		handleCheckProxyName(rec, httptest.NewRequest("GET", "/api/frp/check-name"+tc.query, nil))
+		var result map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &result)
+		if result["available"] != false || result["reason"] != tc.want {
+			t.Errorf("%s: result = %v, want reason %q", tc.query, result, tc.want)
+		}
+	}
+}
+
+func TestRestartScheduleNext(t *testing.T) {
+	at := func(s string) time.Time {
+		v, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	// 2026-01-09 is a Friday
+	cases := []struct {
+		name, spec, from, want string
+	}{
+		{"HH:MM later today", "04:30", "2026-01-10 01:00", "2026-01-10 04:30"},
+		{"HH:MM tomorrow", "04:30", "2026-01-10 05:00", "2026-01-11 04:30"},
+		{"strictly after", "04:30", "2026-01-10 04:30", "2026-01-11 04:30"},
+		{"hour and weekday ranges", "0 9-17 * * 1-5", "2026-01-09 17:30", "2026-01-12 09:00"},
+		{"step from a start value", "5/10 * * * *", "2026-01-09 12:00", "2026-01-09 12:05"},
+		{"step across the hour", "5/10 * * * *", "2026-01-09 12:55", "2026-01-09 13:05"},
+		{"star step", "*/15 * * * *", "2026-01-09 12:16", "2026-01-09 12:30"},
+		{"list", "0 6,18 * * *", "2026-01-09 07:00", "2026-01-09 18:00"},
+		{"7 is Sunday", "0 0 * * 7", "2026-01-09 12:00", "2026-01-11 00:00"},
+		{"0 is Sunday", "0 0 * * 0", "2026-01-09 12:00", "2026-01-11 00:00"},
+		{"day fields OR: weekday first", "0 0 15 * 1", "2026-01-09 12:00", "2026-01-12 00:00"},
+		{"day fields OR: day of month first", "0 0 15 * 1", "2026-01-12 00:00", "2026-01-15 00:00"},
+		{"starred step day field ANDs", "0 0 */2 * 1", "2026-01-09 12:00", "2026-01-19 00:00"},
+		{"month rollover", "0 0 1 * *", "2026-01-31 12:00", "2026-02-01 00:00"},
+		{"year rollover", "0 0 1 1 *", "2026-06-01 00:00", "2027-01-01 00:00"},
+		{"leap day", "0 0 29 2 *", "2027-03-01 00:00", "2028-02-29 00:00"},
+		{"never matches", "0 0 31 2 *", "2026-01-09 12:00", ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			schedule, err := parseRestartSchedule(tc.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := schedule.next(at(tc.from))
+			if tc.want == "" {
+				if !got.IsZero() {
+					t.Errorf("next = %v, want the zero time", got)
+				}
+				return
+			}
+			if !got.Equal(at(tc.want)) {
+				t.Errorf("next(%s) = %s, want %s", tc.from, got.Format("2006-01-02 15:04 Mon"), tc.want)
+			}
+		})
+	}
+}
+
+func TestParseRestartScheduleRejects(t *testing.T) {
+	for _, spec := range []string{
+		"", "25:00", "* * *", "* * * * * *",
+		"60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8",
+		"*/0 * * * *", "5-1 * * * *", "a * * * *", "1-x * * * *",
+	} {
+		if _, err := parseRestartSchedule(spec); err == nil {
+			t.Errorf("parseRestartSchedule(%q) accepted", spec)
+		}
+	}
+}
+
+func TestErrTextLocalizesValidationErrors(t *testing.T) {
+	err := validateAddRuleRequest(AddRuleRequest{ListenPort: "99999", ConnectAddr: "10.0.0.5", ConnectPort: "80"})
+	if err == nil {
+		t.Fatal("invalid listenPort accepted")
+	}
+	if got := err.Error(); !strings.Contains(got, "端口号") {
+		t.Errorf("Error() = %q, want the default-language text", got)
+	}
+
+	en := httptest.NewRequest("POST", "/api/add?lang=en", nil)
+	if got := errText(en, err); !strings.Contains(got, "must be a port number") {
+		t.Errorf("errText(en) = %q, want English", got)
+	}
+	zh := httptest.NewRequest("POST", "/api/add", nil)
+	if got := errText(zh, err); got != err.Error() {
+		t.Errorf("errText(zh) = %q, want %q", got, err.Error())
+	}
+
+	// nested localized errors follow the outer request's language
+	wrapped := msgError("proxy_error", "web", msgError("secret_key_required", "stcp"))
+	if got := errText(en, wrapped); got != "web: stcp proxies require a secretKey" {
+		t.Errorf("errText(en, wrapped) = %q", got)
+	}
+	// plain errors (netsh output, OS errors) pass through untouched
+	if got := errText(en, fmt.Errorf("netsh 退出码 1")); got != "netsh 退出码 1" {
+		t.Errorf("errText(plain) = %q", got)
+	}
+}
+
+func TestMessageCatalogsMatch(t *testing.T) {
+	for id := range messageCatalogs[defaultLang] {
+		if _, ok := messageCatalogs["en"][id]; !ok {
+			t.Errorf("%s missing from the en catalog", id)
+		}
+	}
+	for id := range messageCatalogs["en"] {
+		if _, ok := messageCatalogs[defaultLang][id]; !ok {
+			t.Errorf("%s missing from the %s catalog", id, defaultLang)
+		}
+	}
+}
+
+// benchProxyCount is the size of the large frpc.toml the benchmarks use
+const benchProxyCount = 10000
+
+// largeFrpcToml renders a frpc.toml with n proxies
+func largeFrpcToml(n int) string {
+	var sb strings.Builder
+	sb.WriteString("serverAddr = \"example.com\"\nserverPort = 7000\n")
+	for i := range n {
+		fmt.Fprintf(&sb, "\n[[proxies]]\nname = \"bench-it-10.0.%d.%d-22\"\ntype = \"tcp\"\nlocalIP = \"127.0.0.1\"\nlocalPort = %d\nremotePort = %d\n",
+			i/256, i%256, 10000+i%50000, 20000+i%40000)
+	}
+	return sb.String()
+}
+
+func BenchmarkParseFrpProxies(b *testing.B) {
+	content := largeFrpcToml(benchProxyCount)
+	b.SetBytes(int64(len(content)))
+	b.ReportAllocs()
+	for b.Loop() {
+		proxies, err := parseFrpProxies(strings.NewReader(content))
+		if err != nil || len(proxies) != benchProxyCount {
+			b.Fatalf("parsed %d proxies, err %v", len(proxies), err)
+		}
+	}
+}
+
+func BenchmarkDeleteFrpProxy(b *testing.B) {
+	content := largeFrpcToml(benchProxyCount)
+	path := filepath.Join(b.TempDir(), "frpc.toml")
+	saved := config
+	config = Config{FrpcTomlPath: path}
+	b.Cleanup(func() { config = saved })
+
+	b.SetBytes(int64(len(content)))
+	b.ReportAllocs()
+	for b.Loop() {
+		b.StopTimer()
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+		if err := deleteFrpProxy("bench-it-10.0.19.136-22"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
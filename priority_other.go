@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import "os/exec"
+
+// setPriorityClass is a no-op on non-Windows platforms
+func setPriorityClass(cmd *exec.Cmd, priority string) {
+	// Nothing to do on non-Windows platforms
+}
+
+// setProcessAffinity is a no-op on non-Windows platforms
+func setProcessAffinity(pid int, mask uint64) error {
+	return nil
+}
@@ -0,0 +1,45 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"syscall"
+)
+
+// priorityClassFlags maps frpcPriority values to CreateProcess priority flags
+var priorityClassFlags = map[string]uint32{
+	"idle":         0x00000040, // IDLE_PRIORITY_CLASS
+	"below_normal": 0x00004000, // BELOW_NORMAL_PRIORITY_CLASS
+	"normal":       0x00000020, // NORMAL_PRIORITY_CLASS
+	"above_normal": 0x00008000, // ABOVE_NORMAL_PRIORITY_CLASS
+	"high":         0x00000080, // HIGH_PRIORITY_CLASS
+}
+
+var procSetProcessAffinityMask = syscall.NewLazyDLL("kernel32.dll").NewProc("SetProcessAffinityMask")
+
+// setPriorityClass makes cmd start with the given priority class. It must be
+// called after hideWindow, which replaces SysProcAttr.
+func setPriorityClass(cmd *exec.Cmd, priority string) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= priorityClassFlags[priority]
+}
+
+// setProcessAffinity restricts a running process to the CPUs in mask
+func setProcessAffinity(pid int, mask uint64) error {
+	const processSetInformation = 0x0200
+	handle, err := syscall.OpenProcess(processSetInformation, false, uint32(pid))
+	if err != nil {
+		return err
+	}
+	defer syscall.CloseHandle(handle)
+
+	if ok, _, err := procSetProcessAffinityMask.Call(uintptr(handle), uintptr(mask)); ok == 0 {
+		return fmt.Errorf("SetProcessAffinityMask: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import "errors"
+
+// findProcessesByName is only implemented on Windows
+func findProcessesByName(name string) ([]int, error) {
+	return nil, errors.New("not supported on this platform")
+}
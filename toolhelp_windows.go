@@ -0,0 +1,39 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// findProcessesByName lists the PIDs of processes whose image name matches
+// name (case-insensitively) using a Toolhelp32 snapshot, without tasklist
+func findProcessesByName(name string) ([]int, error) {
+	snapshot, err := syscall.CreateToolhelp32Snapshot(syscall.TH32CS_SNAPPROCESS, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.CloseHandle(snapshot)
+
+	var entry syscall.ProcessEntry32
+	entry.Size = uint32(unsafe.Sizeof(entry))
+	if err := syscall.Process32First(snapshot, &entry); err != nil {
+		return nil, err
+	}
+
+	var pids []int
+	for {
+		if strings.EqualFold(syscall.UTF16ToString(entry.ExeFile[:]), name) {
+			pids = append(pids, int(entry.ProcessID))
+		}
+		if err := syscall.Process32Next(snapshot, &entry); err != nil {
+			if err == syscall.ERROR_NO_MORE_FILES {
+				return pids, nil
+			}
+			return pids, err
+		}
+	}
+}